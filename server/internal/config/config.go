@@ -4,6 +4,8 @@ import (
 	"os"
 	"strconv"
 	"time"
+
+	"tavily-proxy/server/internal/util"
 )
 
 type Config struct {
@@ -11,6 +13,30 @@ type Config struct {
 	DatabasePath    string
 	TavilyBaseURL   string
 	UpstreamTimeout time.Duration
+
+	// Request logs and stats are written in batches off the request path.
+	LogQueueSize     int
+	LogBatchSize     int
+	LogFlushInterval time.Duration
+
+	// MetricsRequireAuth puts /metrics behind the master key.
+	MetricsRequireAuth bool
+
+	// TracingEndpoint is the OTLP/HTTP collector URL; empty disables export.
+	TracingEndpoint    string
+	TracingSampleRatio float64
+
+	// ReadyMinKeys is the number of usable upstream keys /readyz requires.
+	ReadyMinKeys int
+	// HealthMaxSyncAge fails the auto-sync check once the last successful sync
+	// is older than this; zero means three sync intervals.
+	HealthMaxSyncAge time.Duration
+
+	// StatsTimezone is the IANA zone stats are bucketed and reported in, and
+	// QuotaResetTimezone the one whose midnight on the 1st resets key quotas.
+	// Empty means the server's local zone.
+	StatsTimezone      string
+	QuotaResetTimezone string
 }
 
 func FromEnv() Config {
@@ -29,7 +55,32 @@ func FromEnv() Config {
 		DatabasePath:    dbPath,
 		TavilyBaseURL:   baseURL,
 		UpstreamTimeout: timeout,
+
+		LogQueueSize:     getenvInt("LOG_QUEUE_SIZE", 10000),
+		LogBatchSize:     getenvInt("LOG_BATCH_SIZE", 200),
+		LogFlushInterval: getenvDuration("LOG_FLUSH_INTERVAL", 500*time.Millisecond),
+
+		MetricsRequireAuth: getenvBool("METRICS_REQUIRE_AUTH", false),
+
+		TracingEndpoint:    getenvFirst("", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_ENDPOINT"),
+		TracingSampleRatio: getenvFloat("OTEL_TRACES_SAMPLE_RATIO", 1),
+
+		ReadyMinKeys:     getenvInt("READY_MIN_KEYS", 1),
+		HealthMaxSyncAge: getenvDuration("HEALTH_MAX_SYNC_AGE", 0),
+
+		StatsTimezone:      getenv("STATS_TIMEZONE", ""),
+		QuotaResetTimezone: getenv("QUOTA_RESET_TIMEZONE", ""),
+	}
+}
+
+// Location resolves an IANA timezone name such as "Asia/Shanghai"; empty means
+// the server's local zone, under its own name where one can be found.
+func Location(name string) (*time.Location, error) {
+	if name == "" {
+		loc, _ := util.LocalLocation()
+		return loc, nil
 	}
+	return time.LoadLocation(name)
 }
 
 func getenv(key, def string) string {
@@ -59,3 +110,30 @@ func getenvDuration(key string, def time.Duration) time.Duration {
 	}
 	return def
 }
+
+func getenvInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func getenvBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+func getenvFloat(key string, def float64) float64 {
+	if v := os.Getenv(key); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
@@ -20,8 +20,19 @@ func Open(path string) (*gorm.DB, error) {
 		return nil, err
 	}
 
-	if err := database.AutoMigrate(&models.APIKey{}, &models.RequestLog{}, &models.RequestStat{}, &models.Setting{}); err != nil {
+	if err := database.AutoMigrate(&models.APIKey{}, &models.RequestLog{}, &models.RequestStat{}, &models.Setting{}, &models.ClientKey{}, &models.ResponseCacheEntry{}, &models.RequestAttempt{}, &models.NotificationTarget{}, &models.NotificationDelivery{}, &models.KeyUsageSnapshot{}); err != nil {
 		return nil, err
 	}
+
+	// request_stats used to be unique on (granularity, bucket, endpoint) only,
+	// which would reject the per-key and per-status rows, and then on those
+	// dimensions without bucket_start, which would merge a repeated DST hour.
+	for _, index := range []string{"idx_request_stat_bucket", "idx_request_stat_dims"} {
+		if database.Migrator().HasIndex(&models.RequestStat{}, index) {
+			if err := database.Migrator().DropIndex(&models.RequestStat{}, index); err != nil {
+				return nil, err
+			}
+		}
+	}
 	return database, nil
 }
@@ -0,0 +1,203 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tavily-proxy/server/internal/jobs"
+	"tavily-proxy/server/internal/services"
+)
+
+const (
+	checkOK       = "ok"
+	checkFail     = "fail"
+	checkDisabled = "disabled"
+
+	healthCheckTimeout = 3 * time.Second
+)
+
+// healthCheck is one entry of a /readyz or verbose /healthz report. Critical
+// checks decide readiness; the rest only affect the verbose health status.
+type healthCheck struct {
+	Status   string         `json:"status"`
+	Critical bool           `json:"critical"`
+	Error    string         `json:"error,omitempty"`
+	Details  map[string]any `json:"details,omitempty"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks"`
+}
+
+func handleHealthz(c *gin.Context, deps Dependencies) {
+	if v := c.Query("verbose"); v == "" || v == "0" || v == "false" {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+		return
+	}
+	report := runHealthChecks(c.Request.Context(), deps)
+	report.Status = checkOK
+	for _, check := range report.Checks {
+		if check.Status == checkFail {
+			report.Status = checkFail
+		}
+	}
+	writeHealthReport(c, report)
+}
+
+// handleReadyz reports whether this instance can serve proxy traffic: the
+// database answers and enough upstream keys are available.
+func handleReadyz(c *gin.Context, deps Dependencies) {
+	report := runHealthChecks(c.Request.Context(), deps)
+	report.Status = checkOK
+	for _, check := range report.Checks {
+		if check.Critical && check.Status == checkFail {
+			report.Status = checkFail
+		}
+	}
+	writeHealthReport(c, report)
+}
+
+func writeHealthReport(c *gin.Context, report healthReport) {
+	status := http.StatusOK
+	if report.Status != checkOK {
+		status = http.StatusServiceUnavailable
+	}
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, report)
+}
+
+func runHealthChecks(ctx context.Context, deps Dependencies) healthReport {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	now := time.Now()
+	return healthReport{Checks: map[string]healthCheck{
+		"database":   checkDatabase(ctx, deps.SettingsService),
+		"keys":       checkKeys(ctx, deps.KeyService, deps.Config.ReadyMinKeys),
+		"auto_sync":  checkAutoSync(ctx, deps.SettingsService, deps.Config.HealthMaxSyncAge, now),
+		"jobs":       checkJobs(deps.Jobs, now),
+		"log_writer": checkLogWriter(deps.LogWriter),
+	}}
+}
+
+func checkDatabase(ctx context.Context, settings *services.SettingsService) healthCheck {
+	start := time.Now()
+	check := healthCheck{Status: checkOK, Critical: true}
+	if err := settings.Ping(ctx); err != nil {
+		check.Status = checkFail
+		check.Error = err.Error()
+	}
+	check.Details = map[string]any{"latency_ms": time.Since(start).Milliseconds()}
+	return check
+}
+
+func checkKeys(ctx context.Context, keys *services.KeyService, minKeys int) healthCheck {
+	check := healthCheck{Status: checkOK, Critical: true}
+	available, err := keys.AvailableCount(ctx)
+	if err != nil {
+		check.Status = checkFail
+		check.Error = err.Error()
+		return check
+	}
+	check.Details = map[string]any{"available": available, "min": minKeys}
+	if available < minKeys {
+		check.Status = checkFail
+		check.Error = "not_enough_available_keys"
+	}
+	return check
+}
+
+// checkAutoSync fails when auto-sync is enabled but has not succeeded within
+// maxAge, or within three sync intervals when maxAge is zero. A sync that has
+// never run yet, such as right after enabling it, is not a failure.
+func checkAutoSync(ctx context.Context, settings *services.SettingsService, maxAge time.Duration, now time.Time) healthCheck {
+	check := healthCheck{Status: checkOK}
+	enabled, err := settings.GetBool(ctx, services.SettingAutoSyncEnabled, false)
+	if err != nil {
+		check.Status = checkFail
+		check.Error = err.Error()
+		return check
+	}
+	if !enabled {
+		check.Status = checkDisabled
+		return check
+	}
+
+	if maxAge <= 0 {
+		intervalMinutes, err := settings.GetInt(ctx, services.SettingAutoSyncIntervalMinutes, 60)
+		if err != nil {
+			check.Status = checkFail
+			check.Error = err.Error()
+			return check
+		}
+		if intervalMinutes < 1 {
+			intervalMinutes = 1
+		}
+		maxAge = 3 * time.Duration(intervalMinutes) * time.Minute
+	}
+	check.Details = map[string]any{"max_age_seconds": int64(maxAge.Seconds())}
+
+	lastRunAt, _ := settings.GetTime(ctx, services.SettingAutoSyncLastRunAt)
+	lastSuccessAt, err := settings.GetTime(ctx, services.SettingAutoSyncLastSuccessAt)
+	if err != nil {
+		check.Status = checkFail
+		check.Error = err.Error()
+		return check
+	}
+	if lastError, _, _ := settings.Get(ctx, services.SettingAutoSyncLastError); lastError != "" {
+		check.Details["last_error"] = lastError
+	}
+	if lastSuccessAt == nil {
+		if lastRunAt != nil {
+			check.Status = checkFail
+			check.Error = "never_succeeded"
+		}
+		return check
+	}
+
+	age := now.Sub(*lastSuccessAt)
+	check.Details["last_success_at"] = lastSuccessAt.UTC().Format(time.RFC3339)
+	check.Details["age_seconds"] = int64(age.Seconds())
+	if age > maxAge {
+		check.Status = checkFail
+		check.Error = "stale"
+	}
+	return check
+}
+
+func checkJobs(monitor *jobs.Monitor, now time.Time) healthCheck {
+	check := healthCheck{Status: checkOK}
+	statuses := monitor.Status(now)
+	stale := []string{}
+	for _, job := range statuses {
+		if job.Stale {
+			stale = append(stale, job.Name)
+		}
+	}
+	if len(stale) > 0 {
+		check.Status = checkFail
+		check.Error = "stale_jobs"
+	}
+	check.Details = map[string]any{"jobs": statuses, "stale": stale}
+	return check
+}
+
+// checkLogWriter fails while the log queue is full and entries are being dropped.
+func checkLogWriter(w *services.LogWriter) healthCheck {
+	check := healthCheck{Status: checkOK}
+	if w == nil {
+		check.Status = checkDisabled
+		return check
+	}
+	stats := w.Stats()
+	check.Details = map[string]any{"queue_depth": stats.QueueDepth, "queue_capacity": stats.QueueCapacity, "dropped": stats.Dropped}
+	if stats.QueueCapacity > 0 && stats.QueueDepth >= stats.QueueCapacity {
+		check.Status = checkFail
+		check.Error = "queue_full"
+	}
+	return check
+}
@@ -2,6 +2,7 @@ package httpserver
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -16,25 +17,37 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"tavily-proxy/server/internal/mcpserver"
+	"tavily-proxy/server/internal/models"
 	"tavily-proxy/server/internal/services"
 	"tavily-proxy/server/internal/util"
+
+	"gorm.io/gorm"
 )
 
 func NewRouter(deps Dependencies) http.Handler {
 	r := gin.New()
-	r.Use(gin.Logger(), gin.Recovery())
+	r.Use(gin.Logger(), gin.Recovery(), tracingMiddleware())
 
 	publicFS, _ := fs.Sub(deps.EmbeddedPublic, "public")
 
 	mcpHandler := mcpserver.NewHandler(mcpserver.Dependencies{
-		MasterKey: deps.MasterKeyService,
-		Proxy:     deps.TavilyProxy,
+		MasterKey:  deps.MasterKeyService,
+		ClientKeys: deps.ClientKeyService,
+		Proxy:      deps.TavilyProxy,
 	})
 	r.Any("/mcp", gin.WrapH(mcpHandler))
 
-	r.GET("/healthz", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"ok": true})
-	})
+	r.GET("/healthz", func(c *gin.Context) { handleHealthz(c, deps) })
+	r.GET("/readyz", func(c *gin.Context) { handleReadyz(c, deps) })
+
+	if deps.Metrics != nil {
+		metricsHandler := gin.WrapH(deps.Metrics.Handler())
+		if deps.Config.MetricsRequireAuth {
+			r.GET("/metrics", masterAuthMiddleware(deps.MasterKeyService), metricsHandler)
+		} else {
+			r.GET("/metrics", metricsHandler)
+		}
+	}
 
 	api := r.Group("/api", masterAuthMiddleware(deps.MasterKeyService))
 	{
@@ -46,14 +59,37 @@ func NewRouter(deps Dependencies) http.Handler {
 		api.POST("/keys/sync", func(c *gin.Context) { handleStartSyncAllKeys(c, deps.QuotaSyncJob) })
 		api.DELETE("/keys/invalid", func(c *gin.Context) { handleDeleteInvalidKeys(c, deps.KeyService) })
 		api.PUT("/keys/:id", func(c *gin.Context) { handleUpdateKey(c, deps, c.Param("id")) })
+		api.POST("/keys/:id/revalidate", func(c *gin.Context) { handleRevalidateKey(c, deps, c.Param("id")) })
 		api.DELETE("/keys/:id", func(c *gin.Context) { handleDeleteKey(c, deps.KeyService, c.Param("id")) })
 
+		api.GET("/client-keys", func(c *gin.Context) { handleListClientKeys(c, deps.ClientKeyService) })
+		api.POST("/client-keys", func(c *gin.Context) { handleCreateClientKey(c, deps.ClientKeyService) })
+		api.PUT("/client-keys/:id", func(c *gin.Context) { handleUpdateClientKey(c, deps.ClientKeyService, c.Param("id")) })
+		api.POST("/client-keys/:id/rotate", func(c *gin.Context) { handleRotateClientKey(c, deps.ClientKeyService, c.Param("id")) })
+		api.DELETE("/client-keys/:id", func(c *gin.Context) { handleDeleteClientKey(c, deps.ClientKeyService, c.Param("id")) })
+
+		api.GET("/notifications", func(c *gin.Context) { handleListNotificationTargets(c, deps.Notifications) })
+		api.POST("/notifications", func(c *gin.Context) { handleCreateNotificationTarget(c, deps.Notifications) })
+		api.GET("/notifications/deliveries", func(c *gin.Context) { handleListNotificationDeliveries(c, deps.Notifications) })
+		api.PUT("/notifications/:id", func(c *gin.Context) { handleUpdateNotificationTarget(c, deps.Notifications, c.Param("id")) })
+		api.DELETE("/notifications/:id", func(c *gin.Context) { handleDeleteNotificationTarget(c, deps.Notifications, c.Param("id")) })
+		api.POST("/notifications/:id/test", func(c *gin.Context) { handleTestNotificationTarget(c, deps.Notifications, c.Param("id")) })
+
 		api.GET("/logs/status-codes", func(c *gin.Context) { handleLogStatusCodes(c, deps.LogService) })
 		api.GET("/logs", func(c *gin.Context) { handleListLogs(c, deps.LogService) })
+		api.GET("/logs/export", func(c *gin.Context) { handleExportLogs(c, deps.LogService) })
+		api.GET("/logs/:request_id/attempts", func(c *gin.Context) { handleListLogAttempts(c, deps.LogService, c.Param("request_id")) })
 		api.DELETE("/logs", func(c *gin.Context) { handleClearLogs(c, deps.LogService) })
 		api.GET("/stats", func(c *gin.Context) { handleStats(c, deps.StatsService) })
 		api.GET("/stats/timeseries", func(c *gin.Context) { handleTimeSeries(c, deps.StatsService) })
-
+		api.GET("/stats/forecast", func(c *gin.Context) { handleForecast(c, deps.StatsService) })
+		api.GET("/stats/keys/leaderboard", func(c *gin.Context) { handleKeyLeaderboard(c, deps.StatsService) })
+		api.GET("/stats/keys/:id/timeseries", func(c *gin.Context) { handleKeyTimeSeries(c, deps.StatsService, c.Param("id")) })
+		api.GET("/stats/log-writer", func(c *gin.Context) { c.JSON(http.StatusOK, deps.LogWriter.Stats()) })
+		api.GET("/analytics/queries", func(c *gin.Context) { handleQueryAnalytics(c, deps.StatsService) })
+
+		api.GET("/settings", func(c *gin.Context) { handleGetSettings(c, deps) })
+		api.PUT("/settings", func(c *gin.Context) { handleSetSettings(c, deps) })
 		api.GET("/settings/master-key", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"master_key": deps.MasterKeyService.Get()})
 		})
@@ -68,8 +104,17 @@ func NewRouter(deps Dependencies) http.Handler {
 
 		api.GET("/settings/auto-sync", func(c *gin.Context) { handleGetAutoSync(c, deps.SettingsService) })
 		api.PUT("/settings/auto-sync", func(c *gin.Context) { handleSetAutoSync(c, deps.SettingsService) })
+		api.GET("/settings/cache", func(c *gin.Context) { handleGetCache(c, deps.ResponseCache) })
+		api.PUT("/settings/cache", func(c *gin.Context) { handleSetCache(c, deps.ResponseCache) })
+		api.DELETE("/cache", func(c *gin.Context) { handlePurgeCache(c, deps.ResponseCache) })
+		api.GET("/settings/retry", func(c *gin.Context) { handleGetRetryPolicy(c, deps.TavilyProxy) })
+		api.PUT("/settings/retry", func(c *gin.Context) { handleSetRetryPolicy(c, deps.TavilyProxy) })
+		api.GET("/settings/log-redaction", func(c *gin.Context) { handleGetLogRedaction(c, deps.TavilyProxy) })
+		api.PUT("/settings/log-redaction", func(c *gin.Context) { handleSetLogRedaction(c, deps.TavilyProxy) })
 		api.GET("/settings/log-cleanup", func(c *gin.Context) { handleGetLogCleanup(c, deps.SettingsService) })
 		api.PUT("/settings/log-cleanup", func(c *gin.Context) { handleSetLogCleanup(c, deps.SettingsService) })
+		api.GET("/settings/notifications", func(c *gin.Context) { handleGetNotificationThresholds(c, deps.Notifications) })
+		api.PUT("/settings/notifications", func(c *gin.Context) { handleSetNotificationThresholds(c, deps.Notifications) })
 	}
 
 	r.NoRoute(func(c *gin.Context) {
@@ -91,11 +136,26 @@ func NewRouter(deps Dependencies) http.Handler {
 
 		hasCredential := authHeaderToken != "" || apiKeyFromBody != "" || apiKeyFromQuery != ""
 		if deps.MasterKeyService.Authenticate(authHeaderToken) || deps.MasterKeyService.Authenticate(apiKeyFromBody) || deps.MasterKeyService.Authenticate(apiKeyFromQuery) {
-			handleProxy(c, deps.TavilyProxy, sanitizedBody, sanitizedQuery)
+			handleProxy(c, deps.TavilyProxy, sanitizedBody, sanitizedQuery, nil)
 			return
 		}
 		if hasCredential {
-			respondUnauthorized(c)
+			client, err := authenticateClientKey(c, deps.ClientKeyService, authHeaderToken, apiKeyFromBody, apiKeyFromQuery)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+				return
+			}
+			if client == nil {
+				respondUnauthorized(c)
+				return
+			}
+			if err := deps.ClientKeyService.Admit(c.Request.Context(), client, c.Request.URL.Path); err != nil {
+				respondClientKeyRejected(c, err)
+				return
+			}
+			if !handleProxy(c, deps.TavilyProxy, sanitizedBody, sanitizedQuery, client) {
+				_ = deps.ClientKeyService.Refund(context.WithoutCancel(c.Request.Context()), client.ID)
+			}
 			return
 		}
 
@@ -129,6 +189,36 @@ func respondUnauthorized(c *gin.Context) {
 	c.JSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 }
 
+func authenticateClientKey(c *gin.Context, clientKeys *services.ClientKeyService, tokens ...string) (*models.ClientKey, error) {
+	if clientKeys == nil {
+		return nil, nil
+	}
+	for _, token := range tokens {
+		client, err := clientKeys.Authenticate(c.Request.Context(), token)
+		if err != nil || client != nil {
+			return client, err
+		}
+	}
+	return nil, nil
+}
+
+func respondClientKeyRejected(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrClientKeyDisabled):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "client_key_disabled"})
+	case errors.Is(err, services.ErrClientKeyExpired):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "client_key_expired"})
+	case errors.Is(err, services.ErrClientKeyEndpointNotAllowed):
+		c.JSON(http.StatusForbidden, gin.H{"error": "endpoint_not_allowed"})
+	case errors.Is(err, services.ErrClientKeyQuotaExceeded):
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "client_quota_exceeded"})
+	case errors.Is(err, services.ErrClientKeyNotFound):
+		respondUnauthorized(c)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+	}
+}
+
 func parseBearerToken(authHeader string) string {
 	if authHeader == "" {
 		return ""
@@ -226,17 +316,24 @@ func handleListKeys(c *gin.Context, keys *services.KeyService) {
 	}
 
 	type keyDTO struct {
-		ID         uint    `json:"id"`
-		KeyMasked  string  `json:"key"`
-		Alias      string  `json:"alias"`
-		TotalQuota int     `json:"total_quota"`
-		UsedQuota  int     `json:"used_quota"`
-		IsActive   bool    `json:"is_active"`
-		IsInvalid  bool    `json:"is_invalid"`
-		LastUsedAt *string `json:"last_used_at"`
-		CreatedAt  string  `json:"created_at"`
-	}
-
+		ID                 uint    `json:"id"`
+		KeyMasked          string  `json:"key"`
+		Alias              string  `json:"alias"`
+		TotalQuota         int     `json:"total_quota"`
+		UsedQuota          int     `json:"used_quota"`
+		IsActive           bool    `json:"is_active"`
+		IsInvalid          bool    `json:"is_invalid"`
+		CooldownUntil      *string `json:"cooldown_until"`
+		RateLimitPerMinute int     `json:"rate_limit_per_minute"`
+		BreakerState       string  `json:"breaker_state"`
+		InvalidReason      string  `json:"invalid_reason"`
+		InvalidatedAt      *string `json:"invalidated_at"`
+		NextRevalidateAt   *string `json:"next_revalidate_at"`
+		LastUsedAt         *string `json:"last_used_at"`
+		CreatedAt          string  `json:"created_at"`
+	}
+
+	now := time.Now()
 	out := make([]keyDTO, 0, len(items))
 	for _, k := range items {
 		var lastUsed *string
@@ -244,16 +341,27 @@ func handleListKeys(c *gin.Context, keys *services.KeyService) {
 			v := k.LastUsedAt.Format(time.RFC3339)
 			lastUsed = &v
 		}
+		var cooldownUntil *string
+		if k.CooldownUntil != nil && k.CooldownUntil.After(now) {
+			v := k.CooldownUntil.Format(time.RFC3339)
+			cooldownUntil = &v
+		}
 		out = append(out, keyDTO{
-			ID:         k.ID,
-			KeyMasked:  util.MaskAPIKey(k.Key),
-			Alias:      k.Alias,
-			TotalQuota: k.TotalQuota,
-			UsedQuota:  k.UsedQuota,
-			IsActive:   k.IsActive,
-			IsInvalid:  k.IsInvalid,
-			LastUsedAt: lastUsed,
-			CreatedAt:  k.CreatedAt.Format(time.RFC3339),
+			ID:                 k.ID,
+			KeyMasked:          util.MaskAPIKey(k.Key),
+			Alias:              k.Alias,
+			TotalQuota:         k.TotalQuota,
+			UsedQuota:          k.UsedQuota,
+			IsActive:           k.IsActive,
+			IsInvalid:          k.IsInvalid,
+			CooldownUntil:      cooldownUntil,
+			RateLimitPerMinute: k.RateLimitPerMinute,
+			BreakerState:       keys.BreakerState(k.ID),
+			InvalidReason:      k.InvalidReason,
+			InvalidatedAt:      formatOptionalTime(k.InvalidatedAt),
+			NextRevalidateAt:   formatOptionalTime(k.NextRevalidateAt),
+			LastUsedAt:         lastUsed,
+			CreatedAt:          k.CreatedAt.Format(time.RFC3339),
 		})
 	}
 	c.JSON(http.StatusOK, gin.H{"items": out})
@@ -376,17 +484,55 @@ func handleUpdateKey(c *gin.Context, deps Dependencies, idStr string) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"item": gin.H{
-			"id":          updated.ID,
-			"key":         util.MaskAPIKey(updated.Key),
-			"alias":       updated.Alias,
-			"total_quota": updated.TotalQuota,
-			"used_quota":  updated.UsedQuota,
-			"is_active":   updated.IsActive,
-			"is_invalid":  updated.IsInvalid,
+			"id":                    updated.ID,
+			"key":                   util.MaskAPIKey(updated.Key),
+			"alias":                 updated.Alias,
+			"total_quota":           updated.TotalQuota,
+			"used_quota":            updated.UsedQuota,
+			"is_active":             updated.IsActive,
+			"is_invalid":            updated.IsInvalid,
+			"rate_limit_per_minute": updated.RateLimitPerMinute,
 		},
 	})
 }
 
+func handleRevalidateKey(c *gin.Context, deps Dependencies, idStr string) {
+	id, err := parseUintParam(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_id"})
+		return
+	}
+
+	key, err := deps.KeyService.FindByID(c.Request.Context(), uint(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	if key == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+		return
+	}
+	if !key.IsInvalid {
+		c.JSON(http.StatusConflict, gin.H{"error": "key_not_invalid"})
+		return
+	}
+
+	restored, err := deps.QuotaSyncService.Revalidate(c.Request.Context(), key.ID, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"restored": false, "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"restored": restored})
+}
+
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	v := t.Format(time.RFC3339)
+	return &v
+}
+
 func handleDeleteInvalidKeys(c *gin.Context, keys *services.KeyService) {
 	deleted, err := keys.DeleteInvalid(c.Request.Context())
 	if err != nil {
@@ -422,6 +568,67 @@ func handleStartSyncAllKeys(c *gin.Context, jobs *services.QuotaSyncJobService)
 	c.JSON(http.StatusOK, result)
 }
 
+func handleGetSettings(c *gin.Context, deps Dependencies) {
+	streaming, _, err := deps.SettingsService.Get(c.Request.Context(), services.SettingStreamingEndpoints)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	coalescing, err := deps.SettingsService.GetBool(c.Request.Context(), services.SettingCoalescingEnabled, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"key_selection_strategy":   deps.KeyService.ActiveSelector(c.Request.Context()).Name(),
+		"key_selection_strategies": deps.KeyService.SelectorNames(),
+		"streaming_endpoints":      services.SplitEndpoints(streaming),
+		"request_coalescing":       coalescing,
+	})
+}
+
+func handleSetSettings(c *gin.Context, deps Dependencies) {
+	var body struct {
+		KeySelectionStrategy *string   `json:"key_selection_strategy"`
+		StreamingEndpoints   *[]string `json:"streaming_endpoints"`
+		RequestCoalescing    *bool     `json:"request_coalescing"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
+		return
+	}
+	if body.KeySelectionStrategy == nil && body.StreamingEndpoints == nil && body.RequestCoalescing == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_fields"})
+		return
+	}
+
+	if body.KeySelectionStrategy != nil {
+		strategy := strings.TrimSpace(*body.KeySelectionStrategy)
+		if !deps.KeyService.HasSelector(strategy) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_key_selection_strategy"})
+			return
+		}
+		if err := deps.SettingsService.Set(c.Request.Context(), services.SettingKeySelectionStrategy, strategy); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+			return
+		}
+	}
+	if body.StreamingEndpoints != nil {
+		if err := deps.SettingsService.Set(c.Request.Context(), services.SettingStreamingEndpoints, services.JoinEndpoints(*body.StreamingEndpoints)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+			return
+		}
+	}
+	if body.RequestCoalescing != nil {
+		if err := deps.SettingsService.SetBool(c.Request.Context(), services.SettingCoalescingEnabled, *body.RequestCoalescing); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
 func handleGetAutoSync(c *gin.Context, settings *services.SettingsService) {
 	enabled, err := settings.GetBool(c.Request.Context(), services.SettingAutoSyncEnabled, false)
 	if err != nil {
@@ -523,6 +730,201 @@ func handleSetAutoSync(c *gin.Context, settings *services.SettingsService) {
 	c.Status(http.StatusNoContent)
 }
 
+func handleGetRetryPolicy(c *gin.Context, proxy *services.TavilyProxy) {
+	policy, err := proxy.RetryPolicy(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+func handleSetRetryPolicy(c *gin.Context, proxy *services.TavilyProxy) {
+	var body struct {
+		RetryStatuses  *[]int    `json:"retry_statuses"`
+		MaxAttempts    *int      `json:"max_attempts"`
+		DeadlineMs     *int      `json:"deadline_ms"`
+		HedgeAfterMs   *int      `json:"hedge_after_ms"`
+		HedgeEndpoints *[]string `json:"hedge_endpoints"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
+		return
+	}
+	if body.RetryStatuses == nil && body.MaxAttempts == nil && body.DeadlineMs == nil && body.HedgeAfterMs == nil && body.HedgeEndpoints == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_fields"})
+		return
+	}
+
+	policy, err := proxy.RetryPolicy(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	if body.RetryStatuses != nil {
+		policy.RetryStatuses = *body.RetryStatuses
+	}
+	if body.MaxAttempts != nil {
+		policy.MaxAttempts = *body.MaxAttempts
+	}
+	if body.DeadlineMs != nil {
+		policy.DeadlineMs = *body.DeadlineMs
+	}
+	if body.HedgeAfterMs != nil {
+		policy.HedgeAfterMs = *body.HedgeAfterMs
+	}
+	if body.HedgeEndpoints != nil {
+		policy.HedgeEndpoints = *body.HedgeEndpoints
+	}
+	if err := policy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.SetRetryPolicy(c.Request.Context(), policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func handleGetLogRedaction(c *gin.Context, proxy *services.TavilyProxy) {
+	policy, err := proxy.LogRedaction(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+func handleSetLogRedaction(c *gin.Context, proxy *services.TavilyProxy) {
+	var body struct {
+		Mode             *string   `json:"mode"`
+		DropFields       *[]string `json:"drop_fields"`
+		MaskEmails       *bool     `json:"mask_emails"`
+		MaskPhones       *bool     `json:"mask_phones"`
+		MaskPatterns     *[]string `json:"mask_patterns"`
+		CaptureBytes     *int      `json:"capture_bytes"`
+		CaptureEndpoints *[]string `json:"capture_endpoints"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
+		return
+	}
+	if body.Mode == nil && body.DropFields == nil && body.MaskEmails == nil && body.MaskPhones == nil &&
+		body.MaskPatterns == nil && body.CaptureBytes == nil && body.CaptureEndpoints == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_fields"})
+		return
+	}
+
+	policy, err := proxy.LogRedaction(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	if body.Mode != nil {
+		policy.Mode = strings.TrimSpace(*body.Mode)
+	}
+	if body.DropFields != nil {
+		policy.DropFields = *body.DropFields
+	}
+	if body.MaskEmails != nil {
+		policy.MaskEmails = *body.MaskEmails
+	}
+	if body.MaskPhones != nil {
+		policy.MaskPhones = *body.MaskPhones
+	}
+	if body.MaskPatterns != nil {
+		policy.MaskPatterns = *body.MaskPatterns
+	}
+	if body.CaptureBytes != nil {
+		policy.CaptureBytes = *body.CaptureBytes
+	}
+	if body.CaptureEndpoints != nil {
+		policy.CaptureEndpoints = *body.CaptureEndpoints
+	}
+	if err := policy.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := proxy.SetLogRedaction(c.Request.Context(), policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func handleGetCache(c *gin.Context, cache *services.ResponseCache) {
+	cfg, err := cache.Config(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, cfg)
+}
+
+func handleSetCache(c *gin.Context, cache *services.ResponseCache) {
+	var body struct {
+		Enabled    *bool           `json:"enabled"`
+		Backend    *string         `json:"backend"`
+		MaxEntries *int            `json:"max_entries"`
+		TTLSeconds *map[string]int `json:"ttl_seconds"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
+		return
+	}
+	if body.Enabled == nil && body.Backend == nil && body.MaxEntries == nil && body.TTLSeconds == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_fields"})
+		return
+	}
+
+	cfg, err := cache.Config(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	if body.Enabled != nil {
+		cfg.Enabled = *body.Enabled
+	}
+	if body.Backend != nil {
+		if *body.Backend != services.CacheBackendMemory && *body.Backend != services.CacheBackendSQLite {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_backend"})
+			return
+		}
+		cfg.Backend = *body.Backend
+	}
+	if body.MaxEntries != nil {
+		if *body.MaxEntries < 1 || *body.MaxEntries > 100000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_max_entries"})
+			return
+		}
+		cfg.MaxEntries = *body.MaxEntries
+	}
+	if body.TTLSeconds != nil {
+		for _, seconds := range *body.TTLSeconds {
+			if seconds < 0 || seconds > 30*24*3600 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_ttl_seconds"})
+				return
+			}
+		}
+		cfg.TTLSeconds = *body.TTLSeconds
+	}
+
+	if err := cache.SetConfig(c.Request.Context(), cfg); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func handlePurgeCache(c *gin.Context, cache *services.ResponseCache) {
+	if err := cache.Purge(c.Request.Context()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
 func handleGetLogCleanup(c *gin.Context, settings *services.SettingsService) {
 	retentionDays, err := settings.GetInt(c.Request.Context(), services.SettingLogRetentionDays, 30)
 	if err != nil {
@@ -541,6 +943,10 @@ func handleGetLogCleanup(c *gin.Context, settings *services.SettingsService) {
 
 	lastRun, _ := settings.GetTime(c.Request.Context(), services.SettingLogCleanupLastRunAt)
 	lastErr, _, _ := settings.Get(c.Request.Context(), services.SettingLogCleanupLastError)
+	archiveDir, _, _ := settings.Get(c.Request.Context(), services.SettingLogArchiveDir)
+	archiveRetentionDays, _ := settings.GetInt(c.Request.Context(), services.SettingLogArchiveRetentionDays, 0)
+	archiveMaxMB, _ := settings.GetInt(c.Request.Context(), services.SettingLogArchiveMaxMB, 0)
+	lastArchivePath, _, _ := settings.Get(c.Request.Context(), services.SettingLogArchiveLastPath)
 
 	var lastRunStr *string
 	if lastRun != nil {
@@ -553,22 +959,39 @@ func handleGetLogCleanup(c *gin.Context, settings *services.SettingsService) {
 		"retention_days":  retentionDays,
 		"last_run_at":     lastRunStr,
 		"last_error":      lastErr,
+
+		"archive_dir":            archiveDir,
+		"archive_retention_days": archiveRetentionDays,
+		"archive_max_mb":         archiveMaxMB,
+		"last_archive_path":      lastArchivePath,
 	})
 }
 
 func handleSetLogCleanup(c *gin.Context, settings *services.SettingsService) {
 	var body struct {
-		LoggingEnabled *bool `json:"logging_enabled"`
-		RetentionDays  *int  `json:"retention_days"`
+		LoggingEnabled       *bool   `json:"logging_enabled"`
+		RetentionDays        *int    `json:"retention_days"`
+		ArchiveDir           *string `json:"archive_dir"`
+		ArchiveRetentionDays *int    `json:"archive_retention_days"`
+		ArchiveMaxMB         *int    `json:"archive_max_mb"`
 	}
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
 		return
 	}
-	if body.RetentionDays == nil && body.LoggingEnabled == nil {
+	if body.RetentionDays == nil && body.LoggingEnabled == nil && body.ArchiveDir == nil &&
+		body.ArchiveRetentionDays == nil && body.ArchiveMaxMB == nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_fields"})
 		return
 	}
+	if body.ArchiveRetentionDays != nil && (*body.ArchiveRetentionDays < 0 || *body.ArchiveRetentionDays > 36500) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_archive_retention_days"})
+		return
+	}
+	if body.ArchiveMaxMB != nil && *body.ArchiveMaxMB < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_archive_max_mb"})
+		return
+	}
 
 	if body.LoggingEnabled != nil {
 		if err := settings.SetBool(c.Request.Context(), services.SettingRequestLoggingEnabled, *body.LoggingEnabled); err != nil {
@@ -587,6 +1010,25 @@ func handleSetLogCleanup(c *gin.Context, settings *services.SettingsService) {
 			return
 		}
 	}
+
+	if body.ArchiveDir != nil {
+		if err := settings.Set(c.Request.Context(), services.SettingLogArchiveDir, strings.TrimSpace(*body.ArchiveDir)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+			return
+		}
+	}
+	if body.ArchiveRetentionDays != nil {
+		if err := settings.SetInt(c.Request.Context(), services.SettingLogArchiveRetentionDays, *body.ArchiveRetentionDays); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+			return
+		}
+	}
+	if body.ArchiveMaxMB != nil {
+		if err := settings.SetInt(c.Request.Context(), services.SettingLogArchiveMaxMB, *body.ArchiveMaxMB); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+			return
+		}
+	}
 	c.Status(http.StatusNoContent)
 }
 
@@ -603,41 +1045,460 @@ func handleDeleteKey(c *gin.Context, keys *services.KeyService, idStr string) {
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
-func handleListLogs(c *gin.Context, logs *services.LogService) {
-	page, _ := strconv.Atoi(c.Query("page"))
-	size, _ := strconv.Atoi(c.Query("page_size"))
-
-	var statusCode *int
-	if v := c.Query("status_code"); v != "" {
-		parsed, err := strconv.Atoi(v)
-		if err != nil || parsed < 0 || parsed > 999 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_status_code"})
-			return
-		}
-		statusCode = &parsed
+func clientKeyDTO(k models.ClientKey) gin.H {
+	var expiresAt *string
+	if k.ExpiresAt != nil {
+		v := k.ExpiresAt.Format(time.RFC3339)
+		expiresAt = &v
+	}
+	var lastUsed *string
+	if k.LastUsedAt != nil {
+		v := k.LastUsedAt.Format(time.RFC3339)
+		lastUsed = &v
+	}
+	return gin.H{
+		"id":                k.ID,
+		"name":              k.Name,
+		"key_prefix":        k.KeyPrefix,
+		"monthly_limit":     k.MonthlyLimit,
+		"daily_limit":       k.DailyLimit,
+		"monthly_used":      k.MonthlyUsed,
+		"daily_used":        k.DailyUsed,
+		"allowed_endpoints": services.SplitEndpoints(k.AllowedEndpoints),
+		"expires_at":        expiresAt,
+		"is_active":         k.IsActive,
+		"last_used_at":      lastUsed,
+		"created_at":        k.CreatedAt.Format(time.RFC3339),
 	}
+}
 
-	out, err := logs.List(c.Request.Context(), page, size, statusCode)
+func handleListClientKeys(c *gin.Context, clientKeys *services.ClientKeyService) {
+	items, err := clientKeys.List(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
 		return
 	}
-	c.JSON(http.StatusOK, out)
+	out := make([]gin.H, 0, len(items))
+	for _, k := range items {
+		out = append(out, clientKeyDTO(k))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": out})
 }
 
-func handleClearLogs(c *gin.Context, logs *services.LogService) {
-	deleted, err := logs.DeleteAll(c.Request.Context())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+func handleCreateClientKey(c *gin.Context, clientKeys *services.ClientKeyService) {
+	var body services.ClientKeyInput
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_name"})
+		return
+	}
+	if body.MonthlyLimit < 0 || body.DailyLimit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_limit"})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
-}
 
-func handleLogStatusCodes(c *gin.Context, logs *services.LogService) {
-	out, err := logs.StatusCodeCounts(c.Request.Context())
+	created, secret, err := clientKeys.Create(c.Request.Context(), body)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "create_failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"item": clientKeyDTO(*created), "key": secret})
+}
+
+func handleUpdateClientKey(c *gin.Context, clientKeys *services.ClientKeyService, idStr string) {
+	id, err := parseUintParam(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_id"})
+		return
+	}
+
+	var body services.ClientKeyUpdate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
+		return
+	}
+
+	updated, err := clientKeys.Update(c.Request.Context(), uint(id), body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "update_failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"item": clientKeyDTO(*updated)})
+}
+
+func handleRotateClientKey(c *gin.Context, clientKeys *services.ClientKeyService, idStr string) {
+	id, err := parseUintParam(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_id"})
+		return
+	}
+
+	updated, secret, err := clientKeys.RotateSecret(c.Request.Context(), uint(id))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rotate_failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"item": clientKeyDTO(*updated), "key": secret})
+}
+
+func handleDeleteClientKey(c *gin.Context, clientKeys *services.ClientKeyService, idStr string) {
+	id, err := parseUintParam(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_id"})
+		return
+	}
+	if err := clientKeys.Delete(c.Request.Context(), uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete_failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func notificationTargetDTO(t models.NotificationTarget) gin.H {
+	events := []string{}
+	if t.Events != "" {
+		events = strings.Split(t.Events, ",")
+	}
+	return gin.H{
+		"id":         t.ID,
+		"name":       t.Name,
+		"url":        t.URL,
+		"format":     t.Format,
+		"events":     events,
+		"has_secret": t.Secret != "",
+		"is_active":  t.IsActive,
+		"created_at": t.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// notificationErrorCode maps NotificationService errors to API error codes.
+func notificationErrorCode(err error) (int, string) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound, "not_found"
+	case errors.Is(err, services.ErrInvalidNotificationURL):
+		return http.StatusBadRequest, "invalid_url"
+	case errors.Is(err, services.ErrInvalidNotificationFormat):
+		return http.StatusBadRequest, "invalid_format"
+	case errors.Is(err, services.ErrUnknownEventType):
+		return http.StatusBadRequest, "unknown_event_type"
+	default:
+		return http.StatusInternalServerError, "db_error"
+	}
+}
+
+func handleListNotificationTargets(c *gin.Context, notifications *services.NotificationService) {
+	items, err := notifications.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	out := make([]gin.H, 0, len(items))
+	for _, t := range items {
+		out = append(out, notificationTargetDTO(t))
+	}
+	c.JSON(http.StatusOK, gin.H{"items": out, "event_types": services.EventTypes})
+}
+
+func handleCreateNotificationTarget(c *gin.Context, notifications *services.NotificationService) {
+	var body services.NotificationTargetInput
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
+		return
+	}
+	if strings.TrimSpace(body.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_name"})
+		return
+	}
+
+	created, secret, err := notifications.Create(c.Request.Context(), body)
+	if err != nil {
+		status, code := notificationErrorCode(err)
+		c.JSON(status, gin.H{"error": code})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"item": notificationTargetDTO(*created), "secret": secret})
+}
+
+func handleUpdateNotificationTarget(c *gin.Context, notifications *services.NotificationService, idStr string) {
+	id, err := parseUintParam(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_id"})
+		return
+	}
+
+	var body services.NotificationTargetUpdate
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
+		return
+	}
+
+	updated, err := notifications.Update(c.Request.Context(), uint(id), body)
+	if err != nil {
+		status, code := notificationErrorCode(err)
+		c.JSON(status, gin.H{"error": code})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"item": notificationTargetDTO(*updated)})
+}
+
+func handleDeleteNotificationTarget(c *gin.Context, notifications *services.NotificationService, idStr string) {
+	id, err := parseUintParam(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_id"})
+		return
+	}
+	if err := notifications.Delete(c.Request.Context(), uint(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "delete_failed"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// handleTestNotificationTarget sends a test event to one target and returns
+// the resulting delivery, so a misconfigured receiver shows up immediately.
+func handleTestNotificationTarget(c *gin.Context, notifications *services.NotificationService, idStr string) {
+	id, err := parseUintParam(idStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_id"})
+		return
+	}
+	delivery, err := notifications.SendTest(c.Request.Context(), uint(id))
+	if err != nil {
+		status, code := notificationErrorCode(err)
+		c.JSON(status, gin.H{"error": code})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"item": delivery})
+}
+
+func handleListNotificationDeliveries(c *gin.Context, notifications *services.NotificationService) {
+	var filter services.DeliveryFilter
+	if v := strings.TrimSpace(c.Query("target_id")); v != "" {
+		id, err := parseUintParam(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_target_id"})
+			return
+		}
+		targetID := uint(id)
+		filter.TargetID = &targetID
+	}
+	switch status := strings.TrimSpace(c.Query("status")); status {
+	case "", services.DeliveryPending, services.DeliveryDelivered, services.DeliveryFailed:
+		filter.Status = status
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_status"})
+		return
+	}
+	filter.Limit, _ = strconv.Atoi(c.Query("limit"))
+
+	items, err := notifications.ListDeliveries(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func handleGetNotificationThresholds(c *gin.Context, notifications *services.NotificationService) {
+	thresholds, err := notifications.Thresholds(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, thresholds)
+}
+
+func handleSetNotificationThresholds(c *gin.Context, notifications *services.NotificationService) {
+	var body struct {
+		PoolQuotaPercent *int `json:"pool_quota_percent"`
+		MinActiveKeys    *int `json:"min_active_keys"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_json"})
+		return
+	}
+	if body.PoolQuotaPercent == nil && body.MinActiveKeys == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing_fields"})
+		return
+	}
+
+	thresholds, err := notifications.Thresholds(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	if body.PoolQuotaPercent != nil {
+		if *body.PoolQuotaPercent < 0 || *body.PoolQuotaPercent > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_pool_quota_percent"})
+			return
+		}
+		thresholds.PoolQuotaPercent = *body.PoolQuotaPercent
+	}
+	if body.MinActiveKeys != nil {
+		if *body.MinActiveKeys < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_min_active_keys"})
+			return
+		}
+		thresholds.MinActiveKeys = *body.MinActiveKeys
+	}
+	if err := notifications.SetThresholds(c.Request.Context(), thresholds); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func handleListLogAttempts(c *gin.Context, logs *services.LogService, requestID string) {
+	requestID = strings.TrimSpace(requestID)
+	if requestID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request_id"})
+		return
+	}
+	items, err := logs.ListAttempts(c.Request.Context(), requestID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"items": items})
+}
+
+func handleListLogs(c *gin.Context, logs *services.LogService) {
+	page, _ := strconv.Atoi(c.Query("page"))
+	size, _ := strconv.Atoi(c.Query("page_size"))
+
+	filter, errCode := parseLogFilter(c, logs.Location())
+	if errCode != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errCode})
+		return
+	}
+
+	out, err := logs.List(c.Request.Context(), services.LogQuery{
+		LogFilter: filter,
+		Page:      page,
+		Size:      size,
+		Cursor:    strings.TrimSpace(c.Query("cursor")),
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_cursor"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// parseLogFilter reads the log filter query parameters. It returns an error
+// code for the first invalid parameter.
+func parseLogFilter(c *gin.Context, loc *time.Location) (services.LogFilter, string) {
+	var filter services.LogFilter
+
+	if v := c.Query("status_code"); v != "" {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed < 0 || parsed > 999 {
+			return filter, "invalid_status_code"
+		}
+		filter.StatusCode = &parsed
+	}
+	if v := strings.TrimSpace(c.Query("endpoint")); v != "" {
+		filter.Endpoint = "/" + strings.TrimLeft(v, "/")
+	}
+	if v := c.Query("key_id"); v != "" {
+		parsed, err := parseUintParam(v)
+		if err != nil {
+			return filter, "invalid_key_id"
+		}
+		id := uint(parsed)
+		filter.KeyID = &id
+	}
+	filter.KeyAlias = strings.TrimSpace(c.Query("key_alias"))
+	filter.ClientIP = strings.TrimSpace(c.Query("client_ip"))
+	filter.TraceID = strings.ToLower(strings.TrimSpace(c.Query("trace_id")))
+	filter.Search = strings.TrimSpace(c.Query("q"))
+
+	for _, p := range []struct {
+		name string
+		dst  **int64
+	}{{"min_latency", &filter.MinLatencyMs}, {"max_latency", &filter.MaxLatencyMs}} {
+		if v := c.Query(p.name); v != "" {
+			parsed, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || parsed < 0 {
+				return filter, "invalid_" + p.name
+			}
+			*p.dst = &parsed
+		}
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		if v := c.Query(p.name); v != "" {
+			parsed, err := parseTimeParam(v, loc)
+			if err != nil {
+				return filter, "invalid_" + p.name
+			}
+			*p.dst = &parsed
+		}
+	}
+	return filter, ""
+}
+
+// parseTimeParam reads an RFC 3339 time, or a date taken as midnight in loc.
+func parseTimeParam(v string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", v, loc)
+}
+
+func handleExportLogs(c *gin.Context, logs *services.LogService) {
+	format := strings.ToLower(strings.TrimSpace(c.DefaultQuery("format", services.LogFormatJSONL)))
+	contentType := ""
+	switch format {
+	case services.LogFormatJSONL:
+		contentType = "application/x-ndjson"
+	case services.LogFormatCSV:
+		contentType = "text/csv; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_format"})
+		return
+	}
+
+	filter, errCode := parseLogFilter(c, logs.Location())
+	if errCode != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errCode})
+		return
+	}
+
+	filename := "tavily-logs-" + time.Now().Format("20060102-150405") + "." + format
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+
+	// Headers are already sent, so a failure midway can only truncate the file.
+	if _, err := logs.Export(c.Request.Context(), c.Writer, format, filter); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+func handleClearLogs(c *gin.Context, logs *services.LogService) {
+	deleted, err := logs.DeleteAll(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": deleted})
+}
+
+func handleLogStatusCodes(c *gin.Context, logs *services.LogService) {
+	out, err := logs.StatusCodeCounts(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
 		return
 	}
 	c.JSON(http.StatusOK, out)
@@ -652,18 +1513,165 @@ func handleStats(c *gin.Context, stats *services.StatsService) {
 	c.JSON(http.StatusOK, out)
 }
 
+func handleQueryAnalytics(c *gin.Context, stats *services.StatsService) {
+	to := time.Now()
+	if v := c.Query("to"); v != "" {
+		parsed, err := parseTimeParam(v, stats.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_to"})
+			return
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -7)
+	if v := c.Query("from"); v != "" {
+		parsed, err := parseTimeParam(v, stats.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_from"})
+			return
+		}
+		from = parsed
+	}
+	if !from.Before(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_range"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	out, err := stats.QueryAnalytics(c.Request.Context(), from, to, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
 func handleTimeSeries(c *gin.Context, stats *services.StatsService) {
-	granularity := c.Query("granularity")
-	out, err := stats.TimeSeries(c.Request.Context(), granularity)
+	q := services.TimeSeriesQuery{Granularity: c.Query("granularity")}
+	if v := c.Query("from"); v != "" {
+		parsed, err := parseTimeParam(v, stats.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_from"})
+			return
+		}
+		q.From = parsed
+	}
+	if v := c.Query("to"); v != "" {
+		parsed, err := parseTimeParam(v, stats.Location())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_to"})
+			return
+		}
+		q.To = parsed
+	}
+	if !q.From.IsZero() && !q.From.Before(q.To) && !q.To.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_range"})
+		return
+	}
+	// endpoints is a comma-separated list of endpoint paths, "total" for all
+	// requests, or "all" for all requests plus every endpoint.
+	if v := strings.TrimSpace(c.Query("endpoints")); v != "" {
+		for _, ep := range strings.Split(v, ",") {
+			ep = strings.TrimSpace(ep)
+			switch {
+			case ep == "total":
+				ep = ""
+			case ep == services.TimeSeriesAllEndpoints, services.IsStatEndpoint(ep):
+			default:
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_endpoints"})
+				return
+			}
+			q.Endpoints = append(q.Endpoints, ep)
+		}
+	}
+
+	out, err := stats.TimeSeries(c.Request.Context(), q)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidGranularity):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_granularity"})
+		case errors.Is(err, services.ErrTimeSeriesTooLong):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "too_many_points"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func handleKeyTimeSeries(c *gin.Context, stats *services.StatsService, idStr string) {
+	id, err := parseUintParam(idStr)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_id"})
+		return
+	}
+	granularity := c.Query("granularity")
+	switch granularity {
+	case "", "hour", "day", "month":
+	default:
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_granularity"})
 		return
 	}
+
+	out, err := stats.KeyTimeSeries(c.Request.Context(), uint(id), granularity)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not_found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func handleKeyLeaderboard(c *gin.Context, stats *services.StatsService) {
+	period := c.Query("period")
+	if period != "" && period != "day" && period != "month" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_period"})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+
+	out, err := stats.KeyLeaderboard(c.Request.Context(), period, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+func handleForecast(c *gin.Context, stats *services.StatsService) {
+	var opts services.ForecastOptions
+	if v := strings.TrimSpace(c.Query("days")); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 1 || days > 90 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_days"})
+			return
+		}
+		opts.WindowDays = days
+	}
+	if v := strings.TrimSpace(c.Query("key_quota")); v != "" {
+		quota, err := strconv.Atoi(v)
+		if err != nil || quota < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_key_quota"})
+			return
+		}
+		opts.KeyQuota = quota
+	}
+	out, err := stats.Forecast(c.Request.Context(), opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_error"})
+		return
+	}
 	c.JSON(http.StatusOK, out)
 }
 
-func handleProxy(c *gin.Context, proxy *services.TavilyProxy, body []byte, rawQuery string) {
-	resp, err := proxy.Do(c.Request.Context(), services.ProxyRequest{
+// handleProxy answers a proxied request and reports whether it was sent to
+// Tavily; cache hits and requests no key could take were not.
+func handleProxy(c *gin.Context, proxy *services.TavilyProxy, body []byte, rawQuery string, client *models.ClientKey) bool {
+	req := services.ProxyRequest{
 		Method:      c.Request.Method,
 		Path:        c.Request.URL.Path,
 		RawQuery:    rawQuery,
@@ -671,20 +1679,81 @@ func handleProxy(c *gin.Context, proxy *services.TavilyProxy, body []byte, rawQu
 		Body:        body,
 		ClientIP:    c.ClientIP(),
 		ContentType: c.GetHeader("Content-Type"),
-	})
+	}
+	if client != nil {
+		req.ClientKeyID = client.ID
+		req.ClientKeyName = client.Name
+	}
+
+	if proxy.StreamingEnabled(c.Request.Context(), req.Path) {
+		return handleProxyStream(c, proxy, req)
+	}
+
+	resp, err := proxy.Do(c.Request.Context(), req)
 	if err != nil {
-		if errors.Is(err, services.ErrNoAvailableKeys) {
-			c.JSON(http.StatusServiceUnavailable, gin.H{
-				"error":   "no_available_keys",
-				"message": "No active Tavily API keys with remaining quota.",
-			})
-			return
+		respondProxyError(c, err)
+		return !errors.Is(err, services.ErrNoAvailableKeys)
+	}
+
+	copyResponseHeaders(c, resp.Headers)
+	c.Header("X-Proxy-Request-ID", resp.ProxyRequestID)
+	if resp.TavilyRequestID != "" {
+		c.Header("X-Tavily-Request-ID", resp.TavilyRequestID)
+	}
+	if resp.CacheStatus != "" {
+		c.Header("X-Proxy-Cache", resp.CacheStatus)
+	}
+
+	c.Status(resp.StatusCode)
+	_, _ = io.Copy(c.Writer, bytes.NewReader(resp.Body))
+	return resp.CacheStatus != services.CacheStatusHit
+}
+
+func handleProxyStream(c *gin.Context, proxy *services.TavilyProxy, req services.ProxyRequest) bool {
+	resp, err := proxy.DoStream(c.Request.Context(), req)
+	if err != nil {
+		respondProxyError(c, err)
+		return !errors.Is(err, services.ErrNoAvailableKeys)
+	}
+	defer resp.Body.Close()
+
+	copyResponseHeaders(c, resp.Headers)
+	c.Header("X-Proxy-Request-ID", resp.ProxyRequestID)
+	c.Status(resp.StatusCode)
+	c.Writer.WriteHeaderNow()
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := c.Writer.Write(buf[:n]); werr != nil {
+				return true
+			}
+			c.Writer.Flush()
 		}
-		c.JSON(http.StatusBadGateway, gin.H{"error": "upstream_error"})
+		if err != nil {
+			return true
+		}
+	}
+}
+
+func respondProxyError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrNoAvailableKeys) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error":   "no_available_keys",
+			"message": "No active Tavily API keys with remaining quota.",
+		})
 		return
 	}
+	if errors.Is(err, services.ErrRetryDeadlineExceeded) {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "upstream_timeout"})
+		return
+	}
+	c.JSON(http.StatusBadGateway, gin.H{"error": "upstream_error"})
+}
 
-	for k, vv := range resp.Headers {
+func copyResponseHeaders(c *gin.Context, headers http.Header) {
+	for k, vv := range headers {
 		if isHopByHopHeader(k) || strings.EqualFold(k, "Content-Length") {
 			continue
 		}
@@ -692,13 +1761,6 @@ func handleProxy(c *gin.Context, proxy *services.TavilyProxy, body []byte, rawQu
 			c.Writer.Header().Add(k, v)
 		}
 	}
-	c.Header("X-Proxy-Request-ID", resp.ProxyRequestID)
-	if resp.TavilyRequestID != "" {
-		c.Header("X-Tavily-Request-ID", resp.TavilyRequestID)
-	}
-
-	c.Status(resp.StatusCode)
-	_, _ = io.Copy(c.Writer, bytes.NewReader(resp.Body))
 }
 
 func isHopByHopHeader(k string) bool {
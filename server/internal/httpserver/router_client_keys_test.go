@@ -0,0 +1,237 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+	"tavily-proxy/server/internal/services"
+)
+
+func TestProxy_ClientKey_ProxiesAndRecordsClientOnLog(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	const poolKey = "tvly-pool-1234567890abcdef"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer "+poolKey {
+			t.Errorf("unexpected Authorization header: got %q want %q", got, "Bearer "+poolKey)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"request_id":"test","results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	master := services.NewMasterKeyService(database, logger)
+	if err := master.LoadOrCreate(ctx); err != nil {
+		t.Fatalf("master key init: %v", err)
+	}
+
+	keys := services.NewKeyService(database, logger)
+	if _, err := keys.Create(ctx, poolKey, "pool", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	clientKeys := services.NewClientKeyService(database, logger)
+	client, secret, err := clientKeys.Create(ctx, services.ClientKeyInput{Name: "ci-bot", DailyLimit: 1})
+	if err != nil {
+		t.Fatalf("create client key: %v", err)
+	}
+
+	logs := services.NewLogService(database, logger)
+	proxy := services.NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger)
+
+	router := NewRouter(Dependencies{
+		MasterKeyService: master,
+		ClientKeyService: clientKeys,
+		TavilyProxy:      proxy,
+	})
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewReader([]byte(`{"query":"hello"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+secret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := send(); w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: got %d want %d (body=%q)", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var entry models.RequestLog
+	if err := database.WithContext(ctx).First(&entry).Error; err != nil {
+		t.Fatalf("load log: %v", err)
+	}
+	if entry.ClientKeyID != client.ID || entry.ClientKeyName != "ci-bot" {
+		t.Fatalf("unexpected client on log: id=%d name=%q", entry.ClientKeyID, entry.ClientKeyName)
+	}
+
+	// The daily budget of one request is now spent.
+	if w := send(); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status: got %d want %d (body=%q)", w.Code, http.StatusTooManyRequests, w.Body.String())
+	}
+}
+
+func TestProxy_ClientKey_EndpointNotAllowed(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var upstreamCalls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamCalls, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(upstream.Close)
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	master := services.NewMasterKeyService(database, logger)
+	if err := master.LoadOrCreate(ctx); err != nil {
+		t.Fatalf("master key init: %v", err)
+	}
+
+	keys := services.NewKeyService(database, logger)
+	if _, err := keys.Create(ctx, "tvly-pool-1234567890abcdef", "pool", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	clientKeys := services.NewClientKeyService(database, logger)
+	_, secret, err := clientKeys.Create(ctx, services.ClientKeyInput{Name: "search-only", AllowedEndpoints: []string{"search"}})
+	if err != nil {
+		t.Fatalf("create client key: %v", err)
+	}
+
+	proxy := services.NewTavilyProxy(upstream.URL, 5*time.Second, keys, nil, nil, logger)
+	router := NewRouter(Dependencies{
+		MasterKeyService: master,
+		ClientKeyService: clientKeys,
+		TavilyProxy:      proxy,
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/extract", bytes.NewReader([]byte(`{"urls":["https://example.com"],"api_key":"`+secret+`"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("unexpected status: got %d want %d (body=%q)", w.Code, http.StatusForbidden, w.Body.String())
+	}
+	if got := atomic.LoadInt32(&upstreamCalls); got != 0 {
+		t.Fatalf("upstream should not be called, got %d calls", got)
+	}
+}
+
+func TestProxy_ClientKey_ConcurrentBudgetAndRefunds(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	master := services.NewMasterKeyService(database, logger)
+	if err := master.LoadOrCreate(ctx); err != nil {
+		t.Fatalf("master key init: %v", err)
+	}
+	keys := services.NewKeyService(database, logger)
+	clientKeys := services.NewClientKeyService(database, logger)
+	client, secret, err := clientKeys.Create(ctx, services.ClientKeyInput{Name: "burst", DailyLimit: 5})
+	if err != nil {
+		t.Fatalf("create client key: %v", err)
+	}
+	proxy := services.NewTavilyProxy(upstream.URL, 5*time.Second, keys, nil, nil, logger)
+	router := NewRouter(Dependencies{
+		MasterKeyService: master,
+		ClientKeyService: clientKeys,
+		TavilyProxy:      proxy,
+	})
+
+	send := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewReader([]byte(`{"query":"hello"}`)))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+secret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// With no pool keys nothing reaches Tavily, so nothing is charged.
+	if code := send(); code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status without keys: got %d want %d", code, http.StatusServiceUnavailable)
+	}
+	got, err := clientKeys.Get(ctx, client.ID)
+	if err != nil {
+		t.Fatalf("get client key: %v", err)
+	}
+	if got.DailyUsed != 0 {
+		t.Fatalf("daily usage after a 503: got %d want %d", got.DailyUsed, 0)
+	}
+
+	if _, err := keys.Create(ctx, "tvly-pool-1234567890abcdef", "pool", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	codes := make(chan int, 20)
+	for i := 0; i < cap(codes); i++ {
+		go func() { codes <- send() }()
+	}
+	counts := make(map[int]int)
+	for i := 0; i < cap(codes); i++ {
+		counts[<-codes]++
+	}
+	if counts[http.StatusOK] != 5 || counts[http.StatusTooManyRequests] != 15 {
+		t.Fatalf("unexpected statuses for a budget of 5: %v", counts)
+	}
+}
@@ -0,0 +1,101 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tavily-proxy/server/internal/config"
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/jobs"
+	"tavily-proxy/server/internal/services"
+)
+
+func TestReadyzAndVerboseHealthz_ReportChecks(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	settings := services.NewSettingsService(database)
+	keys := services.NewKeyService(database, logger).WithSettings(settings)
+
+	router := NewRouter(Dependencies{
+		Config:           config.Config{ReadyMinKeys: 1},
+		MasterKeyService: services.NewMasterKeyService(database, logger),
+		SettingsService:  settings,
+		KeyService:       keys,
+		Jobs:             jobs.NewMonitor(),
+	})
+
+	get := func(path string) (int, healthReport) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var report healthReport
+		if path != "/healthz" {
+			if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+				t.Fatalf("decode %s: %v", path, err)
+			}
+		}
+		return w.Code, report
+	}
+
+	code, report := get("/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected readyz status without keys: got %d want %d", code, http.StatusServiceUnavailable)
+	}
+	if report.Checks["keys"].Status != checkFail || report.Checks["database"].Status != checkOK {
+		t.Fatalf("unexpected checks without keys: %+v", report.Checks)
+	}
+
+	if _, err := keys.Create(ctx, "tvly-test", "primary", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if code, report = get("/readyz"); code != http.StatusOK {
+		t.Fatalf("unexpected readyz status with a key: got %d want %d (%+v)", code, http.StatusOK, report.Checks)
+	}
+
+	// A stale auto-sync degrades health but does not take the instance out of rotation.
+	if err := settings.SetBool(ctx, services.SettingAutoSyncEnabled, true); err != nil {
+		t.Fatalf("enable auto-sync: %v", err)
+	}
+	if err := settings.SetInt(ctx, services.SettingAutoSyncIntervalMinutes, 60); err != nil {
+		t.Fatalf("set interval: %v", err)
+	}
+	if err := settings.SetTime(ctx, services.SettingAutoSyncLastSuccessAt, time.Now().Add(-4*time.Hour)); err != nil {
+		t.Fatalf("set last success: %v", err)
+	}
+	if code, _ = get("/readyz"); code != http.StatusOK {
+		t.Fatalf("unexpected readyz status with stale sync: got %d want %d", code, http.StatusOK)
+	}
+	code, report = get("/healthz?verbose=1")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected verbose healthz status: got %d want %d", code, http.StatusServiceUnavailable)
+	}
+	if check := report.Checks["auto_sync"]; check.Status != checkFail || check.Error != "stale" {
+		t.Fatalf("unexpected auto_sync check: %+v", check)
+	}
+	if code, _ = get("/healthz"); code != http.StatusOK {
+		t.Fatalf("unexpected plain healthz status: got %d want %d", code, http.StatusOK)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"net/http/httptest"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
@@ -163,3 +164,127 @@ func TestHandleLogStatusCodes_ReturnsCounts(t *testing.T) {
 		t.Fatalf("unexpected second item: %+v", out[1])
 	}
 }
+
+func TestHandleListLogAttempts(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	logs := services.NewLogService(database, logger)
+	ctx := context.Background()
+
+	for _, attempt := range []models.RequestAttempt{
+		{RequestID: "a", Attempt: 2, KeyID: 2, Endpoint: "/search", StatusCode: 200, Outcome: services.AttemptOutcomeReturned},
+		{RequestID: "a", Attempt: 1, KeyID: 1, Endpoint: "/search", StatusCode: 401, Outcome: services.AttemptOutcomeFailover},
+		{RequestID: "b", Attempt: 1, KeyID: 1, Endpoint: "/search", StatusCode: 200, Outcome: services.AttemptOutcomeReturned},
+	} {
+		attempt := attempt
+		if err := logs.CreateAttempt(ctx, &attempt); err != nil {
+			t.Fatalf("create attempt: %v", err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/logs/a/attempts", nil)
+
+	handleListLogAttempts(c, logs, "a")
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: got %d want %d", w.Code, http.StatusOK)
+	}
+	var out struct {
+		Items []models.RequestAttempt `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("unmarshal response: %v (body=%q)", err, w.Body.String())
+	}
+	if len(out.Items) != 2 || out.Items[0].Attempt != 1 || out.Items[0].StatusCode != 401 || out.Items[1].Attempt != 2 {
+		t.Fatalf("unexpected attempts: %+v", out.Items)
+	}
+}
+
+func TestHandleListLogs_FiltersAndCursor(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	logs := services.NewLogService(database, logger)
+	ctx := context.Background()
+
+	for i, entry := range []models.RequestLog{
+		{RequestID: "a", Endpoint: "/extract", KeyUsed: 12, StatusCode: 200, LatencyMs: 4000, ClientIP: "10.0.0.5"},
+		{RequestID: "b", Endpoint: "/extract", KeyUsed: 12, StatusCode: 200, LatencyMs: 100, ClientIP: "10.0.0.5"},
+		{RequestID: "c", Endpoint: "/extract", KeyUsed: 7, StatusCode: 200, LatencyMs: 5000, ClientIP: "10.0.0.5"},
+		{RequestID: "d", Endpoint: "/search", KeyUsed: 12, StatusCode: 200, LatencyMs: 6000, ClientIP: "10.0.0.5", RequestBody: `{"query":"golang 100% speed"}`},
+		{RequestID: "e", Endpoint: "/extract", KeyUsed: 12, StatusCode: 200, LatencyMs: 3000, ClientIP: "10.0.0.5"},
+		{RequestID: "f", Endpoint: "/extract", KeyUsed: 12, StatusCode: 200, LatencyMs: 3500, ClientIP: "10.0.0.6"},
+	} {
+		entry := entry
+		entry.CreatedAt = time.Date(2026, 1, 2, 10, i, 0, 0, time.UTC)
+		if err := logs.Create(ctx, &entry); err != nil {
+			t.Fatalf("create log: %v", err)
+		}
+	}
+
+	list := func(target string) services.PaginatedLogs {
+		t.Helper()
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+		handleListLogs(c, logs)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status for %s: got %d want %d (body=%q)", target, w.Code, http.StatusOK, w.Body.String())
+		}
+		var out services.PaginatedLogs
+		if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+			t.Fatalf("unmarshal response: %v (body=%q)", err, w.Body.String())
+		}
+		return out
+	}
+
+	slow := "/api/logs?endpoint=extract&key_id=12&client_ip=10.0.0.5&min_latency=1000&from=2026-01-02T00:00:00Z&to=2026-01-03T00:00:00Z"
+	out := list(slow + "&page_size=1")
+	if out.Total != 2 || len(out.Items) != 1 || out.Items[0].RequestID != "e" || out.NextCursor == "" {
+		t.Fatalf("unexpected first page: %+v", out)
+	}
+	out = list(slow + "&page_size=1&cursor=" + out.NextCursor)
+	if len(out.Items) != 1 || out.Items[0].RequestID != "a" {
+		t.Fatalf("unexpected cursor page: %+v", out)
+	}
+
+	out = list("/api/logs?q=100%25")
+	if out.Total != 1 || out.Items[0].RequestID != "d" {
+		t.Fatalf("unexpected body search result: %+v", out)
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/api/logs?cursor=abc", nil)
+	handleListLogs(c, logs)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("unexpected status for bad cursor: got %d want %d", w.Code, http.StatusBadRequest)
+	}
+}
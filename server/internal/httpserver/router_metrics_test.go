@@ -0,0 +1,100 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"tavily-proxy/server/internal/config"
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/metrics"
+	"tavily-proxy/server/internal/services"
+)
+
+func TestMetrics_RequiresMasterKeyAndReportsProxyTraffic(t *testing.T) {
+	t.Parallel()
+
+	gin.SetMode(gin.TestMode)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	master := services.NewMasterKeyService(database, logger)
+	if err := master.LoadOrCreate(ctx); err != nil {
+		t.Fatalf("master key init: %v", err)
+	}
+	keys := services.NewKeyService(database, logger)
+	if _, err := keys.Create(ctx, "tvly-test", "primary", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	m := metrics.New(services.StatEndpoint)
+	m.RegisterKeys(keys.List)
+	proxy := services.NewTavilyProxy(upstream.URL, 5*time.Second, keys, services.NewLogService(database, logger), nil, logger).
+		WithMetrics(m)
+
+	router := NewRouter(Dependencies{
+		Config:           config.Config{MetricsRequireAuth: true},
+		MasterKeyService: master,
+		TavilyProxy:      proxy,
+		Metrics:          m,
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/search", bytes.NewReader([]byte(`{"query":"hello"}`)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+master.Get())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected proxy status: got %d want %d", w.Code, http.StatusOK)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected unauthenticated status: got %d want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+master.Get())
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected metrics status: got %d want %d", w.Code, http.StatusOK)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`tavily_proxy_requests_total{endpoint="/search",key="primary",status="200"} 1`,
+		`tavily_proxy_key_remaining_quota{id="1",key="primary"} 999`,
+		`tavily_proxy_keys_active 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("metrics output missing %q:\n%s", want, body)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"tavily-proxy/server/internal/config"
+	"tavily-proxy/server/internal/jobs"
+	"tavily-proxy/server/internal/metrics"
 	"tavily-proxy/server/internal/services"
 
 	"log/slog"
@@ -14,13 +16,19 @@ type Dependencies struct {
 	Config           config.Config
 	EmbeddedPublic   embed.FS
 	MasterKeyService *services.MasterKeyService
+	ClientKeyService *services.ClientKeyService
 	SettingsService  *services.SettingsService
 	KeyService       *services.KeyService
 	QuotaSyncService *services.QuotaSyncService
 	QuotaSyncJob     *services.QuotaSyncJobService
 	LogService       *services.LogService
 	StatsService     *services.StatsService
+	LogWriter        *services.LogWriter
+	Metrics          *metrics.Metrics
+	Jobs             *jobs.Monitor
+	Notifications    *services.NotificationService
 	TavilyProxy      *services.TavilyProxy
+	ResponseCache    *services.ResponseCache
 	Logger           *slog.Logger
 }
 
@@ -2,15 +2,19 @@ package jobs
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"sync/atomic"
 	"time"
 
+	"tavily-proxy/server/internal/metrics"
 	"tavily-proxy/server/internal/services"
 )
 
-func StartAutoQuotaSync(ctx context.Context, settings *services.SettingsService, sync *services.QuotaSyncService, logger *slog.Logger) {
+func StartAutoQuotaSync(ctx context.Context, settings *services.SettingsService, sync *services.QuotaSyncService, events *services.EventBus, m *metrics.Metrics, mon *Monitor, logger *slog.Logger) {
 	var running atomic.Bool
+	// A run syncs every key, at most one a minute with the request interval.
+	mon.register("auto_quota_sync", 30*time.Second, 2*time.Hour)
 
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -24,6 +28,7 @@ func StartAutoQuotaSync(ctx context.Context, settings *services.SettingsService,
 				if running.Load() {
 					continue
 				}
+				mon.beat("auto_quota_sync")
 
 				enabled, err := settings.GetBool(ctx, services.SettingAutoSyncEnabled, false)
 				if err != nil {
@@ -67,8 +72,10 @@ func StartAutoQuotaSync(ctx context.Context, settings *services.SettingsService,
 					continue
 				}
 
+				mon.begin("auto_quota_sync")
 				go func() {
 					defer running.Store(false)
+					defer mon.end("auto_quota_sync")
 
 					now := time.Now()
 					_ = settings.SetTime(context.Background(), services.SettingAutoSyncLastRunAt, now)
@@ -78,14 +85,26 @@ func StartAutoQuotaSync(ctx context.Context, settings *services.SettingsService,
 						concurrency,
 						time.Duration(requestIntervalSeconds)*time.Second,
 					)
+					m.ObserveQuotaSync("auto", time.Since(now), result.Failed, err)
 					if err != nil {
 						_ = settings.Set(context.Background(), services.SettingAutoSyncLastError, err.Error())
+						events.Publish(services.EventAutoSyncFailed, "Automatic quota sync failed: "+err.Error(), map[string]any{
+							"error":  err.Error(),
+							"total":  result.Total,
+							"failed": result.Failed,
+						})
 						logger.Error("auto-sync: sync failed", "err", err)
 						return
 					}
 
 					_ = settings.SetTime(context.Background(), services.SettingAutoSyncLastSuccessAt, time.Now())
 					_ = settings.Set(context.Background(), services.SettingAutoSyncLastError, "")
+					if result.Total > 0 && result.Failed == result.Total {
+						events.Publish(services.EventAutoSyncFailed, fmt.Sprintf("Automatic quota sync failed for all %d keys", result.Total), map[string]any{
+							"total":  result.Total,
+							"failed": result.Failed,
+						})
+					}
 					logger.Info(
 						"auto-sync: completed",
 						"total",
@@ -0,0 +1,52 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"tavily-proxy/server/internal/services"
+)
+
+// StartKeyRevalidation periodically re-probes keys marked invalid and restores
+// the ones Tavily accepts again. Each key follows its own backoff schedule, so
+// the ticker only decides how often the schedule is checked.
+func StartKeyRevalidation(ctx context.Context, quotaSync *services.QuotaSyncService, mon *Monitor, logger *slog.Logger) {
+	var running atomic.Bool
+	mon.register("key_revalidation", 5*time.Minute, 10*time.Minute)
+
+	go func() {
+		ticker := time.NewTicker(5 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !running.CompareAndSwap(false, true) {
+					continue
+				}
+
+				mon.begin("key_revalidation")
+				go func() {
+					defer running.Store(false)
+					defer mon.end("key_revalidation")
+
+					runCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+					defer cancel()
+
+					checked, restored, err := quotaSync.RevalidateDue(runCtx)
+					if err != nil {
+						logger.Error("key-revalidation: failed", "err", err)
+						return
+					}
+					if checked > 0 {
+						logger.Info("key-revalidation: completed", "checked", checked, "restored", restored)
+					}
+				}()
+			}
+		}
+	}()
+}
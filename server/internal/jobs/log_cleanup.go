@@ -6,11 +6,14 @@ import (
 	"sync/atomic"
 	"time"
 
+	"tavily-proxy/server/internal/metrics"
 	"tavily-proxy/server/internal/services"
 )
 
-func StartLogCleanup(ctx context.Context, settings *services.SettingsService, logs *services.LogService, logger *slog.Logger) {
+func StartLogCleanup(ctx context.Context, settings *services.SettingsService, logs *services.LogService, m *metrics.Metrics, mon *Monitor, logger *slog.Logger) {
 	var running atomic.Bool
+	// Archiving and deleting share a 5 minute timeout.
+	mon.register("log_cleanup", 30*time.Minute, 10*time.Minute)
 
 	go func() {
 		ticker := time.NewTicker(30 * time.Minute)
@@ -24,6 +27,7 @@ func StartLogCleanup(ctx context.Context, settings *services.SettingsService, lo
 				if running.Load() {
 					continue
 				}
+				mon.beat("log_cleanup")
 
 				retentionDays, err := settings.GetInt(ctx, services.SettingLogRetentionDays, 30)
 				if err != nil {
@@ -43,10 +47,13 @@ func StartLogCleanup(ctx context.Context, settings *services.SettingsService, lo
 					continue
 				}
 
+				mon.begin("log_cleanup")
 				go func(retention int) {
 					defer running.Store(false)
+					defer mon.end("log_cleanup")
 
-					now := time.Now()
+					// Retention days and archive files follow the reporting timezone.
+					now := time.Now().In(logs.Location())
 					_ = settings.SetTime(context.Background(), services.SettingLogCleanupLastRunAt, now)
 
 					cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -retention)
@@ -54,7 +61,15 @@ func StartLogCleanup(ctx context.Context, settings *services.SettingsService, lo
 					runCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 					defer cancel()
 
+					if err := archiveLogs(runCtx, settings, logs, cutoff, now, logger); err != nil {
+						m.ObserveLogCleanup(now, 0, err)
+						_ = settings.Set(context.Background(), services.SettingLogCleanupLastError, err.Error())
+						logger.Error("log-cleanup: archive failed, skipping delete", "err", err)
+						return
+					}
+
 					deleted, err := logs.DeleteOlderThan(runCtx, cutoff)
+					m.ObserveLogCleanup(now, deleted, err)
 					if err != nil {
 						_ = settings.Set(context.Background(), services.SettingLogCleanupLastError, err.Error())
 						logger.Error("log-cleanup: delete failed", "err", err)
@@ -68,3 +83,37 @@ func StartLogCleanup(ctx context.Context, settings *services.SettingsService, lo
 		}
 	}()
 }
+
+// archiveLogs copies the rows about to be deleted into the archive directory,
+// if one is configured, and then enforces the archive retention and size caps.
+// Deletion must not run when this fails, or the rows would be lost.
+func archiveLogs(ctx context.Context, settings *services.SettingsService, logs *services.LogService, cutoff, now time.Time, logger *slog.Logger) error {
+	dir, _, err := settings.Get(ctx, services.SettingLogArchiveDir)
+	if err != nil {
+		return err
+	}
+	if dir == "" {
+		return nil
+	}
+
+	result, err := logs.ArchiveOlderThan(ctx, dir, cutoff)
+	if err != nil {
+		return err
+	}
+	if len(result.Files) > 0 {
+		_ = settings.Set(context.Background(), services.SettingLogArchiveLastPath, result.Files[len(result.Files)-1])
+	}
+
+	retentionDays, _ := settings.GetInt(ctx, services.SettingLogArchiveRetentionDays, 0)
+	maxMB, _ := settings.GetInt(ctx, services.SettingLogArchiveMaxMB, 0)
+	// The files just written hold the rows about to be deleted, so the caps
+	// never remove them, even when they alone exceed archive_max_mb.
+	removed, err := services.PruneLogArchives(dir, retentionDays, int64(maxMB)<<20, now, result.Files...)
+	if err != nil {
+		// The fresh archive is already on disk; a failed prune only leaves extra files.
+		logger.Error("log-cleanup: archive prune failed", "err", err)
+	}
+
+	logger.Info("log-cleanup: archived", "rows", result.Rows, "attempts", result.Attempts, "files", len(result.Files), "pruned", removed)
+	return nil
+}
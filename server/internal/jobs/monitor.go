@@ -0,0 +1,116 @@
+package jobs
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Monitor tracks a heartbeat per background job so health checks can tell a
+// live loop from one that has exited or wedged. A loop beats on every tick
+// that finds nothing to do and when a run finishes; while a run is in flight
+// the job is judged by how long that run has taken instead. Every method is
+// safe to call on a nil *Monitor.
+type Monitor struct {
+	mu   sync.Mutex
+	jobs map[string]*jobBeat
+}
+
+type jobBeat struct {
+	interval time.Duration
+	budget   time.Duration
+	last     time.Time
+	runStart *time.Time
+}
+
+// JobStatus is one job's heartbeat. A job is stale once it has missed three
+// beats in a row, or when its current run has taken longer than its budget.
+type JobStatus struct {
+	Name         string     `json:"name"`
+	LastBeat     time.Time  `json:"last_beat"`
+	Interval     string     `json:"interval"`
+	RunStartedAt *time.Time `json:"run_started_at,omitempty"`
+	RunBudget    string     `json:"run_budget"`
+	Stale        bool       `json:"stale"`
+}
+
+func NewMonitor() *Monitor {
+	return &Monitor{jobs: make(map[string]*jobBeat)}
+}
+
+// register records a job that is expected to beat at least once per interval
+// and to finish each run within budget.
+func (m *Monitor) register(name string, interval, budget time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[name] = &jobBeat{interval: interval, budget: budget, last: time.Now()}
+}
+
+// beat records a tick on which the job had nothing to run.
+func (m *Monitor) beat(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.jobs[name]; ok {
+		j.last = time.Now()
+	}
+}
+
+// begin records the start of a run.
+func (m *Monitor) begin(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.jobs[name]; ok {
+		now := time.Now()
+		j.runStart = &now
+	}
+}
+
+// end records a finished run, which counts as a beat.
+func (m *Monitor) end(name string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if j, ok := m.jobs[name]; ok {
+		j.runStart = nil
+		j.last = time.Now()
+	}
+}
+
+// Status reports every registered job, sorted by name.
+func (m *Monitor) Status(now time.Time) []JobStatus {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]JobStatus, 0, len(m.jobs))
+	for name, j := range m.jobs {
+		status := JobStatus{
+			Name:      name,
+			LastBeat:  j.last,
+			Interval:  j.interval.String(),
+			RunBudget: j.budget.String(),
+		}
+		if j.runStart != nil {
+			started := *j.runStart
+			status.RunStartedAt = &started
+			status.Stale = now.Sub(started) > j.budget
+		} else {
+			status.Stale = now.Sub(j.last) > 3*j.interval
+		}
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].Name < out[k].Name })
+	return out
+}
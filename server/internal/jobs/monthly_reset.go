@@ -8,24 +8,30 @@ import (
 	"tavily-proxy/server/internal/services"
 )
 
-func StartMonthlyReset(ctx context.Context, keys *services.KeyService, logger *slog.Logger) {
+// StartMonthlyReset zeroes every key's used quota at midnight on the 1st in loc.
+func StartMonthlyReset(ctx context.Context, keys *services.KeyService, loc *time.Location, mon *Monitor, logger *slog.Logger) {
+	mon.register("monthly_reset", 24*time.Hour, time.Hour)
 	go func() {
 		for {
-			now := time.Now()
-			nextMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+			now := time.Now().In(loc)
+			nextMidnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, loc)
 			timer := time.NewTimer(time.Until(nextMidnight))
 			select {
 			case <-ctx.Done():
 				timer.Stop()
 				return
 			case <-timer.C:
-				if nextMidnight.Day() == 1 {
-					if err := keys.ResetAllUsage(context.Background()); err != nil {
-						logger.Error("monthly reset failed", "err", err)
-					} else {
-						logger.Info("monthly quota reset completed")
-					}
+				if nextMidnight.Day() != 1 {
+					mon.beat("monthly_reset")
+					continue
 				}
+				mon.begin("monthly_reset")
+				if err := keys.ResetAllUsage(context.Background()); err != nil {
+					logger.Error("monthly reset failed", "err", err)
+				} else {
+					logger.Info("monthly quota reset completed")
+				}
+				mon.end("monthly_reset")
 			}
 		}
 	}()
@@ -3,19 +3,32 @@ package mcpserver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strings"
 	"time"
 
+	"github.com/modelcontextprotocol/go-sdk/auth"
 	"github.com/modelcontextprotocol/go-sdk/mcp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
+	"tavily-proxy/server/internal/models"
 	"tavily-proxy/server/internal/services"
+	"tavily-proxy/server/internal/tracing"
 )
 
+var tracer = tracing.Tracer("mcpserver")
+
+var errUnauthorized = errors.New("unauthorized")
+
 type Dependencies struct {
-	MasterKey *services.MasterKeyService
-	Proxy     *services.TavilyProxy
+	MasterKey  *services.MasterKeyService
+	ClientKeys *services.ClientKeyService
+	Proxy      *services.TavilyProxy
 }
 
 func NewHandler(deps Dependencies) http.Handler {
@@ -24,27 +37,27 @@ func NewHandler(deps Dependencies) http.Handler {
 		Version: "0.1.0",
 	}, nil)
 
-	addProxyTool(server, deps.Proxy, &mcp.Tool{
+	addProxyTool(server, deps, &mcp.Tool{
 		Name:        "tavily-search",
 		Description: "Execute a search query using Tavily Search (via Tavily Proxy Pool). Returns ranked results and optional answer/raw_content/images/usage.",
 		InputSchema: tavilySearchInputSchema,
 	}, http.MethodPost, "/search")
-	addProxyTool(server, deps.Proxy, &mcp.Tool{
+	addProxyTool(server, deps, &mcp.Tool{
 		Name:        "tavily-extract",
 		Description: "Extract structured content from URLs (via Tavily Proxy Pool)",
 		InputSchema: tavilyExtractInputSchema,
 	}, http.MethodPost, "/extract")
-	addProxyTool(server, deps.Proxy, &mcp.Tool{
+	addProxyTool(server, deps, &mcp.Tool{
 		Name:        "tavily-crawl",
 		Description: "Crawl a website starting from a root URL (via Tavily Proxy Pool)",
 		InputSchema: tavilyCrawlInputSchema,
 	}, http.MethodPost, "/crawl")
-	addProxyTool(server, deps.Proxy, &mcp.Tool{
+	addProxyTool(server, deps, &mcp.Tool{
 		Name:        "tavily-map",
 		Description: "Map a website's URL structure (via Tavily Proxy Pool)",
 		InputSchema: tavilyMapInputSchema,
 	}, http.MethodPost, "/map")
-	addProxyTool(server, deps.Proxy, &mcp.Tool{
+	addProxyTool(server, deps, &mcp.Tool{
 		Name:        "tavily-usage",
 		Description: "Get usage/quota info (via Tavily Proxy Pool)",
 		InputSchema: map[string]any{"type": "object", "properties": map[string]any{}, "additionalProperties": false},
@@ -56,18 +69,70 @@ func NewHandler(deps Dependencies) http.Handler {
 		SessionTimeout: 10 * time.Minute,
 	})
 
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := parseBearerToken(r.Header.Get("Authorization"))
-		if !deps.MasterKey.Authenticate(token) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
+	return auth.RequireBearerToken(verifier(deps), nil)(base)
+}
+
+// clientKeyInfo is the TokenInfo.Extra entry holding the client key that
+// authenticated the HTTP request; it is absent for the Master Key.
+const clientKeyInfo = "client_key"
+
+// verifier authenticates every HTTP request to the endpoint. The SDK hands the
+// TokenInfo it returns to the tool calls carried by that request, so client
+// keys are checked against the request that made the call rather than the one
+// that opened the session.
+func verifier(deps Dependencies) auth.TokenVerifier {
+	return func(ctx context.Context, token string, _ *http.Request) (*auth.TokenInfo, error) {
+		// Keys do not expire here; the SDK only insists on an expiration.
+		info := &auth.TokenInfo{Expiration: time.Now().Add(time.Minute)}
+		if deps.MasterKey.Authenticate(token) {
+			return info, nil
 		}
-		base.ServeHTTP(w, r)
-	})
+		if deps.ClientKeys != nil {
+			client, err := deps.ClientKeys.Authenticate(ctx, token)
+			if err != nil {
+				return nil, errors.New("internal error")
+			}
+			if client != nil {
+				info.Extra = map[string]any{clientKeyInfo: client}
+				return info, nil
+			}
+		}
+		return nil, auth.ErrInvalidToken
+	}
 }
 
-func addProxyTool(server *mcp.Server, proxy *services.TavilyProxy, tool *mcp.Tool, method, path string) {
+func addProxyTool(server *mcp.Server, deps Dependencies, tool *mcp.Tool, method, path string) {
 	server.AddTool(tool, func(ctx context.Context, req *mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		// Sessions outlive the HTTP request that carried the call, so pick the
+		// client's trace up from the request headers when ctx has none.
+		if req.Extra != nil && !trace.SpanContextFromContext(ctx).IsValid() {
+			ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(req.Extra.Header))
+		}
+		ctx, span := tracer.Start(ctx, "mcp.tool "+tool.Name, trace.WithAttributes(
+			attribute.String("mcp.tool", tool.Name),
+			attribute.String("tavily.endpoint", path),
+		))
+		defer span.End()
+
+		// Calls that did not come through the authenticated HTTP handler
+		// carry no TokenInfo and are refused.
+		if req.Extra == nil || req.Extra.TokenInfo == nil {
+			return toolError(errUnauthorized), nil
+		}
+		var clientKeyID uint
+		var clientKeyName string
+		if client, ok := req.Extra.TokenInfo.Extra[clientKeyInfo].(*models.ClientKey); ok {
+			if err := deps.ClientKeys.Admit(ctx, client, path); err != nil {
+				// A key deleted mid-session is refused like an unknown one.
+				if errors.Is(err, services.ErrClientKeyNotFound) {
+					err = errUnauthorized
+				}
+				return toolError(err), nil
+			}
+			clientKeyID = client.ID
+			clientKeyName = client.Name
+		}
+
 		var body []byte
 		if method == http.MethodPost {
 			if len(req.Params.Arguments) > 0 {
@@ -83,23 +148,26 @@ func addProxyTool(server *mcp.Server, proxy *services.TavilyProxy, tool *mcp.Too
 			headers.Set("Content-Type", "application/json")
 		}
 
-		resp, err := proxy.Do(ctx, services.ProxyRequest{
-			Method:      method,
-			Path:        path,
-			Headers:     headers,
-			Body:        body,
-			ClientIP:    "mcp",
-			ContentType: "application/json",
+		resp, err := deps.Proxy.Do(ctx, services.ProxyRequest{
+			Method:        method,
+			Path:          path,
+			Headers:       headers,
+			Body:          body,
+			ClientIP:      "mcp",
+			ContentType:   "application/json",
+			ClientKeyID:   clientKeyID,
+			ClientKeyName: clientKeyName,
 		})
+		// Client budgets only count calls that reached Tavily.
+		if clientKeyID != 0 && (errors.Is(err, services.ErrNoAvailableKeys) || err == nil && resp.CacheStatus == services.CacheStatusHit) {
+			_ = deps.ClientKeys.Refund(context.WithoutCancel(ctx), clientKeyID)
+		}
 		if err != nil {
-			return &mcp.CallToolResult{
-				IsError: true,
-				Content: []mcp.Content{
-					&mcp.TextContent{Text: err.Error()},
-				},
-				StructuredContent: map[string]any{"error": err.Error()},
-			}, nil
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			return toolError(err), nil
 		}
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
 		text := string(resp.Body)
 
@@ -134,6 +202,16 @@ func addProxyTool(server *mcp.Server, proxy *services.TavilyProxy, tool *mcp.Too
 	})
 }
 
+func toolError(err error) *mcp.CallToolResult {
+	return &mcp.CallToolResult{
+		IsError: true,
+		Content: []mcp.Content{
+			&mcp.TextContent{Text: err.Error()},
+		},
+		StructuredContent: map[string]any{"error": err.Error()},
+	}
+}
+
 var tavilySearchInputSchema = map[string]any{
 	"type":                 "object",
 	"additionalProperties": true,
@@ -480,17 +558,3 @@ var tavilyCrawlInputSchema = map[string]any{
 		},
 	},
 }
-
-func parseBearerToken(authHeader string) string {
-	if authHeader == "" {
-		return ""
-	}
-	parts := strings.SplitN(authHeader, " ", 2)
-	if len(parts) != 2 {
-		return ""
-	}
-	if !strings.EqualFold(parts[0], "Bearer") {
-		return ""
-	}
-	return strings.TrimSpace(parts[1])
-}
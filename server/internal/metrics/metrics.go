@@ -0,0 +1,218 @@
+// Package metrics exposes the proxy's Prometheus series. Every recorder is
+// safe to call on a nil *Metrics, so components work unchanged without one.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"tavily-proxy/server/internal/models"
+)
+
+const namespace = "tavily_proxy"
+
+type Metrics struct {
+	registry *prometheus.Registry
+	endpoint func(path string) string
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	failovers       *prometheus.CounterVec
+
+	quotaSyncDuration *prometheus.HistogramVec
+	quotaSyncFailures *prometheus.CounterVec
+
+	logCleanupRuns    *prometheus.CounterVec
+	logCleanupDeleted prometheus.Counter
+	logCleanupLastRun prometheus.Gauge
+}
+
+// New builds the collectors. endpoint maps a request path to its endpoint
+// label; the proxy forwards any path, so it must bound the label's values.
+func New(endpoint func(path string) string) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		endpoint: endpoint,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Proxied requests by endpoint, final status and upstream key alias.",
+		}, []string{"endpoint", "status", "key"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Upstream latency of proxied requests.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 40, 80, 150},
+		}, []string{"endpoint", "status", "key"}),
+		failovers: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "failovers_total",
+			Help:      "Upstream attempts abandoned for another key, by endpoint and reason.",
+		}, []string{"endpoint", "reason"}),
+		quotaSyncDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "quota_sync_duration_seconds",
+			Help:      "Duration of quota sync runs.",
+			Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800},
+		}, []string{"trigger"}),
+		quotaSyncFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "quota_sync_failures_total",
+			Help:      "Keys that failed to sync, plus runs that failed outright.",
+		}, []string{"trigger"}),
+		logCleanupRuns: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_cleanup_runs_total",
+			Help:      "Log retention cleanup runs by result.",
+		}, []string{"result"}),
+		logCleanupDeleted: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "log_cleanup_deleted_total",
+			Help:      "Request logs deleted by retention cleanup.",
+		}),
+		logCleanupLastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "log_cleanup_last_run_timestamp_seconds",
+			Help:      "Unix time of the last log cleanup run.",
+		}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests, m.requestDuration, m.failovers,
+		m.quotaSyncDuration, m.quotaSyncFailures,
+		m.logCleanupRuns, m.logCleanupDeleted, m.logCleanupLastRun,
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest records one proxied request. key is the zero value when no
+// upstream key served it (cache hits, failures before any key answered).
+func (m *Metrics) ObserveRequest(endpoint string, status int, key models.APIKey, latency time.Duration) {
+	if m == nil {
+		return
+	}
+	labels := prometheus.Labels{"endpoint": m.endpoint(endpoint), "status": strconv.Itoa(status), "key": keyLabel(key)}
+	m.requests.With(labels).Inc()
+	m.requestDuration.With(labels).Observe(latency.Seconds())
+}
+
+// ObserveFailover records an upstream attempt that was abandoned. reason is
+// the upstream status, or "error" for transport failures.
+func (m *Metrics) ObserveFailover(endpoint, reason string) {
+	if m == nil {
+		return
+	}
+	m.failovers.WithLabelValues(m.endpoint(endpoint), reason).Inc()
+}
+
+// ObserveQuotaSync records a finished sync run; trigger is "auto" or "manual".
+func (m *Metrics) ObserveQuotaSync(trigger string, duration time.Duration, failedKeys int, err error) {
+	if m == nil {
+		return
+	}
+	m.quotaSyncDuration.WithLabelValues(trigger).Observe(duration.Seconds())
+	if err != nil {
+		failedKeys++
+	}
+	if failedKeys > 0 {
+		m.quotaSyncFailures.WithLabelValues(trigger).Add(float64(failedKeys))
+	}
+}
+
+// ObserveLogCleanup records a retention cleanup run.
+func (m *Metrics) ObserveLogCleanup(at time.Time, deleted int64, err error) {
+	if m == nil {
+		return
+	}
+	m.logCleanupLastRun.Set(float64(at.Unix()))
+	if err != nil {
+		m.logCleanupRuns.WithLabelValues("error").Inc()
+		return
+	}
+	m.logCleanupRuns.WithLabelValues("success").Inc()
+	m.logCleanupDeleted.Add(float64(deleted))
+}
+
+// RegisterKeys exports key gauges, read from list on every scrape.
+func (m *Metrics) RegisterKeys(list func(ctx context.Context) ([]models.APIKey, error)) {
+	m.registry.MustRegister(&keyCollector{list: list})
+}
+
+// RegisterGaugeFunc exports a value read on every scrape, such as a queue depth.
+func (m *Metrics) RegisterGaugeFunc(name, help string, fn func() float64) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help}, fn))
+}
+
+// RegisterCounterFunc exports a monotonically increasing value read on every scrape.
+func (m *Metrics) RegisterCounterFunc(name, help string, fn func() float64) {
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help}, fn))
+}
+
+var (
+	keyRemainingDesc = prometheus.NewDesc(namespace+"_key_remaining_quota", "Remaining credits per upstream key.", []string{"id", "key"}, nil)
+	keysActiveDesc   = prometheus.NewDesc(namespace+"_keys_active", "Keys that are enabled, valid and have quota left.", nil, nil)
+	keysInvalidDesc  = prometheus.NewDesc(namespace+"_keys_invalid", "Keys marked invalid by the upstream.", nil, nil)
+	keysTotalDesc    = prometheus.NewDesc(namespace+"_keys_total", "All configured upstream keys.", nil, nil)
+)
+
+type keyCollector struct {
+	list func(ctx context.Context) ([]models.APIKey, error)
+}
+
+func (k *keyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- keyRemainingDesc
+	ch <- keysActiveDesc
+	ch <- keysInvalidDesc
+	ch <- keysTotalDesc
+}
+
+func (k *keyCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	keys, err := k.list(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(keysTotalDesc, err)
+		return
+	}
+
+	var active, invalid int
+	for _, key := range keys {
+		remaining := key.TotalQuota - key.UsedQuota
+		if remaining < 0 {
+			remaining = 0
+		}
+		ch <- prometheus.MustNewConstMetric(keyRemainingDesc, prometheus.GaugeValue, float64(remaining), strconv.FormatUint(uint64(key.ID), 10), keyLabel(key))
+		if key.IsInvalid {
+			invalid++
+		} else if key.IsActive && key.UsedQuota < key.TotalQuota {
+			active++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(keysActiveDesc, prometheus.GaugeValue, float64(active))
+	ch <- prometheus.MustNewConstMetric(keysInvalidDesc, prometheus.GaugeValue, float64(invalid))
+	ch <- prometheus.MustNewConstMetric(keysTotalDesc, prometheus.GaugeValue, float64(len(keys)))
+}
+
+// keyLabel identifies a key by alias, falling back to its ID.
+func keyLabel(key models.APIKey) string {
+	switch {
+	case key.Alias != "":
+		return key.Alias
+	case key.ID != 0:
+		return "key-" + strconv.FormatUint(uint64(key.ID), 10)
+	default:
+		return "none"
+	}
+}
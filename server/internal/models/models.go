@@ -13,6 +13,19 @@ type APIKey struct {
 	LastUsedAt *time.Time `json:"last_used_at"`
 	CreatedAt  time.Time  `json:"created_at"`
 	UpdatedAt  time.Time  `json:"updated_at"`
+
+	// CooldownUntil takes a rate-limited key out of rotation for a while;
+	// CooldownStrikes counts consecutive 429s and drives the backoff.
+	CooldownUntil      *time.Time `gorm:"index" json:"cooldown_until"`
+	CooldownStrikes    int        `gorm:"not null;default:0" json:"cooldown_strikes"`
+	RateLimitPerMinute int        `gorm:"not null;default:0" json:"rate_limit_per_minute"`
+
+	// Invalid keys are re-probed on a backoff schedule and restored once
+	// Tavily accepts them again.
+	InvalidReason      string     `gorm:"not null;default:''" json:"invalid_reason"`
+	InvalidatedAt      *time.Time `json:"invalidated_at"`
+	RevalidateAttempts int        `gorm:"not null;default:0" json:"revalidate_attempts"`
+	NextRevalidateAt   *time.Time `gorm:"index" json:"next_revalidate_at"`
 }
 
 type RequestLog struct {
@@ -22,22 +35,101 @@ type RequestLog struct {
 	KeyAlias          string    `json:"key_alias"`
 	Endpoint          string    `gorm:"index;not null" json:"endpoint"`
 	StatusCode        int       `json:"status_code"`
-	LatencyMs         int64     `json:"latency"`
+	LatencyMs         int64     `gorm:"index" json:"latency"`
 	RequestBody       string    `gorm:"type:text" json:"request_body,omitempty"`
 	RequestTruncated  bool      `gorm:"not null;default:false" json:"request_truncated"`
 	ResponseBody      string    `gorm:"type:text" json:"response_body,omitempty"`
 	ResponseTruncated bool      `gorm:"not null;default:false" json:"response_truncated"`
-	ClientIP          string    `json:"client_ip"`
+	ClientIP          string    `gorm:"index" json:"client_ip"`
+	ClientKeyID       uint      `gorm:"index" json:"client_key_id"`
+	ClientKeyName     string    `json:"client_key_name"`
+	CacheHit          bool      `gorm:"not null;default:false" json:"cache_hit"`
+	Coalesced         bool      `gorm:"not null;default:false" json:"coalesced"`
+	Credits           int       `gorm:"not null;default:0" json:"credits"`
+	CreditsEstimated  bool      `gorm:"not null;default:false" json:"credits_estimated"`
+	Attempt           int       `gorm:"not null;default:0" json:"attempt"`
+	Hedged            bool      `gorm:"not null;default:false" json:"hedged"`
+	Query             string    `gorm:"index" json:"query,omitempty"`
+	Topic             string    `gorm:"index" json:"topic,omitempty"`
+	SearchDepth       string    `json:"search_depth,omitempty"`
+	MaxResults        int       `gorm:"not null;default:0" json:"max_results,omitempty"`
+	IncludeDomains    string    `gorm:"type:text" json:"include_domains,omitempty"`
+	TraceID           string    `gorm:"index" json:"trace_id,omitempty"`
 	CreatedAt         time.Time `gorm:"index" json:"created_at"`
 }
 
+// RequestAttempt is one upstream call made while serving a RequestLog, linked
+// by RequestID.
+type RequestAttempt struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	RequestID  string    `gorm:"index;not null" json:"request_id"`
+	Attempt    int       `gorm:"not null" json:"attempt"`
+	KeyID      uint      `gorm:"index" json:"key_id"`
+	KeyAlias   string    `json:"key_alias"`
+	Endpoint   string    `gorm:"not null" json:"endpoint"`
+	StatusCode int       `json:"status_code"`
+	LatencyMs  int64     `json:"latency"`
+	Outcome    string    `gorm:"not null" json:"outcome"`
+	Error      string    `gorm:"type:text" json:"error,omitempty"`
+	Hedged     bool      `gorm:"not null;default:false" json:"hedged"`
+	CreatedAt  time.Time `gorm:"index" json:"created_at"`
+}
+
+type ClientKey struct {
+	ID               uint       `gorm:"primaryKey" json:"id"`
+	Name             string     `gorm:"not null" json:"name"`
+	KeyHash          string     `gorm:"uniqueIndex;not null" json:"-"`
+	KeyPrefix        string     `gorm:"not null;default:''" json:"key_prefix"`
+	MonthlyLimit     int        `gorm:"not null;default:0" json:"monthly_limit"`
+	DailyLimit       int        `gorm:"not null;default:0" json:"daily_limit"`
+	MonthlyUsed      int        `gorm:"not null;default:0" json:"monthly_used"`
+	DailyUsed        int        `gorm:"not null;default:0" json:"daily_used"`
+	UsageMonth       string     `gorm:"not null;default:''" json:"-"`
+	UsageDay         string     `gorm:"not null;default:''" json:"-"`
+	AllowedEndpoints string     `gorm:"not null;default:''" json:"allowed_endpoints"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	IsActive         bool       `gorm:"not null;default:true" json:"is_active"`
+	LastUsedAt       *time.Time `json:"last_used_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+// RequestStat is a request counter for one time bucket. Endpoint, KeyID and
+// StatusCode are dimensions; a zero value means "all", so the overall totals
+// are the rows where all three are zero. Each row sets at most one of KeyID
+// and StatusCode. LatencySumMs over LatencySamples is the average latency of
+// the requests whose latency was measured. The status-class and latency
+// columns are only filled for requests recorded since they were added, so
+// rates derived from them use their own totals rather than Count.
+// BucketStart is the Unix time an hour bucket starts at, which keeps the two
+// passes through a repeated DST hour apart; it is 0 for day and month buckets
+// and for hour buckets written before it was added.
 type RequestStat struct {
-	ID          uint      `gorm:"primaryKey" json:"id"`
-	Granularity string    `gorm:"not null;index:idx_request_stat_bucket,unique" json:"granularity"`
-	Bucket      string    `gorm:"not null;index:idx_request_stat_bucket,unique" json:"bucket"`
-	Endpoint    string    `gorm:"not null;default:'';index:idx_request_stat_bucket,unique" json:"endpoint"`
-	Count       int64     `gorm:"not null;default:0" json:"count"`
-	UpdatedAt   time.Time `gorm:"index" json:"updated_at"`
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	Granularity    string    `gorm:"not null;index:idx_request_stat_key,unique" json:"granularity"`
+	Bucket         string    `gorm:"not null;index:idx_request_stat_key,unique" json:"bucket"`
+	Endpoint       string    `gorm:"not null;default:'';index:idx_request_stat_key,unique" json:"endpoint"`
+	KeyID          uint      `gorm:"not null;default:0;index:idx_request_stat_key,unique" json:"key_id"`
+	StatusCode     int       `gorm:"not null;default:0;index:idx_request_stat_key,unique" json:"status_code"`
+	BucketStart    int64     `gorm:"not null;default:0;index:idx_request_stat_key,unique" json:"bucket_start"`
+	Count          int64     `gorm:"not null;default:0" json:"count"`
+	CacheHits      int64     `gorm:"not null;default:0" json:"cache_hits"`
+	Status2xx      int64     `gorm:"column:status_2xx;not null;default:0" json:"status_2xx"`
+	Status3xx      int64     `gorm:"column:status_3xx;not null;default:0" json:"status_3xx"`
+	Status4xx      int64     `gorm:"column:status_4xx;not null;default:0" json:"status_4xx"`
+	Status5xx      int64     `gorm:"column:status_5xx;not null;default:0" json:"status_5xx"`
+	LatencySumMs   int64     `gorm:"not null;default:0" json:"latency_sum_ms"`
+	LatencySamples int64     `gorm:"not null;default:0" json:"latency_samples"`
+	UpdatedAt      time.Time `gorm:"index" json:"updated_at"`
+}
+
+// KeyUsageSnapshot records a key's quota as reported by Tavily at one sync.
+type KeyUsageSnapshot struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	KeyID      uint      `gorm:"not null;index:idx_key_usage_snapshot_key_time" json:"key_id"`
+	UsedQuota  int       `gorm:"not null" json:"used_quota"`
+	TotalQuota int       `gorm:"not null" json:"total_quota"`
+	CreatedAt  time.Time `gorm:"index:idx_key_usage_snapshot_key_time" json:"created_at"`
 }
 
 type Setting struct {
@@ -45,3 +137,47 @@ type Setting struct {
 	Value     string    `gorm:"not null" json:"value"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+type ResponseCacheEntry struct {
+	Key             string    `gorm:"primaryKey" json:"key"`
+	Endpoint        string    `gorm:"not null;default:''" json:"endpoint"`
+	StatusCode      int       `json:"status_code"`
+	Headers         string    `gorm:"type:text" json:"-"`
+	Body            []byte    `json:"-"`
+	TavilyRequestID string    `json:"tavily_request_id"`
+	ExpiresAt       time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// NotificationTarget is a webhook that receives pool and key events. Events is
+// a comma-separated list of event types; empty means every type.
+type NotificationTarget struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Name      string    `gorm:"not null" json:"name"`
+	URL       string    `gorm:"not null" json:"url"`
+	Format    string    `gorm:"not null;default:'json'" json:"format"`
+	Secret    string    `gorm:"not null;default:''" json:"-"`
+	Events    string    `gorm:"not null;default:''" json:"events"`
+	IsActive  bool      `gorm:"not null;default:true" json:"is_active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NotificationDelivery is one event queued for one target, kept as the
+// delivery log once it has been delivered or has run out of retries.
+type NotificationDelivery struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	TargetID      uint       `gorm:"index;not null" json:"target_id"`
+	EventID       string     `gorm:"index;not null" json:"event_id"`
+	EventType     string     `gorm:"not null" json:"event_type"`
+	Payload       string     `gorm:"type:text" json:"payload"`
+	Status        string     `gorm:"index;not null" json:"status"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	StatusCode    int        `json:"status_code"`
+	Error         string     `gorm:"type:text" json:"error,omitempty"`
+	NextAttemptAt *time.Time `gorm:"index" json:"next_attempt_at"`
+	DeliveredAt   *time.Time `json:"delivered_at"`
+	CreatedAt     time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const clientKeyPrefix = "tpk-"
+
+var (
+	ErrClientKeyDisabled           = errors.New("client key disabled")
+	ErrClientKeyExpired            = errors.New("client key expired")
+	ErrClientKeyEndpointNotAllowed = errors.New("endpoint not allowed for client key")
+	ErrClientKeyQuotaExceeded      = errors.New("client key quota exceeded")
+	ErrClientKeyNotFound           = errors.New("client key not found")
+)
+
+type ClientKeyService struct {
+	db     *gorm.DB
+	logger *slog.Logger
+	// resetLoc is the timezone whose midnights roll the daily and monthly
+	// budgets over.
+	resetLoc *time.Location
+}
+
+func NewClientKeyService(db *gorm.DB, logger *slog.Logger) *ClientKeyService {
+	return &ClientKeyService{db: db, logger: logger, resetLoc: time.Local}
+}
+
+// WithResetTimezone sets the timezone in which client key budgets reset, the
+// same one the upstream key quotas reset in.
+func (s *ClientKeyService) WithResetTimezone(loc *time.Location) *ClientKeyService {
+	s.resetLoc = loc
+	return s
+}
+
+func (s *ClientKeyService) now() time.Time {
+	return time.Now().In(s.resetLoc)
+}
+
+type ClientKeyInput struct {
+	Name             string     `json:"name"`
+	MonthlyLimit     int        `json:"monthly_limit"`
+	DailyLimit       int        `json:"daily_limit"`
+	AllowedEndpoints []string   `json:"allowed_endpoints"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+}
+
+type ClientKeyUpdate struct {
+	Name             *string    `json:"name"`
+	MonthlyLimit     *int       `json:"monthly_limit"`
+	DailyLimit       *int       `json:"daily_limit"`
+	AllowedEndpoints *[]string  `json:"allowed_endpoints"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+	ClearExpiresAt   bool       `json:"clear_expires_at"`
+	IsActive         *bool      `json:"is_active"`
+	ResetUsage       bool       `json:"reset_usage"`
+}
+
+func (s *ClientKeyService) List(ctx context.Context) ([]models.ClientKey, error) {
+	var items []models.ClientKey
+	if err := s.db.WithContext(ctx).Order("id desc").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	now := s.now()
+	for i := range items {
+		rollClientKeyUsage(&items[i], now)
+	}
+	return items, nil
+}
+
+func (s *ClientKeyService) Get(ctx context.Context, id uint) (*models.ClientKey, error) {
+	var item models.ClientKey
+	if err := s.db.WithContext(ctx).First(&item, id).Error; err != nil {
+		return nil, err
+	}
+	rollClientKeyUsage(&item, s.now())
+	return &item, nil
+}
+
+// Create stores a new client key and returns the record together with the plain
+// secret. The secret is only ever returned here and from RotateSecret.
+func (s *ClientKeyService) Create(ctx context.Context, in ClientKeyInput) (*models.ClientKey, string, error) {
+	secret, err := generateClientKeySecret()
+	if err != nil {
+		return nil, "", err
+	}
+	record := models.ClientKey{
+		Name:             strings.TrimSpace(in.Name),
+		KeyHash:          hashClientKey(secret),
+		KeyPrefix:        secret[:len(clientKeyPrefix)+4],
+		MonthlyLimit:     clampNonNegative(in.MonthlyLimit),
+		DailyLimit:       clampNonNegative(in.DailyLimit),
+		AllowedEndpoints: JoinEndpoints(in.AllowedEndpoints),
+		ExpiresAt:        in.ExpiresAt,
+		IsActive:         true,
+	}
+	if err := s.db.WithContext(ctx).Create(&record).Error; err != nil {
+		return nil, "", err
+	}
+	return &record, secret, nil
+}
+
+func (s *ClientKeyService) Update(ctx context.Context, id uint, upd ClientKeyUpdate) (*models.ClientKey, error) {
+	var item models.ClientKey
+	if err := s.db.WithContext(ctx).First(&item, id).Error; err != nil {
+		return nil, err
+	}
+	rollClientKeyUsage(&item, s.now())
+
+	if upd.Name != nil && strings.TrimSpace(*upd.Name) != "" {
+		item.Name = strings.TrimSpace(*upd.Name)
+	}
+	if upd.MonthlyLimit != nil {
+		item.MonthlyLimit = clampNonNegative(*upd.MonthlyLimit)
+	}
+	if upd.DailyLimit != nil {
+		item.DailyLimit = clampNonNegative(*upd.DailyLimit)
+	}
+	if upd.AllowedEndpoints != nil {
+		item.AllowedEndpoints = JoinEndpoints(*upd.AllowedEndpoints)
+	}
+	if upd.ExpiresAt != nil {
+		item.ExpiresAt = upd.ExpiresAt
+	}
+	if upd.ClearExpiresAt {
+		item.ExpiresAt = nil
+	}
+	if upd.IsActive != nil {
+		item.IsActive = *upd.IsActive
+	}
+	if upd.ResetUsage {
+		item.DailyUsed = 0
+		item.MonthlyUsed = 0
+	}
+
+	if err := s.db.WithContext(ctx).Save(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (s *ClientKeyService) RotateSecret(ctx context.Context, id uint) (*models.ClientKey, string, error) {
+	var item models.ClientKey
+	if err := s.db.WithContext(ctx).First(&item, id).Error; err != nil {
+		return nil, "", err
+	}
+	secret, err := generateClientKeySecret()
+	if err != nil {
+		return nil, "", err
+	}
+	item.KeyHash = hashClientKey(secret)
+	item.KeyPrefix = secret[:len(clientKeyPrefix)+4]
+	if err := s.db.WithContext(ctx).Save(&item).Error; err != nil {
+		return nil, "", err
+	}
+	return &item, secret, nil
+}
+
+func (s *ClientKeyService) Delete(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Delete(&models.ClientKey{}, id).Error
+}
+
+// Authenticate resolves a presented token to its client key. It returns nil
+// without error when the token does not belong to any client key.
+func (s *ClientKeyService) Authenticate(ctx context.Context, token string) (*models.ClientKey, error) {
+	token = strings.TrimSpace(token)
+	if token == "" || !strings.HasPrefix(token, clientKeyPrefix) {
+		return nil, nil
+	}
+	var item models.ClientKey
+	err := s.db.WithContext(ctx).First(&item, "key_hash = ?", hashClientKey(token)).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &item, nil
+}
+
+// Admit checks that the authenticated client key may call endpoint right now
+// and, if so, consumes one request from its daily and monthly budgets. The
+// budget check and the charge are one conditional UPDATE, so concurrent
+// requests never wait on each other's transactions.
+func (s *ClientKeyService) Admit(ctx context.Context, client *models.ClientKey, endpoint string) error {
+	now := s.now()
+	if !client.IsActive {
+		return ErrClientKeyDisabled
+	}
+	if client.ExpiresAt != nil && !now.Before(*client.ExpiresAt) {
+		return ErrClientKeyExpired
+	}
+	if !clientKeyAllowsEndpoint(client.AllowedEndpoints, endpoint) {
+		return ErrClientKeyEndpointNotAllowed
+	}
+
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	result := s.db.WithContext(ctx).Model(&models.ClientKey{}).
+		Where("id = ? AND is_active = ?", client.ID, true).
+		Where("daily_limit = 0 OR usage_day <> ? OR daily_used < daily_limit", day).
+		Where("monthly_limit = 0 OR usage_month <> ? OR monthly_used < monthly_limit", month).
+		Updates(map[string]any{
+			"daily_used":   gorm.Expr("CASE WHEN usage_day = ? THEN daily_used + 1 ELSE 1 END", day),
+			"monthly_used": gorm.Expr("CASE WHEN usage_month = ? THEN monthly_used + 1 ELSE 1 END", month),
+			"usage_day":    day,
+			"usage_month":  month,
+			"last_used_at": &now,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	// Nothing was charged: the key is over budget, or was disabled or deleted
+	// since it authenticated.
+	var current models.ClientKey
+	if err := s.db.WithContext(ctx).First(&current, client.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrClientKeyNotFound
+		}
+		return err
+	}
+	if !current.IsActive {
+		return ErrClientKeyDisabled
+	}
+	return ErrClientKeyQuotaExceeded
+}
+
+// Refund gives back a request admitted today that never reached Tavily, such
+// as a cache hit or a request no upstream key could take, so the budgets only
+// count upstream calls.
+func (s *ClientKeyService) Refund(ctx context.Context, id uint) error {
+	now := s.now()
+	return s.db.WithContext(ctx).Model(&models.ClientKey{}).
+		Where("id = ? AND usage_day = ? AND daily_used > 0", id, now.Format("2006-01-02")).
+		Updates(map[string]any{
+			"daily_used":   gorm.Expr("daily_used - 1"),
+			"monthly_used": gorm.Expr("CASE WHEN usage_month = ? AND monthly_used > 0 THEN monthly_used - 1 ELSE monthly_used END", now.Format("2006-01")),
+		}).Error
+}
+
+// rollClientKeyUsage zeroes the counters whose period has ended so callers see
+// the usage for the current day and month.
+func rollClientKeyUsage(item *models.ClientKey, now time.Time) {
+	day := now.Format("2006-01-02")
+	month := now.Format("2006-01")
+	if item.UsageDay != day {
+		item.UsageDay = day
+		item.DailyUsed = 0
+	}
+	if item.UsageMonth != month {
+		item.UsageMonth = month
+		item.MonthlyUsed = 0
+	}
+}
+
+func clientKeyAllowsEndpoint(allowed, endpoint string) bool {
+	return strings.TrimSpace(allowed) == "" || endpointListContains(allowed, endpoint)
+}
+
+func clampNonNegative(v int) int {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+func generateClientKeySecret() (string, error) {
+	secret, err := generateSecret(24)
+	if err != nil {
+		return "", err
+	}
+	return clientKeyPrefix + secret, nil
+}
+
+func hashClientKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
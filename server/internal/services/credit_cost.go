@@ -0,0 +1,166 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Tavily bills per endpoint and per request parameters rather than per call.
+// EstimateCredits mirrors the published pricing so UsedQuota tracks the real
+// balance between syncs:
+//
+//	/search   basic, fast, ultra-fast: 1; advanced: 2
+//	/extract  per 5 URLs: basic 1, advanced 2
+//	/map      per 10 pages: 1, or 2 with instructions
+//	/crawl    map cost plus extract cost for the pages crawled
+//
+// When the response carries a usage block (include_usage=true) its value wins.
+// /map and /crawl are otherwise billed by the pages in the response, since the
+// request limit is only an upper bound.
+
+const (
+	defaultMapCrawlLimit = 50
+	defaultCreditCost    = 1
+)
+
+// EstimateCredits predicts the credit cost of a request from its endpoint and
+// JSON body. GET requests (such as /usage) are free.
+func EstimateCredits(method, path string, body []byte) int {
+	if strings.EqualFold(method, http.MethodGet) {
+		return 0
+	}
+
+	params := requestParams(body)
+	switch endpoint := normalizeEndpoint(path); endpoint {
+	case "/search":
+		if stringParam(params, "search_depth") == "advanced" {
+			return 2
+		}
+		return 1
+	case "/extract":
+		return extractCredits(countURLs(params["urls"]), stringParam(params, "extract_depth"))
+	case "/map", "/crawl":
+		return pagedCredits(endpoint, params, intParam(params, "limit", defaultMapCrawlLimit))
+	default:
+		return defaultCreditCost
+	}
+}
+
+// CreditsFromResponse reads usage.credits from a Tavily response body.
+func CreditsFromResponse(body []byte) (int, bool) {
+	var out struct {
+		Usage *struct {
+			Credits *float64 `json:"credits"`
+		} `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return 0, false
+	}
+	if out.Usage == nil || out.Usage.Credits == nil || *out.Usage.Credits < 0 {
+		return 0, false
+	}
+	return int(math.Ceil(*out.Usage.Credits)), true
+}
+
+// creditCost returns the credits charged for a successful request, preferring
+// the upstream-reported usage over the estimate. estimated is set when a /map
+// or /crawl charge had to fall back to the request limit because the response
+// was not captured, as with uncaptured streams, or was cut short; the quota
+// sync corrects the key's balance later.
+func creditCost(req ProxyRequest, responseBody []byte) (credits int, estimated bool) {
+	if strings.EqualFold(req.Method, http.MethodGet) {
+		return 0, false
+	}
+	if credits, ok := CreditsFromResponse(responseBody); ok {
+		return credits, false
+	}
+	switch endpoint := normalizeEndpoint(req.Path); endpoint {
+	case "/map", "/crawl":
+		if pages, ok := resultCount(responseBody); ok {
+			return pagedCredits(endpoint, requestParams(req.Body), pages), false
+		}
+		return EstimateCredits(req.Method, req.Path, req.Body), true
+	}
+	return EstimateCredits(req.Method, req.Path, req.Body), false
+}
+
+// resultCount reads the length of the results array of a Tavily response body.
+func resultCount(body []byte) (int, bool) {
+	var out struct {
+		Results *[]json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil || out.Results == nil {
+		return 0, false
+	}
+	return len(*out.Results), true
+}
+
+// pagedCredits bills a /map or /crawl request that covered pages pages.
+func pagedCredits(endpoint string, params map[string]any, pages int) int {
+	credits := mapCredits(pages, stringParam(params, "instructions") != "")
+	if endpoint == "/crawl" {
+		credits += extractCredits(pages, stringParam(params, "extract_depth"))
+	}
+	return credits
+}
+
+func requestParams(body []byte) map[string]any {
+	var params map[string]any
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 {
+		_ = json.Unmarshal(trimmed, &params)
+	}
+	return params
+}
+
+func extractCredits(urls int, depth string) int {
+	if urls <= 0 {
+		urls = 1
+	}
+	perBatch := 1
+	if depth == "advanced" {
+		perBatch = 2
+	}
+	return ceilDiv(urls, 5) * perBatch
+}
+
+func mapCredits(pages int, withInstructions bool) int {
+	if pages <= 0 {
+		pages = 1
+	}
+	perBatch := 1
+	if withInstructions {
+		perBatch = 2
+	}
+	return ceilDiv(pages, 10) * perBatch
+}
+
+func countURLs(v any) int {
+	switch urls := v.(type) {
+	case []any:
+		return len(urls)
+	case string:
+		if strings.TrimSpace(urls) != "" {
+			return 1
+		}
+	}
+	return 0
+}
+
+func stringParam(params map[string]any, name string) string {
+	v, _ := params[name].(string)
+	return strings.ToLower(strings.TrimSpace(v))
+}
+
+func intParam(params map[string]any, name string, def int) int {
+	if v, ok := params[name].(float64); ok && v > 0 {
+		return int(v)
+	}
+	return def
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestEstimateCredits(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "usage is free", method: http.MethodGet, path: "/usage", want: 0},
+		{name: "basic search", method: http.MethodPost, path: "/search", body: `{"query":"q"}`, want: 1},
+		{name: "advanced search", method: http.MethodPost, path: "/search", body: `{"query":"q","search_depth":"advanced"}`, want: 2},
+		{name: "extract batch", method: http.MethodPost, path: "/extract", body: `{"urls":["a","b","c","d","e","f"]}`, want: 2},
+		{name: "advanced extract", method: http.MethodPost, path: "/extract", body: `{"urls":["a"],"extract_depth":"advanced"}`, want: 2},
+		{name: "map default limit", method: http.MethodPost, path: "/map", body: `{"url":"https://example.com"}`, want: 5},
+		{name: "map with instructions", method: http.MethodPost, path: "/map", body: `{"url":"u","limit":20,"instructions":"docs"}`, want: 4},
+		{name: "crawl", method: http.MethodPost, path: "/crawl", body: `{"url":"u","limit":10}`, want: 3},
+		{name: "unknown endpoint", method: http.MethodPost, path: "/research", body: `{}`, want: 1},
+	}
+	for _, tc := range cases {
+		if got := EstimateCredits(tc.method, tc.path, []byte(tc.body)); got != tc.want {
+			t.Errorf("%s: got %d want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCreditCost_PagedEndpoints(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name          string
+		path          string
+		body          string
+		response      string
+		want          int
+		wantEstimated bool
+	}{
+		{name: "crawl billed by results", path: "/crawl", body: `{"url":"u"}`, response: `{"results":[{},{},{}]}`, want: 2},
+		{name: "crawl without results", path: "/crawl", body: `{"url":"u"}`, response: `{"results":[]}`, want: 2},
+		{name: "map billed by results", path: "/map", body: `{"url":"u","limit":100}`, response: `{"results":["a","b"]}`, want: 1},
+		{name: "usage block wins", path: "/crawl", body: `{"url":"u"}`, response: `{"results":[{}],"usage":{"credits":7}}`, want: 7},
+		{name: "uncaptured crawl falls back to limit", path: "/crawl", body: `{"url":"u","limit":20}`, want: 6, wantEstimated: true},
+		{name: "truncated crawl falls back to limit", path: "/crawl", body: `{"url":"u","limit":20}`, response: `{"results":[{},`, want: 6, wantEstimated: true},
+		{name: "uncaptured search is exact", path: "/search", body: `{"query":"q"}`, want: 1},
+	}
+	for _, tc := range cases {
+		req := ProxyRequest{Method: http.MethodPost, Path: tc.path, Body: []byte(tc.body)}
+		got, estimated := creditCost(req, []byte(tc.response))
+		if got != tc.want || estimated != tc.wantEstimated {
+			t.Errorf("%s: got %d estimated=%v want %d estimated=%v", tc.name, got, estimated, tc.want, tc.wantEstimated)
+		}
+	}
+}
+
+func TestTavilyProxy_ChargesCreditsFromUsageBlock(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[],"usage":{"credits":4}}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	key, err := keys.Create(ctx, "tvly-test", "test", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger)
+	if _, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/extract", Body: []byte(`{"urls":["a"],"include_usage":true}`)}); err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+
+	got, err := keys.Get(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if got.UsedQuota != 4 {
+		t.Fatalf("unexpected used_quota: got %d want %d", got.UsedQuota, 4)
+	}
+
+	var entry models.RequestLog
+	if err := database.WithContext(ctx).First(&entry).Error; err != nil {
+		t.Fatalf("load log: %v", err)
+	}
+	if entry.Credits != 4 {
+		t.Fatalf("unexpected logged credits: got %d want %d", entry.Credits, 4)
+	}
+}
@@ -0,0 +1,59 @@
+package services
+
+import "strings"
+
+// Endpoint lists (client key allow-lists, streaming endpoints, ...) are stored
+// as comma-separated, normalized paths such as "/search,/extract".
+
+// SplitEndpoints turns a stored endpoint list into a slice.
+func SplitEndpoints(list string) []string {
+	out := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if v := normalizeEndpoint(item); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// JoinEndpoints normalizes and de-duplicates endpoints into the stored form.
+func JoinEndpoints(endpoints []string) string {
+	seen := make(map[string]struct{}, len(endpoints))
+	out := make([]string, 0, len(endpoints))
+	for _, item := range endpoints {
+		v := normalizeEndpoint(item)
+		if v == "" {
+			continue
+		}
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		out = append(out, v)
+	}
+	return strings.Join(out, ",")
+}
+
+func endpointListContains(list, endpoint string) bool {
+	endpoint = normalizeEndpoint(endpoint)
+	if endpoint == "" {
+		return false
+	}
+	for _, item := range strings.Split(list, ",") {
+		if normalizeEndpoint(item) == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeEndpoint(v string) string {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return ""
+	}
+	if !strings.HasPrefix(v, "/") {
+		v = "/" + v
+	}
+	return strings.TrimRight(v, "/")
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	EventKeyInvalid       = "key.invalid"
+	EventKeyExhausted     = "key.exhausted"
+	EventPoolQuotaLow     = "pool.quota_low"
+	EventPoolKeysLow      = "pool.active_keys_low"
+	EventAutoSyncFailed   = "auto_sync.failed"
+	EventMasterKeyReset   = "master_key.reset"
+	EventNotificationTest = "notification.test"
+)
+
+// EventTypes lists the events targets can subscribe to.
+var EventTypes = []string{
+	EventKeyInvalid,
+	EventKeyExhausted,
+	EventPoolQuotaLow,
+	EventPoolKeysLow,
+	EventAutoSyncFailed,
+	EventMasterKeyReset,
+}
+
+func IsEventType(name string) bool {
+	for _, t := range EventTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+type Event struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	OccurredAt time.Time      `json:"occurred_at"`
+	Message    string         `json:"message"`
+	Data       map[string]any `json:"data,omitempty"`
+}
+
+// EventBus fans events out to subscribers. Publish calls every subscriber
+// synchronously, so subscribers must hand work off rather than block. Publish
+// is safe to call on a nil *EventBus.
+type EventBus struct {
+	mu   sync.RWMutex
+	subs []func(Event)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+func (b *EventBus) Subscribe(fn func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, fn)
+}
+
+func (b *EventBus) Publish(eventType, message string, data map[string]any) {
+	if b == nil {
+		return
+	}
+	ev := Event{
+		ID:         uuid.NewString(),
+		Type:       eventType,
+		OccurredAt: time.Now().UTC(),
+		Message:    message,
+		Data:       data,
+	}
+	b.mu.RLock()
+	subs := b.subs
+	b.mu.RUnlock()
+	for _, fn := range subs {
+		fn(ev)
+	}
+}
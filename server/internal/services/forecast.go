@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+)
+
+const (
+	defaultForecastWindowDays = 7
+	maxForecastWindowDays     = 90
+	// defaultKeyQuota sizes extra keys when no key has a quota to go by; it is
+	// Tavily's free monthly allowance.
+	defaultKeyQuota = 1000
+)
+
+// ForecastOptions tunes Forecast. Zero values use the defaults.
+type ForecastOptions struct {
+	// WindowDays is how many recent days of demand the projection averages.
+	WindowDays int
+	// KeyQuota is the monthly quota assumed for each extra key. It defaults to
+	// the average quota of the configured keys.
+	KeyQuota int
+	Now      time.Time
+}
+
+// Forecast is a quota projection made by StatsService.Forecast.
+type Forecast struct {
+	GeneratedAt       time.Time `json:"generated_at"`
+	WindowDays        int       `json:"window_days"`
+	ObservedDays      float64   `json:"observed_days"`
+	MonthEnd          time.Time `json:"month_end"`
+	DaysToMonthEnd    float64   `json:"days_to_month_end"`
+	DailyRequests     float64   `json:"daily_requests"`
+	CreditsPerRequest float64   `json:"credits_per_request"`
+	DailyCredits      float64   `json:"daily_credits"`
+	// Basis is "logs" when the credit ratio and key shares come from the
+	// request log, or "requests" when one credit per request is assumed.
+	Basis string `json:"basis"`
+
+	Pool PoolForecast  `json:"pool"`
+	Keys []KeyForecast `json:"keys"`
+}
+
+type PoolForecast struct {
+	RemainingQuota int64      `json:"remaining_quota"`
+	DepletionAt    *time.Time `json:"depletion_at"`
+	DaysLeft       *float64   `json:"days_left"`
+	// ProjectedDemand is the credits needed until MonthEnd at the current rate.
+	ProjectedDemand     int64 `json:"projected_demand"`
+	Shortfall           int64 `json:"shortfall"`
+	KeyQuota            int   `json:"key_quota"`
+	ExtraKeysNeeded     int   `json:"extra_keys_needed"`
+	DemandExceedsSupply bool  `json:"demand_exceeds_supply"`
+}
+
+type KeyForecast struct {
+	ID             uint       `json:"id"`
+	Alias          string     `json:"alias"`
+	Usable         bool       `json:"usable"`
+	RemainingQuota int64      `json:"remaining_quota"`
+	DailyCredits   float64    `json:"daily_credits"`
+	DepletionAt    *time.Time `json:"depletion_at"`
+	DaysLeft       *float64   `json:"days_left"`
+	// DepletesBeforeMonthEnd is true when the key runs dry before the reset.
+	DepletesBeforeMonthEnd bool `json:"depletes_before_month_end"`
+}
+
+// Forecast projects when the pool and each key will run out of quota at the
+// recent rate, and whether the pool lasts until the monthly reset.
+//
+// Demand comes from the day buckets of RequestStat (cache hits excluded). The
+// buckets count requests, so they are converted to credits with the average
+// credits per request seen in the request log over the same window. Per-key
+// burn rates split the pool rate by each key's share of logged credits, or
+// evenly across usable keys when the log has none.
+func (s *StatsService) Forecast(ctx context.Context, opts ForecastOptions) (Forecast, error) {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	window := opts.WindowDays
+	if window <= 0 {
+		window = defaultForecastWindowDays
+	}
+	if window > maxForecastWindowDays {
+		window = maxForecastWindowDays
+	}
+
+	// Day buckets follow the reporting timezone, the month the reset timezone.
+	now = now.In(s.loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.loc)
+	windowStart := today.AddDate(0, 0, -(window - 1))
+	resetNow := now.In(s.resetLoc)
+	monthEnd := time.Date(resetNow.Year(), resetNow.Month()+1, 1, 0, 0, 0, 0, s.resetLoc)
+
+	var buckets []models.RequestStat
+	if err := totalsOnly(s.db.WithContext(ctx)).
+		Where("granularity = ? AND endpoint = ? AND bucket >= ?", "day", "", windowStart.Format("2006-01-02")).
+		Order("bucket asc").
+		Find(&buckets).Error; err != nil {
+		return Forecast{}, err
+	}
+	var requests int64
+	observedStart := now
+	for _, b := range buckets {
+		requests += b.Count - b.CacheHits
+		if day, err := time.ParseInLocation("2006-01-02", b.Bucket, s.loc); err == nil && day.Before(observedStart) {
+			observedStart = day
+		}
+	}
+	// A young instance has fewer days of history than the window; average
+	// over the days actually observed, but at least one.
+	observedDays := math.Max(now.Sub(observedStart).Hours()/24, 1)
+
+	type keyCredits struct {
+		KeyUsed uint
+		Credits int64
+		Count   int64
+	}
+	var perKey []keyCredits
+	if err := s.db.WithContext(ctx).
+		Model(&models.RequestLog{}).
+		Select("key_used, COALESCE(SUM(credits),0) AS credits, COUNT(*) AS count").
+		Where("created_at >= ? AND cache_hit = ?", storageTime(windowStart), false).
+		Group("key_used").
+		Scan(&perKey).Error; err != nil {
+		return Forecast{}, err
+	}
+	var loggedCredits, loggedRequests int64
+	keyShare := make(map[uint]int64, len(perKey))
+	for _, k := range perKey {
+		loggedCredits += k.Credits
+		loggedRequests += k.Count
+		if k.KeyUsed != 0 {
+			keyShare[k.KeyUsed] += k.Credits
+		}
+	}
+
+	out := Forecast{
+		GeneratedAt:       now,
+		WindowDays:        window,
+		ObservedDays:      roundTo(observedDays, 2),
+		MonthEnd:          monthEnd,
+		DaysToMonthEnd:    roundTo(monthEnd.Sub(now).Hours()/24, 2),
+		DailyRequests:     float64(requests) / observedDays,
+		CreditsPerRequest: 1,
+		Basis:             "requests",
+		Keys:              []KeyForecast{},
+	}
+	if loggedRequests > 0 && loggedCredits > 0 {
+		out.CreditsPerRequest = float64(loggedCredits) / float64(loggedRequests)
+		out.Basis = "logs"
+	}
+	out.DailyCredits = out.DailyRequests * out.CreditsPerRequest
+
+	var keys []models.APIKey
+	if err := s.db.WithContext(ctx).Order("id asc").Find(&keys).Error; err != nil {
+		return Forecast{}, err
+	}
+	var usable []models.APIKey
+	var quotaSum int64
+	for _, k := range keys {
+		quotaSum += int64(k.TotalQuota)
+		if k.IsActive && !k.IsInvalid && k.UsedQuota < k.TotalQuota {
+			usable = append(usable, k)
+			out.Pool.RemainingQuota += int64(k.TotalQuota - k.UsedQuota)
+		}
+	}
+
+	var shareTotal int64
+	for _, k := range usable {
+		shareTotal += keyShare[k.ID]
+	}
+	for _, k := range keys {
+		kf := KeyForecast{ID: k.ID, Alias: k.Alias}
+		kf.Usable = k.IsActive && !k.IsInvalid && k.UsedQuota < k.TotalQuota
+		if kf.Usable {
+			kf.RemainingQuota = int64(k.TotalQuota - k.UsedQuota)
+			switch {
+			case shareTotal > 0:
+				kf.DailyCredits = out.DailyCredits * float64(keyShare[k.ID]) / float64(shareTotal)
+			case len(usable) > 0:
+				kf.DailyCredits = out.DailyCredits / float64(len(usable))
+			}
+			kf.DepletionAt, kf.DaysLeft = projectDepletion(now, kf.RemainingQuota, kf.DailyCredits)
+			kf.DepletesBeforeMonthEnd = kf.DepletionAt != nil && kf.DepletionAt.Before(monthEnd)
+			kf.DailyCredits = roundTo(kf.DailyCredits, 2)
+		}
+		out.Keys = append(out.Keys, kf)
+	}
+
+	pool := &out.Pool
+	pool.DepletionAt, pool.DaysLeft = projectDepletion(now, pool.RemainingQuota, out.DailyCredits)
+	pool.ProjectedDemand = int64(math.Ceil(out.DailyCredits * monthEnd.Sub(now).Hours() / 24))
+	pool.KeyQuota = opts.KeyQuota
+	if pool.KeyQuota <= 0 {
+		pool.KeyQuota = defaultKeyQuota
+		if len(keys) > 0 && quotaSum >= int64(len(keys)) {
+			pool.KeyQuota = int(quotaSum / int64(len(keys)))
+		}
+	}
+	if pool.ProjectedDemand > pool.RemainingQuota {
+		pool.DemandExceedsSupply = true
+		pool.Shortfall = pool.ProjectedDemand - pool.RemainingQuota
+		pool.ExtraKeysNeeded = int((pool.Shortfall + int64(pool.KeyQuota) - 1) / int64(pool.KeyQuota))
+	}
+
+	out.DailyRequests = roundTo(out.DailyRequests, 2)
+	out.CreditsPerRequest = roundTo(out.CreditsPerRequest, 3)
+	out.DailyCredits = roundTo(out.DailyCredits, 2)
+	return out, nil
+}
+
+// projectDepletion returns when remaining credits run out at dailyRate, or
+// nils when nothing is being spent. Dates beyond ten years are left out.
+func projectDepletion(now time.Time, remaining int64, dailyRate float64) (*time.Time, *float64) {
+	if dailyRate <= 0 {
+		return nil, nil
+	}
+	days := float64(remaining) / dailyRate
+	rounded := roundTo(days, 2)
+	if days > 3650 {
+		return nil, &rounded
+	}
+	at := now.Add(time.Duration(days * float64(24*time.Hour)))
+	return &at, &rounded
+}
+
+func roundTo(v float64, places int) float64 {
+	p := math.Pow(10, float64(places))
+	return math.Round(v*p) / p
+}
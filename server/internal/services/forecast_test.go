@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestStatsService_ForecastProjectsDepletion(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+	stats := NewStatsService(database)
+
+	busy, err := keys.Create(ctx, "tvly-busy", "busy", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	idle, err := keys.Create(ctx, "tvly-idle", "idle", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	revoked, err := keys.Create(ctx, "tvly-revoked", "revoked", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if err := keys.SetUsage(ctx, busy.ID, 900, nil); err != nil {
+		t.Fatalf("set usage: %v", err)
+	}
+	if err := keys.MarkInvalid(ctx, revoked.ID); err != nil {
+		t.Fatalf("mark invalid: %v", err)
+	}
+
+	// 50 requests a day for the last four days; the instance is 3.5 days old.
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.Local)
+	var events []StatEvent
+	for day := 0; day < 4; day++ {
+		for i := 0; i < 50; i++ {
+			events = append(events, StatEvent{Endpoint: "/search", OccurredAt: now.AddDate(0, 0, -day).Add(-time.Hour)})
+		}
+	}
+	if err := stats.RecordBatch(ctx, events); err != nil {
+		t.Fatalf("record stats: %v", err)
+	}
+	// Logged requests cost two credits each, three quarters on the busy key.
+	for i := 0; i < 40; i++ {
+		keyID := busy.ID
+		if i%4 == 0 {
+			keyID = idle.ID
+		}
+		if err := logs.Create(ctx, &models.RequestLog{RequestID: "r" + strconv.Itoa(i), KeyUsed: keyID, Endpoint: "/search", StatusCode: 200, Credits: 2, CreatedAt: now.Add(-time.Duration(i) * time.Hour)}); err != nil {
+			t.Fatalf("create log: %v", err)
+		}
+	}
+
+	out, err := stats.Forecast(ctx, ForecastOptions{Now: now})
+	if err != nil {
+		t.Fatalf("forecast: %v", err)
+	}
+
+	if out.Basis != "logs" || out.CreditsPerRequest != 2 || out.ObservedDays != 3.5 {
+		t.Fatalf("unexpected rate inputs: basis %q, credits/request %v, observed days %v", out.Basis, out.CreditsPerRequest, out.ObservedDays)
+	}
+	if math.Abs(out.DailyCredits-114.29) > 0.01 {
+		t.Fatalf("unexpected daily credits: got %v want %v", out.DailyCredits, 114.29)
+	}
+	pool := out.Pool
+	if pool.RemainingQuota != 1100 || pool.DaysLeft == nil || *pool.DaysLeft != 9.63 {
+		t.Fatalf("unexpected pool projection: %+v", pool)
+	}
+	// 21.5 days to the reset at ~114.3 credits a day.
+	if pool.ProjectedDemand != 2458 || pool.Shortfall != 1358 || pool.ExtraKeysNeeded != 2 || !pool.DemandExceedsSupply {
+		t.Fatalf("unexpected pool shortfall: %+v", pool)
+	}
+
+	byAlias := make(map[string]KeyForecast)
+	for _, k := range out.Keys {
+		byAlias[k.Alias] = k
+	}
+	if k := byAlias["busy"]; !k.DepletesBeforeMonthEnd || k.DaysLeft == nil || *k.DaysLeft != 1.17 {
+		t.Fatalf("unexpected busy key projection: %+v", k)
+	}
+	if k := byAlias["idle"]; k.DepletesBeforeMonthEnd || k.DaysLeft == nil || *k.DaysLeft != 35 {
+		t.Fatalf("unexpected idle key projection: %+v", k)
+	}
+	if k := byAlias["revoked"]; k.Usable || k.DepletionAt != nil {
+		t.Fatalf("unexpected revoked key projection: %+v", k)
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+
+	defaultBreakerThreshold = 5
+	defaultBreakerOpenFor   = 30 * time.Second
+)
+
+// keyBreakers is a per-key circuit breaker. A key trips open after threshold
+// consecutive failures and is skipped until openFor has passed; then a single
+// request is let through as a probe, which closes the breaker on success and
+// re-opens it on failure.
+type keyBreakers struct {
+	threshold int
+	openFor   time.Duration
+
+	mu    sync.Mutex
+	state map[uint]*breakerState
+}
+
+type breakerState struct {
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+func newKeyBreakers(threshold int, openFor time.Duration) *keyBreakers {
+	return &keyBreakers{
+		threshold: threshold,
+		openFor:   openFor,
+		state:     make(map[uint]*breakerState),
+	}
+}
+
+// State reports the breaker state of a key without changing it.
+func (b *keyBreakers) State(keyID uint, now time.Time) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stateLocked(b.state[keyID], now)
+}
+
+func (b *keyBreakers) stateLocked(st *breakerState, now time.Time) string {
+	switch {
+	case st == nil || !st.open:
+		return BreakerClosed
+	case st.probing || now.Sub(st.openedAt) >= b.openFor:
+		return BreakerHalfOpen
+	default:
+		return BreakerOpen
+	}
+}
+
+// Available reports whether a key may be offered as a candidate: its breaker
+// is closed, or half-open with no probe in flight.
+func (b *keyBreakers) Available(keyID uint, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.state[keyID]
+	switch b.stateLocked(st, now) {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		return !st.probing
+	default:
+		return false
+	}
+}
+
+// Acquire admits a request on the key. For a half-open breaker only the first
+// caller is admitted, as the probe.
+func (b *keyBreakers) Acquire(keyID uint, now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.state[keyID]
+	switch b.stateLocked(st, now) {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if st.probing {
+			return false
+		}
+		st.probing = true
+		return true
+	default:
+		return false
+	}
+}
+
+// Release gives back a probe that ended without telling anything about the
+// key, such as a cancelled request, so the next caller can probe instead.
+func (b *keyBreakers) Release(keyID uint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if st := b.state[keyID]; st != nil {
+		st.probing = false
+	}
+}
+
+func (b *keyBreakers) Success(keyID uint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.state, keyID)
+}
+
+func (b *keyBreakers) Failure(keyID uint, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := b.state[keyID]
+	if st == nil {
+		st = &breakerState{}
+		b.state[keyID] = st
+	}
+	st.failures++
+	if st.open || st.failures >= b.threshold {
+		st.open = true
+		st.openedAt = now
+		st.probing = false
+	}
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestKeyBreakers_OpenHalfOpenClose(t *testing.T) {
+	t.Parallel()
+
+	b := newKeyBreakers(2, time.Minute)
+	now := time.Now()
+
+	b.Failure(1, now)
+	if got := b.State(1, now); got != BreakerClosed {
+		t.Fatalf("one failure: got %q want %q", got, BreakerClosed)
+	}
+	b.Failure(1, now)
+	if got := b.State(1, now); got != BreakerOpen {
+		t.Fatalf("threshold reached: got %q want %q", got, BreakerOpen)
+	}
+	if b.Available(1, now) || b.Acquire(1, now) {
+		t.Fatalf("open breaker should reject requests")
+	}
+
+	later := now.Add(time.Minute)
+	if !b.Available(1, later) || !b.Acquire(1, later) {
+		t.Fatalf("expected a half-open probe to be admitted")
+	}
+	if b.Available(1, later) || b.Acquire(1, later) {
+		t.Fatalf("only one probe should be admitted while half-open")
+	}
+
+	b.Failure(1, later)
+	if got := b.State(1, later); got != BreakerOpen {
+		t.Fatalf("failed probe: got %q want %q", got, BreakerOpen)
+	}
+
+	muchLater := later.Add(time.Minute)
+	if !b.Acquire(1, muchLater) {
+		t.Fatalf("expected a second probe to be admitted")
+	}
+	b.Success(1)
+	if got := b.State(1, muchLater); got != BreakerClosed {
+		t.Fatalf("successful probe: got %q want %q", got, BreakerClosed)
+	}
+}
+
+func TestTavilyProxy_BreakerSkipsFailingKey(t *testing.T) {
+	t.Parallel()
+
+	var brokenCalls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") == "Bearer tvly-broken" {
+			atomic.AddInt32(&brokenCalls, 1)
+			w.WriteHeader(http.StatusBadGateway)
+			_, _ = w.Write([]byte(`{"detail":"bad gateway"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	// More remaining quota, so the default strategy tries it first.
+	broken, err := keys.Create(ctx, "tvly-broken", "broken", 2000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if _, err := keys.Create(ctx, "tvly-ok", "ok", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger)
+	for i := 0; i < defaultBreakerThreshold; i++ {
+		_, _ = proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)})
+	}
+	if got := keys.BreakerState(broken.ID); got != BreakerOpen {
+		t.Fatalf("unexpected breaker state: got %q want %q", got, BreakerOpen)
+	}
+
+	resp, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d want %d", resp.StatusCode, http.StatusOK)
+	}
+	if got := atomic.LoadInt32(&brokenCalls); got != defaultBreakerThreshold {
+		t.Fatalf("open breaker should skip the key: upstream calls=%d", got)
+	}
+}
+
+func TestTavilyProxy_CancelledProbeReleasesKey(t *testing.T) {
+	t.Parallel()
+
+	unblock := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-unblock:
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	t.Cleanup(func() { close(unblock) })
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	keys.breakers = newKeyBreakers(1, time.Millisecond)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	key, err := keys.Create(ctx, "tvly-probe", "probe", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	keys.ReportFailure(key.ID)
+	time.Sleep(5 * time.Millisecond)
+	if got := keys.BreakerState(key.ID); got != BreakerHalfOpen {
+		t.Fatalf("unexpected breaker state: got %q want %q", got, BreakerHalfOpen)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger)
+	reqCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := proxy.Do(reqCtx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)}); err == nil {
+		t.Fatalf("expected the cancelled probe to fail")
+	}
+
+	if !keys.breakers.Available(key.ID, time.Now()) {
+		t.Fatalf("a cancelled probe should leave the key selectable")
+	}
+	if got := keys.BreakerState(key.ID); got != BreakerHalfOpen {
+		t.Fatalf("cancelled probe changed breaker state: got %q want %q", got, BreakerHalfOpen)
+	}
+}
+
+func TestTavilyProxy_RefusedKeysKeepRateSlotsAndLog(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	key, err := keys.Create(ctx, "tvly-limited", "limited", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if err := database.Model(&models.APIKey{}).Where("id = ?", key.ID).Update("rate_limit_per_minute", 1).Error; err != nil {
+		t.Fatalf("set rate limit: %v", err)
+	}
+	key.RateLimitPerMinute = 1
+
+	for i := 0; i < defaultBreakerThreshold; i++ {
+		keys.ReportFailure(key.ID)
+	}
+	if keys.AllowRequest(*key) {
+		t.Fatalf("open breaker should refuse the key")
+	}
+	keys.ReportSuccess(key.ID)
+	if !keys.AllowRequest(*key) {
+		t.Fatalf("a refused key should not have used its rate limit slot")
+	}
+
+	// The only slot is now taken, so the proxy cannot launch any attempt.
+	proxy := NewTavilyProxy("http://127.0.0.1:0", 5*time.Second, keys, logs, nil, logger)
+	if _, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)}); err != ErrNoAvailableKeys {
+		t.Fatalf("unexpected error: got %v want %v", err, ErrNoAvailableKeys)
+	}
+	var entry models.RequestLog
+	if err := database.First(&entry).Error; err != nil {
+		t.Fatalf("load log: %v", err)
+	}
+	if entry.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("logged status: got %d want %d", entry.StatusCode, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+)
+
+func TestTavilyProxy_TooManyRequestsCoolsKeyDown(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") == "Bearer tvly-limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"detail":"rate limited"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	// More remaining quota, so the default strategy tries it first.
+	limited, err := keys.Create(ctx, "tvly-limited", "limited", 2000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if _, err := keys.Create(ctx, "tvly-ok", "ok", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger)
+	resp, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	got, err := keys.Get(ctx, limited.ID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if got.UsedQuota != 0 {
+		t.Fatalf("429 should not exhaust the key: used_quota=%d", got.UsedQuota)
+	}
+	if got.CooldownUntil == nil || !got.CooldownUntil.After(time.Now()) || got.CooldownStrikes != 1 {
+		t.Fatalf("expected key to cool down: until=%v strikes=%d", got.CooldownUntil, got.CooldownStrikes)
+	}
+
+	candidates, err := keys.Candidates(ctx)
+	if err != nil {
+		t.Fatalf("candidates: %v", err)
+	}
+	if len(candidates) != 1 || candidates[0].ID == limited.ID {
+		t.Fatalf("cooling key should not be a candidate: %+v", candidates)
+	}
+}
+
+func TestCooldownFor_BacksOffExponentially(t *testing.T) {
+	t.Parallel()
+
+	if got := cooldownFor(1); got != baseKeyCooldown {
+		t.Fatalf("first strike: got %s want %s", got, baseKeyCooldown)
+	}
+	if got := cooldownFor(3); got != 4*baseKeyCooldown {
+		t.Fatalf("third strike: got %s want %s", got, 4*baseKeyCooldown)
+	}
+	if got := cooldownFor(50); got != maxKeyCooldown {
+		t.Fatalf("cap: got %s want %s", got, maxKeyCooldown)
+	}
+}
+
+func TestKeyRateLimiter_SlidingWindow(t *testing.T) {
+	t.Parallel()
+
+	limiter := newKeyRateLimiter()
+	now := time.Now()
+	if !limiter.Allow(1, 2, now) || !limiter.Allow(1, 2, now.Add(time.Second)) {
+		t.Fatalf("expected first two requests to be allowed")
+	}
+	if limiter.Allow(1, 2, now.Add(2*time.Second)) {
+		t.Fatalf("expected third request within a minute to be rejected")
+	}
+	if !limiter.Allow(2, 2, now.Add(2*time.Second)) {
+		t.Fatalf("limits should be tracked per key")
+	}
+	if !limiter.Allow(1, 2, now.Add(61*time.Second)) {
+		t.Fatalf("expected request to be allowed once the window slides")
+	}
+
+	// Key 2 has been idle for over a minute, so its window is swept.
+	limiter.Allow(1, 2, now.Add(3*time.Minute))
+	if _, ok := limiter.recent[2]; ok || len(limiter.recent) != 1 {
+		t.Fatalf("idle keys should be swept: got %d entries want 1", len(limiter.recent))
+	}
+}
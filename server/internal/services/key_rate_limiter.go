@@ -0,0 +1,53 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// keyRateLimiter enforces per-key requests-per-minute limits locally with a
+// sliding one-minute window, so keys never reach Tavily's own rate limit.
+type keyRateLimiter struct {
+	mu        sync.Mutex
+	recent    map[uint][]time.Time
+	lastSweep time.Time
+}
+
+func newKeyRateLimiter() *keyRateLimiter {
+	return &keyRateLimiter{recent: make(map[uint][]time.Time)}
+}
+
+func (l *keyRateLimiter) Allow(keyID uint, perMinute int, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-time.Minute)
+	l.sweep(cutoff)
+	window := l.recent[keyID]
+	i := 0
+	for i < len(window) && !window[i].After(cutoff) {
+		i++
+	}
+	window = window[i:]
+
+	if len(window) >= perMinute {
+		l.recent[keyID] = window
+		return false
+	}
+	l.recent[keyID] = append(window, now)
+	return true
+}
+
+// sweep drops keys with no request inside the window, at most once a minute,
+// so deleted keys and keys whose limit was lifted don't stay in the map.
+func (l *keyRateLimiter) sweep(cutoff time.Time) {
+	if l.lastSweep.After(cutoff) {
+		return
+	}
+	l.lastSweep = cutoff.Add(time.Minute)
+	for id, window := range l.recent {
+		if len(window) == 0 || !window[len(window)-1].After(cutoff) {
+			delete(l.recent, id)
+		}
+	}
+}
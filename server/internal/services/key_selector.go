@@ -0,0 +1,204 @@
+package services
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+)
+
+const (
+	KeySelectionRemaining   = "remaining"
+	KeySelectionRoundRobin  = "round_robin"
+	KeySelectionLRU         = "least_recently_used"
+	KeySelectionWeighted    = "weighted"
+	KeySelectionDrain       = "drain"
+	KeySelectionLatency     = "latency"
+	defaultKeySelectionName = KeySelectionRemaining
+)
+
+// KeySelector decides the order in which usable keys are tried for a request.
+// Order receives only keys that are active, valid and have quota left, and
+// must return a permutation of them.
+type KeySelector interface {
+	Name() string
+	Order(keys []models.APIKey) []models.APIKey
+}
+
+// remainingSelector tries the key with the most remaining quota first and
+// shuffles ties for fairness.
+type remainingSelector struct{}
+
+func (remainingSelector) Name() string { return KeySelectionRemaining }
+
+func (remainingSelector) Order(keys []models.APIKey) []models.APIKey {
+	out := append([]models.APIKey(nil), keys...)
+	sort.SliceStable(out, func(i, j int) bool {
+		return remainingQuota(out[i]) > remainingQuota(out[j])
+	})
+	shuffleTies(out, func(a, b models.APIKey) bool { return remainingQuota(a) == remainingQuota(b) })
+	return out
+}
+
+// roundRobinSelector rotates the starting key on every call.
+type roundRobinSelector struct {
+	next atomic.Uint64
+}
+
+func (*roundRobinSelector) Name() string { return KeySelectionRoundRobin }
+
+func (s *roundRobinSelector) Order(keys []models.APIKey) []models.APIKey {
+	sorted := append([]models.APIKey(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	if len(sorted) == 0 {
+		return sorted
+	}
+	start := int(s.next.Add(1)-1) % len(sorted)
+	return append(sorted[start:], sorted[:start]...)
+}
+
+// lruSelector tries the key that has been idle the longest first. Keys that
+// were never used come before all others.
+type lruSelector struct{}
+
+func (lruSelector) Name() string { return KeySelectionLRU }
+
+func (lruSelector) Order(keys []models.APIKey) []models.APIKey {
+	out := append([]models.APIKey(nil), keys...)
+	sort.SliceStable(out, func(i, j int) bool {
+		a, b := out[i].LastUsedAt, out[j].LastUsedAt
+		switch {
+		case a == nil && b == nil:
+			return out[i].ID < out[j].ID
+		case a == nil:
+			return true
+		case b == nil:
+			return false
+		default:
+			return a.Before(*b)
+		}
+	})
+	return out
+}
+
+// weightedSelector draws keys at random, weighted by remaining quota, so load
+// spreads proportionally instead of always hitting the fullest key.
+type weightedSelector struct{}
+
+func (weightedSelector) Name() string { return KeySelectionWeighted }
+
+func (weightedSelector) Order(keys []models.APIKey) []models.APIKey {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	pool := append([]models.APIKey(nil), keys...)
+	out := make([]models.APIKey, 0, len(pool))
+	for len(pool) > 0 {
+		var total int
+		for _, k := range pool {
+			total += remainingQuota(k)
+		}
+		idx := 0
+		if total > 0 {
+			pick := rng.Intn(total)
+			for i, k := range pool {
+				pick -= remainingQuota(k)
+				if pick < 0 {
+					idx = i
+					break
+				}
+			}
+		}
+		out = append(out, pool[idx])
+		pool = append(pool[:idx], pool[idx+1:]...)
+	}
+	return out
+}
+
+// drainSelector uses the key with the least remaining quota first, so one key
+// is emptied at a time and fresh keys stay untouched.
+type drainSelector struct{}
+
+func (drainSelector) Name() string { return KeySelectionDrain }
+
+func (drainSelector) Order(keys []models.APIKey) []models.APIKey {
+	out := append([]models.APIKey(nil), keys...)
+	sort.SliceStable(out, func(i, j int) bool {
+		ri, rj := remainingQuota(out[i]), remainingQuota(out[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// latencySelector prefers keys with the lowest recently observed upstream
+// latency. Keys without samples go first so they get measured.
+type latencySelector struct {
+	tracker *latencyTracker
+}
+
+func (*latencySelector) Name() string { return KeySelectionLatency }
+
+func (s *latencySelector) Order(keys []models.APIKey) []models.APIKey {
+	out := append([]models.APIKey(nil), keys...)
+	sort.SliceStable(out, func(i, j int) bool {
+		li, _ := s.tracker.Get(out[i].ID)
+		lj, _ := s.tracker.Get(out[j].ID)
+		if li != lj {
+			return li < lj
+		}
+		return remainingQuota(out[i]) > remainingQuota(out[j])
+	})
+	return out
+}
+
+// latencyTracker keeps an exponentially weighted moving average of upstream
+// latency per key.
+type latencyTracker struct {
+	mu      sync.RWMutex
+	average map[uint]float64
+}
+
+const latencyEWMAAlpha = 0.3
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{average: make(map[uint]float64)}
+}
+
+func (t *latencyTracker) Observe(keyID uint, latencyMs int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	prev, ok := t.average[keyID]
+	if !ok {
+		t.average[keyID] = float64(latencyMs)
+		return
+	}
+	t.average[keyID] = latencyEWMAAlpha*float64(latencyMs) + (1-latencyEWMAAlpha)*prev
+}
+
+func (t *latencyTracker) Get(keyID uint) (float64, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	v, ok := t.average[keyID]
+	return v, ok
+}
+
+func remainingQuota(k models.APIKey) int {
+	return k.TotalQuota - k.UsedQuota
+}
+
+func shuffleTies(keys []models.APIKey, equal func(a, b models.APIKey) bool) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for i := 0; i < len(keys); {
+		j := i + 1
+		for j < len(keys) && equal(keys[j], keys[i]) {
+			j++
+		}
+		group := keys[i:j]
+		rng.Shuffle(len(group), func(a, b int) { group[a], group[b] = group[b], group[a] })
+		i = j
+	}
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestKeyService_Candidates_UsesConfiguredSelector(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	settings := NewSettingsService(database)
+	keys := NewKeyService(database, logger).WithSettings(settings)
+
+	ctx := context.Background()
+	fresh, err := keys.Create(ctx, "tvly-fresh", "fresh", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	half, err := keys.Create(ctx, "tvly-half", "half", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if err := keys.SetUsage(ctx, half.ID, 500, nil); err != nil {
+		t.Fatalf("set usage: %v", err)
+	}
+
+	got, err := keys.Candidates(ctx)
+	if err != nil {
+		t.Fatalf("candidates: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != fresh.ID {
+		t.Fatalf("default selector should prefer most remaining quota, got %+v", got)
+	}
+
+	if err := settings.Set(ctx, SettingKeySelectionStrategy, KeySelectionDrain); err != nil {
+		t.Fatalf("set strategy: %v", err)
+	}
+	got, err = keys.Candidates(ctx)
+	if err != nil {
+		t.Fatalf("candidates: %v", err)
+	}
+	if len(got) != 2 || got[0].ID != half.ID {
+		t.Fatalf("drain selector should prefer least remaining quota, got %+v", got)
+	}
+
+	if err := settings.Set(ctx, SettingKeySelectionStrategy, "no-such-strategy"); err != nil {
+		t.Fatalf("set strategy: %v", err)
+	}
+	if name := keys.ActiveSelector(ctx).Name(); name != KeySelectionRemaining {
+		t.Fatalf("unknown strategy should fall back to default, got %q", name)
+	}
+}
+
+func TestKeySelectors_Order(t *testing.T) {
+	t.Parallel()
+
+	older := time.Now().Add(-time.Hour)
+	newer := time.Now()
+	keys := []models.APIKey{
+		{ID: 1, TotalQuota: 1000, UsedQuota: 100, LastUsedAt: &newer},
+		{ID: 2, TotalQuota: 1000, UsedQuota: 900, LastUsedAt: &older},
+		{ID: 3, TotalQuota: 1000, UsedQuota: 500},
+	}
+
+	if got := (lruSelector{}).Order(keys); got[0].ID != 3 || got[1].ID != 2 || got[2].ID != 1 {
+		t.Fatalf("unexpected lru order: %v", keyIDs(got))
+	}
+
+	rr := &roundRobinSelector{}
+	first := rr.Order(keys)
+	second := rr.Order(keys)
+	if first[0].ID != 1 || second[0].ID != 2 {
+		t.Fatalf("unexpected round robin starts: %d then %d", first[0].ID, second[0].ID)
+	}
+
+	tracker := newLatencyTracker()
+	tracker.Observe(1, 800)
+	tracker.Observe(2, 100)
+	tracker.Observe(3, 400)
+	if got := (&latencySelector{tracker: tracker}).Order(keys); got[0].ID != 2 || got[2].ID != 1 {
+		t.Fatalf("unexpected latency order: %v", keyIDs(got))
+	}
+
+	if got := (weightedSelector{}).Order(keys); len(got) != len(keys) {
+		t.Fatalf("weighted selector dropped keys: %v", keyIDs(got))
+	}
+}
+
+func keyIDs(keys []models.APIKey) []uint {
+	out := make([]uint, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, k.ID)
+	}
+	return out
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	"errors"
 	"log/slog"
-	"math/rand"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"tavily-proxy/server/internal/models"
@@ -14,12 +16,120 @@ import (
 )
 
 type KeyService struct {
-	db     *gorm.DB
-	logger *slog.Logger
+	db       *gorm.DB
+	logger   *slog.Logger
+	settings *SettingsService
+	events   *EventBus
+
+	latency  *latencyTracker
+	rate     *keyRateLimiter
+	breakers *keyBreakers
+
+	selectorsMu sync.RWMutex
+	selectors   map[string]KeySelector
 }
 
 func NewKeyService(db *gorm.DB, logger *slog.Logger) *KeyService {
-	return &KeyService{db: db, logger: logger}
+	s := &KeyService{
+		db:        db,
+		logger:    logger,
+		latency:   newLatencyTracker(),
+		rate:      newKeyRateLimiter(),
+		breakers:  newKeyBreakers(defaultBreakerThreshold, defaultBreakerOpenFor),
+		selectors: make(map[string]KeySelector),
+	}
+	s.RegisterSelector(remainingSelector{})
+	s.RegisterSelector(&roundRobinSelector{})
+	s.RegisterSelector(lruSelector{})
+	s.RegisterSelector(weightedSelector{})
+	s.RegisterSelector(drainSelector{})
+	s.RegisterSelector(&latencySelector{tracker: s.latency})
+	return s
+}
+
+func (s *KeyService) WithSettings(settings *SettingsService) *KeyService {
+	s.settings = settings
+	return s
+}
+
+// WithEvents publishes key.invalid and key.exhausted when a key changes state.
+func (s *KeyService) WithEvents(events *EventBus) *KeyService {
+	s.events = events
+	return s
+}
+
+// RegisterSelector makes a key selection strategy available under its name,
+// replacing any strategy previously registered with the same name.
+func (s *KeyService) RegisterSelector(selector KeySelector) {
+	s.selectorsMu.Lock()
+	defer s.selectorsMu.Unlock()
+	s.selectors[selector.Name()] = selector
+}
+
+// SelectorNames lists the registered key selection strategies.
+func (s *KeyService) SelectorNames() []string {
+	s.selectorsMu.RLock()
+	defer s.selectorsMu.RUnlock()
+	out := make([]string, 0, len(s.selectors))
+	for name := range s.selectors {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (s *KeyService) HasSelector(name string) bool {
+	s.selectorsMu.RLock()
+	defer s.selectorsMu.RUnlock()
+	_, ok := s.selectors[name]
+	return ok
+}
+
+// ActiveSelector returns the strategy configured in settings, falling back to
+// the default when the setting is missing or names an unknown strategy.
+func (s *KeyService) ActiveSelector(ctx context.Context) KeySelector {
+	name := defaultKeySelectionName
+	if s.settings != nil {
+		if v, ok, err := s.settings.Get(ctx, SettingKeySelectionStrategy); err == nil && ok {
+			name = strings.TrimSpace(v)
+		}
+	}
+
+	s.selectorsMu.RLock()
+	defer s.selectorsMu.RUnlock()
+	if selector, ok := s.selectors[name]; ok {
+		return selector
+	}
+	return s.selectors[defaultKeySelectionName]
+}
+
+// ObserveLatency feeds an upstream latency sample into the latency-aware
+// selection strategy.
+func (s *KeyService) ObserveLatency(id uint, latencyMs int64) {
+	s.latency.Observe(id, latencyMs)
+}
+
+// ReportSuccess closes the key's circuit breaker.
+func (s *KeyService) ReportSuccess(id uint) {
+	s.breakers.Success(id)
+}
+
+// ReportFailure counts a transport error or upstream 5xx against the key's
+// circuit breaker.
+func (s *KeyService) ReportFailure(id uint) {
+	s.breakers.Failure(id, time.Now())
+}
+
+// ReleaseAttempt ends a request admitted by AllowRequest that produced no
+// answer to report, freeing the half-open probe it may hold.
+func (s *KeyService) ReleaseAttempt(id uint) {
+	s.breakers.Release(id)
+}
+
+// BreakerState reports the key's circuit breaker state: closed, open or
+// half_open.
+func (s *KeyService) BreakerState(id uint) string {
+	return s.breakers.State(id, time.Now())
 }
 
 func (s *KeyService) List(ctx context.Context) ([]models.APIKey, error) {
@@ -57,12 +167,14 @@ func (s *KeyService) Get(ctx context.Context, id uint) (*models.APIKey, error) {
 }
 
 type KeyUpdate struct {
-	Alias      *string `json:"alias"`
-	TotalQuota *int    `json:"total_quota"`
-	UsedQuota  *int    `json:"used_quota"`
-	IsActive   *bool   `json:"is_active"`
-	ResetQuota bool    `json:"reset_quota"`
-	SyncUsage  bool    `json:"sync_usage"`
+	Alias              *string `json:"alias"`
+	TotalQuota         *int    `json:"total_quota"`
+	UsedQuota          *int    `json:"used_quota"`
+	IsActive           *bool   `json:"is_active"`
+	RateLimitPerMinute *int    `json:"rate_limit_per_minute"`
+	ResetQuota         bool    `json:"reset_quota"`
+	ClearCooldown      bool    `json:"clear_cooldown"`
+	SyncUsage          bool    `json:"sync_usage"`
 }
 
 func (s *KeyService) Update(ctx context.Context, id uint, upd KeyUpdate) (*models.APIKey, error) {
@@ -86,9 +198,16 @@ func (s *KeyService) Update(ctx context.Context, id uint, upd KeyUpdate) (*model
 			key.IsActive = *upd.IsActive
 		}
 	}
+	if upd.RateLimitPerMinute != nil && *upd.RateLimitPerMinute >= 0 {
+		key.RateLimitPerMinute = *upd.RateLimitPerMinute
+	}
 	if upd.ResetQuota {
 		key.UsedQuota = 0
 	}
+	if upd.ClearCooldown {
+		key.CooldownUntil = nil
+		key.CooldownStrikes = 0
+	}
 
 	if err := s.db.WithContext(ctx).Save(&key).Error; err != nil {
 		return nil, err
@@ -97,34 +216,259 @@ func (s *KeyService) Update(ctx context.Context, id uint, upd KeyUpdate) (*model
 }
 
 func (s *KeyService) Delete(ctx context.Context, id uint) error {
-	return s.db.WithContext(ctx).Delete(&models.APIKey{}, id).Error
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("key_id = ?", id).Delete(&models.KeyUsageSnapshot{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.APIKey{}, id).Error
+	})
 }
 
 func (s *KeyService) MarkInactive(ctx context.Context, id uint) error {
 	return s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Update("is_active", false).Error
 }
 
+const (
+	InvalidReasonProxyUnauthorized = "proxy_unauthorized"
+	InvalidReasonUsageUnauthorized = "usage_unauthorized"
+
+	baseRevalidateBackoff = 10 * time.Minute
+	maxRevalidateBackoff  = 24 * time.Hour
+)
+
 func (s *KeyService) MarkInvalid(ctx context.Context, id uint) error {
+	return s.MarkInvalidWithReason(ctx, id, "")
+}
+
+// MarkInvalidWithReason takes a key out of rotation after Tavily rejected it,
+// recording why and scheduling the first revalidation probe. A key that is
+// already invalid keeps its reason, invalidation time and probe backoff, so
+// quota syncs that probe it again do not restart the schedule.
+func (s *KeyService) MarkInvalidWithReason(ctx context.Context, id uint, reason string) error {
+	before := s.snapshotForEvents(ctx, id)
+	now := time.Now()
+	next := now.Add(revalidateBackoff(0))
+	result := s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ? AND is_invalid = ?", id, false).Updates(map[string]any{
+		"is_active":           false,
+		"is_invalid":          true,
+		"invalid_reason":      reason,
+		"invalidated_at":      &now,
+		"revalidate_attempts": 0,
+		"next_revalidate_at":  &next,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 && before != nil {
+		s.events.Publish(EventKeyInvalid, "Key "+keyName(*before)+" was rejected by Tavily and taken out of rotation", map[string]any{
+			"key_id": before.ID,
+			"alias":  before.Alias,
+			"reason": reason,
+		})
+	}
+	return nil
+}
+
+// RevalidationDue lists invalid keys whose next probe is due.
+func (s *KeyService) RevalidationDue(ctx context.Context, now time.Time) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := s.db.WithContext(ctx).
+		Where("is_invalid = ?", true).
+		Where("next_revalidate_at IS NULL OR next_revalidate_at <= ?", now).
+		Order("id asc").
+		Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ScheduleRevalidation records a failed probe and pushes the next one back.
+func (s *KeyService) ScheduleRevalidation(ctx context.Context, id uint, attempts int) error {
+	next := time.Now().Add(revalidateBackoff(attempts))
 	return s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Updates(map[string]any{
-		"is_active":  false,
-		"is_invalid": true,
+		"revalidate_attempts": attempts,
+		"next_revalidate_at":  &next,
 	}).Error
 }
 
+// Restore puts a previously invalid key back into rotation.
+func (s *KeyService) Restore(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Updates(map[string]any{
+		"is_active":           true,
+		"is_invalid":          false,
+		"invalid_reason":      "",
+		"invalidated_at":      nil,
+		"revalidate_attempts": 0,
+		"next_revalidate_at":  nil,
+	}).Error
+}
+
+func revalidateBackoff(attempts int) time.Duration {
+	d := baseRevalidateBackoff
+	for i := 0; i < attempts && d < maxRevalidateBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRevalidateBackoff {
+		d = maxRevalidateBackoff
+	}
+	return d
+}
+
 func (s *KeyService) MarkExhausted(ctx context.Context, id uint) error {
-	return s.db.WithContext(ctx).Model(&models.APIKey{}).
-		Where("id = ?", id).
-		Update("used_quota", gorm.Expr("total_quota")).Error
+	res := s.db.WithContext(ctx).Model(&models.APIKey{}).
+		Where("id = ? AND used_quota < total_quota", id).
+		Update("used_quota", gorm.Expr("total_quota"))
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		s.publishExhausted(ctx, id)
+	}
+	return nil
 }
 
-func (s *KeyService) IncrementUsed(ctx context.Context, id uint) error {
-	now := time.Now()
+// snapshotForEvents loads a key before a state change so the change can be
+// detected afterwards. It returns nil when nobody listens for events.
+func (s *KeyService) snapshotForEvents(ctx context.Context, id uint) *models.APIKey {
+	if s.events == nil {
+		return nil
+	}
+	key, err := s.FindByID(ctx, id)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// publishIfExhausted reports key.exhausted when a key that had quota left
+// before an update has none now.
+func (s *KeyService) publishIfExhausted(ctx context.Context, before *models.APIKey) {
+	if before == nil || before.UsedQuota >= before.TotalQuota {
+		return
+	}
+	after, err := s.FindByID(ctx, before.ID)
+	if err != nil || after == nil || after.UsedQuota < after.TotalQuota {
+		return
+	}
+	s.publishExhaustedKey(*after)
+}
+
+// publishExhausted reports key.exhausted for a key that an update has just
+// moved from having quota left to having none.
+func (s *KeyService) publishExhausted(ctx context.Context, id uint) {
+	if s.events == nil {
+		return
+	}
+	key, err := s.FindByID(ctx, id)
+	if err != nil || key == nil {
+		return
+	}
+	s.publishExhaustedKey(*key)
+}
+
+func (s *KeyService) publishExhaustedKey(key models.APIKey) {
+	s.events.Publish(EventKeyExhausted, "Key "+keyName(key)+" has used its whole quota", map[string]any{
+		"key_id":      key.ID,
+		"alias":       key.Alias,
+		"total_quota": key.TotalQuota,
+	})
+}
+
+func keyName(key models.APIKey) string {
+	if key.Alias != "" {
+		return key.Alias
+	}
+	return "#" + strconv.FormatUint(uint64(key.ID), 10)
+}
+
+const (
+	baseKeyCooldown = 30 * time.Second
+	maxKeyCooldown  = 30 * time.Minute
+)
+
+// MarkCooldown takes a rate-limited key out of rotation. The cooldown doubles
+// with every consecutive 429, from baseKeyCooldown up to maxKeyCooldown.
+func (s *KeyService) MarkCooldown(ctx context.Context, id uint) (time.Time, error) {
+	var key models.APIKey
+	if err := s.db.WithContext(ctx).First(&key, id).Error; err != nil {
+		return time.Time{}, err
+	}
+	strikes := key.CooldownStrikes + 1
+	until := time.Now().Add(cooldownFor(strikes))
+	err := s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Updates(map[string]any{
+		"cooldown_until":   &until,
+		"cooldown_strikes": strikes,
+	}).Error
+	return until, err
+}
+
+// ClearCooldown resets the 429 backoff after a key answers successfully again.
+func (s *KeyService) ClearCooldown(ctx context.Context, id uint) error {
 	return s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Updates(map[string]any{
-		"used_quota":   gorm.Expr("CASE WHEN used_quota + 1 > total_quota THEN total_quota ELSE used_quota + 1 END"),
-		"last_used_at": &now,
+		"cooldown_until":   nil,
+		"cooldown_strikes": 0,
 	}).Error
 }
 
+func cooldownFor(strikes int) time.Duration {
+	d := baseKeyCooldown
+	for i := 1; i < strikes && d < maxKeyCooldown; i++ {
+		d *= 2
+	}
+	if d > maxKeyCooldown {
+		d = maxKeyCooldown
+	}
+	return d
+}
+
+// AllowRequest checks the key's circuit breaker, claiming the single probe
+// request when it is half-open, and then reserves a slot in the key's local
+// per-minute budget. A key refused by its breaker uses no slot. Keys without
+// a configured limit only go through the breaker check.
+func (s *KeyService) AllowRequest(key models.APIKey) bool {
+	now := time.Now()
+	if !s.breakers.Acquire(key.ID, now) {
+		return false
+	}
+	if key.RateLimitPerMinute > 0 && !s.rate.Allow(key.ID, key.RateLimitPerMinute, now) {
+		s.breakers.Release(key.ID)
+		return false
+	}
+	return true
+}
+
+// IncrementUsed adds credits to the key's used quota, capped at its total.
+// The common case, a key that keeps quota left, is a single UPDATE; only the
+// charge that uses up the quota loads the key to publish key.exhausted.
+func (s *KeyService) IncrementUsed(ctx context.Context, id uint, credits int) error {
+	if credits < 0 {
+		credits = 0
+	}
+	now := time.Now()
+	res := s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ? AND used_quota + ? < total_quota", id, credits).Updates(map[string]any{
+		"used_quota":   gorm.Expr("used_quota + ?", credits),
+		"last_used_at": &now,
+	})
+	if res.Error != nil || res.RowsAffected > 0 {
+		return res.Error
+	}
+
+	// The charge reaches the total. The conditional update succeeds for
+	// exactly one caller, which publishes the event.
+	res = s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ? AND used_quota < total_quota", id).Updates(map[string]any{
+		"used_quota":   gorm.Expr("total_quota"),
+		"last_used_at": &now,
+	})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		s.publishExhausted(ctx, id)
+		return nil
+	}
+	return s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Update("last_used_at", &now).Error
+}
+
 func (s *KeyService) ResetAllUsage(ctx context.Context) error {
 	return s.db.WithContext(ctx).Model(&models.APIKey{}).Update("used_quota", 0).Error
 }
@@ -139,49 +483,61 @@ func (s *KeyService) SetUsage(ctx context.Context, id uint, used int, total *int
 			updates["used_quota"] = *total
 		}
 	}
-	return s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Updates(updates).Error
+	before := s.snapshotForEvents(ctx, id)
+	if err := s.db.WithContext(ctx).Model(&models.APIKey{}).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return err
+	}
+	s.publishIfExhausted(ctx, before)
+	return nil
+}
+
+// keyUsageSnapshotRetention is how long quota snapshots are kept for charts.
+const keyUsageSnapshotRetention = 400 * 24 * time.Hour
+
+// RecordUsageSnapshot stores the key's quota as just reported by Tavily and
+// drops the key's snapshots that have aged out.
+func (s *KeyService) RecordUsageSnapshot(ctx context.Context, id uint, used, total int) error {
+	now := time.Now()
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&models.KeyUsageSnapshot{KeyID: id, UsedQuota: used, TotalQuota: total, CreatedAt: now}).Error; err != nil {
+			return err
+		}
+		return tx.Where("key_id = ? AND created_at < ?", id, now.Add(-keyUsageSnapshotRetention)).Delete(&models.KeyUsageSnapshot{}).Error
+	})
 }
 
 func (s *KeyService) Candidates(ctx context.Context) ([]models.APIKey, error) {
+	available, err := s.available(ctx)
+	if err != nil || len(available) == 0 {
+		return nil, err
+	}
+	return s.ActiveSelector(ctx).Order(available), nil
+}
+
+// AvailableCount reports how many keys Candidates would offer, without
+// advancing stateful selectors such as round-robin.
+func (s *KeyService) AvailableCount(ctx context.Context) (int, error) {
+	available, err := s.available(ctx)
+	return len(available), err
+}
+
+func (s *KeyService) available(ctx context.Context) ([]models.APIKey, error) {
 	var keys []models.APIKey
 	if err := s.db.WithContext(ctx).
 		Where("is_active = ? AND is_invalid = ? AND used_quota < total_quota", true, false).
+		Where("cooldown_until IS NULL OR cooldown_until <= ?", time.Now()).
 		Find(&keys).Error; err != nil {
 		return nil, err
 	}
-	if len(keys) == 0 {
-		return nil, nil
-	}
 
-	type scored struct {
-		key       models.APIKey
-		remaining int
-	}
-	scoredKeys := make([]scored, 0, len(keys))
+	now := time.Now()
+	available := keys[:0]
 	for _, k := range keys {
-		scoredKeys = append(scoredKeys, scored{key: k, remaining: k.TotalQuota - k.UsedQuota})
-	}
-
-	sort.Slice(scoredKeys, func(i, j int) bool {
-		return scoredKeys[i].remaining > scoredKeys[j].remaining
-	})
-
-	// Shuffle ties for fairness.
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
-	out := make([]models.APIKey, 0, len(scoredKeys))
-	for i := 0; i < len(scoredKeys); {
-		j := i + 1
-		for j < len(scoredKeys) && scoredKeys[j].remaining == scoredKeys[i].remaining {
-			j++
-		}
-		group := scoredKeys[i:j]
-		rng.Shuffle(len(group), func(a, b int) { group[a], group[b] = group[b], group[a] })
-		for _, item := range group {
-			out = append(out, item.key)
+		if s.breakers.Available(k.ID, now) {
+			available = append(available, k)
 		}
-		i = j
 	}
-	return out, nil
+	return available, nil
 }
 
 func (s *KeyService) FindByID(ctx context.Context, id uint) (*models.APIKey, error) {
@@ -196,6 +552,15 @@ func (s *KeyService) FindByID(ctx context.Context, id uint) (*models.APIKey, err
 }
 
 func (s *KeyService) DeleteInvalid(ctx context.Context) (int64, error) {
-	result := s.db.WithContext(ctx).Where("is_invalid = ?", true).Delete(&models.APIKey{})
-	return result.RowsAffected, result.Error
+	var deleted int64
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		invalid := tx.Model(&models.APIKey{}).Select("id").Where("is_invalid = ?", true)
+		if err := tx.Where("key_id IN (?)", invalid).Delete(&models.KeyUsageSnapshot{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("is_invalid = ?", true).Delete(&models.APIKey{})
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	return deleted, err
 }
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+)
+
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 100
+)
+
+// KeyTimeSeries is one key's request counts over a TimeSeries window, with the
+// quota snapshots taken by the quota sync over the same window.
+type KeyTimeSeries struct {
+	KeyID       uint            `json:"key_id"`
+	Alias       string          `json:"alias"`
+	Granularity string          `json:"granularity"`
+	Labels      []string        `json:"labels"`
+	Requests    []int64         `json:"requests"`
+	Usage       []KeyUsagePoint `json:"usage"`
+}
+
+type KeyUsagePoint struct {
+	At         time.Time `json:"at"`
+	UsedQuota  int       `json:"used_quota"`
+	TotalQuota int       `json:"total_quota"`
+}
+
+// KeyTimeSeries returns the request and quota history of one key. It returns
+// gorm.ErrRecordNotFound when the key does not exist.
+func (s *StatsService) KeyTimeSeries(ctx context.Context, keyID uint, granularity string) (KeyTimeSeries, error) {
+	w, err := newStatWindow(granularity, time.Now().In(s.loc))
+	if err != nil {
+		return KeyTimeSeries{}, err
+	}
+
+	var key models.APIKey
+	if err := s.db.WithContext(ctx).First(&key, keyID).Error; err != nil {
+		return KeyTimeSeries{}, err
+	}
+
+	counts, err := s.bucketCountsFromStats(ctx, w.name, statKey{keyID: keyID}, w.bucketKey(w.start))
+	if err != nil {
+		return KeyTimeSeries{}, err
+	}
+
+	var snapshots []models.KeyUsageSnapshot
+	if err := s.db.WithContext(ctx).
+		Where("key_id = ? AND created_at >= ? AND created_at < ?", keyID, storageTime(w.start), storageTime(w.end())).
+		Order("created_at asc").
+		Find(&snapshots).Error; err != nil {
+		return KeyTimeSeries{}, err
+	}
+	usage := make([]KeyUsagePoint, 0, len(snapshots))
+	for _, snap := range snapshots {
+		usage = append(usage, KeyUsagePoint{At: snap.CreatedAt, UsedQuota: snap.UsedQuota, TotalQuota: snap.TotalQuota})
+	}
+
+	return KeyTimeSeries{
+		KeyID:       key.ID,
+		Alias:       key.Alias,
+		Granularity: w.name,
+		Labels:      w.labels(),
+		Requests:    w.fill(counts),
+		Usage:       usage,
+	}, nil
+}
+
+// KeyLeaderboard ranks keys by requests served in the current day or month.
+type KeyLeaderboard struct {
+	Period string `json:"period"`
+	Bucket string `json:"bucket"`
+	// TotalRequests counts requests answered by any key; cache hits and
+	// requests that never reached a key are not included.
+	TotalRequests int64                 `json:"total_requests"`
+	Items         []KeyLeaderboardEntry `json:"items"`
+}
+
+type KeyLeaderboardEntry struct {
+	KeyID      uint    `json:"key_id"`
+	Alias      string  `json:"alias"`
+	Deleted    bool    `json:"deleted"`
+	Requests   int64   `json:"requests"`
+	Share      float64 `json:"share"`
+	UsedQuota  int     `json:"used_quota"`
+	TotalQuota int     `json:"total_quota"`
+}
+
+func (s *StatsService) KeyLeaderboard(ctx context.Context, period string, limit int) (KeyLeaderboard, error) {
+	now := time.Now().In(s.loc)
+	var bucket string
+	switch period {
+	case "", "day":
+		period = "day"
+		bucket = now.Format("2006-01-02")
+	case "month":
+		bucket = now.Format("2006-01")
+	default:
+		return KeyLeaderboard{}, fmt.Errorf("invalid period: %s", period)
+	}
+	if limit <= 0 {
+		limit = defaultLeaderboardLimit
+	}
+	if limit > maxLeaderboardLimit {
+		limit = maxLeaderboardLimit
+	}
+
+	var rows []models.RequestStat
+	if err := s.db.WithContext(ctx).
+		Where("granularity = ? AND bucket = ? AND endpoint = ? AND status_code = ? AND key_id <> ?", period, bucket, "", 0, 0).
+		Find(&rows).Error; err != nil {
+		return KeyLeaderboard{}, err
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Count != rows[j].Count {
+			return rows[i].Count > rows[j].Count
+		}
+		return rows[i].KeyID < rows[j].KeyID
+	})
+
+	out := KeyLeaderboard{Period: period, Bucket: bucket, Items: []KeyLeaderboardEntry{}}
+	for _, r := range rows {
+		out.TotalRequests += r.Count
+	}
+	if len(rows) > limit {
+		rows = rows[:limit]
+	}
+
+	ids := make([]uint, 0, len(rows))
+	for _, r := range rows {
+		ids = append(ids, r.KeyID)
+	}
+	var keys []models.APIKey
+	if len(ids) > 0 {
+		if err := s.db.WithContext(ctx).Where("id IN ?", ids).Find(&keys).Error; err != nil {
+			return KeyLeaderboard{}, err
+		}
+	}
+	byID := make(map[uint]models.APIKey, len(keys))
+	for _, k := range keys {
+		byID[k.ID] = k
+	}
+
+	for _, r := range rows {
+		entry := KeyLeaderboardEntry{KeyID: r.KeyID, Requests: r.Count}
+		if k, ok := byID[r.KeyID]; ok {
+			entry.Alias = k.Alias
+			entry.UsedQuota = k.UsedQuota
+			entry.TotalQuota = k.TotalQuota
+		} else {
+			entry.Deleted = true
+		}
+		if out.TotalRequests > 0 {
+			entry.Share = roundTo(float64(r.Count)/float64(out.TotalRequests), 4)
+		}
+		out.Items = append(out.Items, entry)
+	}
+	return out, nil
+}
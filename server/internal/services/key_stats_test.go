@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+)
+
+func TestStatsService_PerKeyBuckets(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	keys := NewKeyService(database, logger)
+	stats := NewStatsService(database)
+
+	busy, err := keys.Create(ctx, "tvly-busy", "busy", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	quiet, err := keys.Create(ctx, "tvly-quiet", "quiet", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	now := time.Now()
+	var events []StatEvent
+	for i := 0; i < 3; i++ {
+		events = append(events, StatEvent{Endpoint: "/search", KeyID: busy.ID, StatusCode: 200, OccurredAt: now})
+	}
+	events = append(events,
+		StatEvent{Endpoint: "/extract", KeyID: quiet.ID, StatusCode: 429, OccurredAt: now},
+		StatEvent{Endpoint: "/search", StatusCode: 200, OccurredAt: now, CacheHit: true},
+	)
+	if err := stats.RecordBatch(ctx, events); err != nil {
+		t.Fatalf("record stats: %v", err)
+	}
+
+	// The key and status rows must not leak into the overall totals.
+	overall, err := stats.TimeSeries(ctx, TimeSeriesQuery{Granularity: "day"})
+	if err != nil {
+		t.Fatalf("timeseries: %v", err)
+	}
+	if got := overall.Series[0].Data[len(overall.Series[0].Data)-1]; got != 5 {
+		t.Fatalf("overall requests today: got %d want %d", got, 5)
+	}
+	if got := overall.Series[1].Data[len(overall.Series[1].Data)-1]; got != 4 {
+		t.Fatalf("search requests today: got %d want %d", got, 4)
+	}
+
+	series, err := stats.KeyTimeSeries(ctx, busy.ID, "hour")
+	if err != nil {
+		t.Fatalf("key timeseries: %v", err)
+	}
+	if got := series.Requests[len(series.Requests)-1]; got != 3 {
+		t.Fatalf("busy key requests this hour: got %d want %d", got, 3)
+	}
+
+	board, err := stats.KeyLeaderboard(ctx, "month", 0)
+	if err != nil {
+		t.Fatalf("leaderboard: %v", err)
+	}
+	if board.TotalRequests != 4 || len(board.Items) != 2 {
+		t.Fatalf("unexpected leaderboard: %+v", board)
+	}
+	if board.Items[0].KeyID != busy.ID || board.Items[0].Share != 0.75 {
+		t.Fatalf("unexpected leader: %+v", board.Items[0])
+	}
+
+	var tooMany int64
+	if err := database.Table("request_stats").Where("granularity = ? AND status_code = ?", "day", 429).Select("COALESCE(SUM(count),0)").Scan(&tooMany).Error; err != nil {
+		t.Fatalf("status rows: %v", err)
+	}
+	if tooMany != 1 {
+		t.Fatalf("429 requests today: got %d want %d", tooMany, 1)
+	}
+}
+
+func TestKeyService_UsageSnapshots(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	keys := NewKeyService(database, logger)
+	stats := NewStatsService(database)
+
+	key, err := keys.Create(ctx, "tvly-a", "a", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	for _, used := range []int{100, 250} {
+		if err := keys.RecordUsageSnapshot(ctx, key.ID, used, 1000); err != nil {
+			t.Fatalf("record snapshot: %v", err)
+		}
+	}
+
+	series, err := stats.KeyTimeSeries(ctx, key.ID, "day")
+	if err != nil {
+		t.Fatalf("key timeseries: %v", err)
+	}
+	if len(series.Usage) != 2 || series.Usage[1].UsedQuota != 250 {
+		t.Fatalf("unexpected usage points: %+v", series.Usage)
+	}
+
+	if err := keys.Delete(ctx, key.ID); err != nil {
+		t.Fatalf("delete key: %v", err)
+	}
+	var left int64
+	if err := database.Table("key_usage_snapshots").Count(&left).Error; err != nil {
+		t.Fatalf("count snapshots: %v", err)
+	}
+	if left != 0 {
+		t.Fatalf("snapshots after delete: got %d want %d", left, 0)
+	}
+}
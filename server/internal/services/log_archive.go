@@ -0,0 +1,228 @@
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+)
+
+const (
+	logArchivePrefix     = "request-logs-"
+	attemptArchivePrefix = "request-attempts-"
+	logArchiveSuffix     = ".jsonl.gz"
+)
+
+// LogArchiveResult describes one archival run.
+type LogArchiveResult struct {
+	Rows     int64    `json:"rows"`
+	Attempts int64    `json:"attempts"`
+	Files    []string `json:"files"`
+}
+
+// ArchiveOlderThan writes every log created before the cutoff to gzip-compressed
+// JSONL files under dir, one per calendar day in the reporting timezone:
+//
+//	dir/2026/01/request-logs-2026-01-02.jsonl.gz
+//
+// The upstream attempts deleted along with those logs go to a matching
+// request-attempts-2026-01-02.jsonl.gz next to it.
+//
+// A day that already has a file gets a new gzip member appended, which gzip
+// readers treat as one continuous stream. The log files use the export format,
+// so they can be loaded back with the import-logs command.
+func (s *LogService) ArchiveOlderThan(ctx context.Context, dir string, before time.Time) (LogArchiveResult, error) {
+	files := &archiveFiles{dir: dir, loc: s.loc, open: make(map[string]*archiveFile)}
+	var result LogArchiveResult
+
+	err := s.Each(ctx, LogFilter{To: &before}, func(entry *models.RequestLog) error {
+		result.Rows++
+		return files.encode(logArchivePrefix, entry.CreatedAt, entry)
+	})
+	if err == nil {
+		err = s.eachAttemptBefore(ctx, before, func(attempt *models.RequestAttempt) error {
+			result.Attempts++
+			return files.encode(attemptArchivePrefix, attempt.CreatedAt, attempt)
+		})
+	}
+	if cerr := files.close(); err == nil {
+		err = cerr
+	}
+	result.Files = files.paths
+	sort.Strings(result.Files)
+	return result, err
+}
+
+// eachAttemptBefore walks the attempts created before the cutoff in batches.
+func (s *LogService) eachAttemptBefore(ctx context.Context, before time.Time, fn func(*models.RequestAttempt) error) error {
+	var lastID uint
+	for {
+		var batch []models.RequestAttempt
+		if err := s.db.WithContext(ctx).
+			Where("created_at < ? AND id > ?", storageTime(before), lastID).
+			Order("id asc").
+			Limit(logExportBatchSize).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		if len(batch) < logExportBatchSize {
+			return nil
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+// archiveFiles appends JSON lines to per-day archive files, opening each file
+// once per run.
+type archiveFiles struct {
+	dir   string
+	loc   *time.Location
+	open  map[string]*archiveFile
+	paths []string
+}
+
+type archiveFile struct {
+	f   *os.File
+	gz  *gzip.Writer
+	enc *json.Encoder
+}
+
+func (a *archiveFiles) encode(prefix string, at time.Time, v any) error {
+	path := archivePath(a.dir, prefix, at.In(a.loc).Format("2006-01-02"))
+	af, ok := a.open[path]
+	if !ok {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		gz := gzip.NewWriter(f)
+		af = &archiveFile{f: f, gz: gz, enc: json.NewEncoder(gz)}
+		a.open[path] = af
+		a.paths = append(a.paths, path)
+	}
+	return af.enc.Encode(v)
+}
+
+func (a *archiveFiles) close() error {
+	var firstErr error
+	for _, af := range a.open {
+		if err := af.gz.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := af.f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func logArchivePath(dir, day string) string {
+	return archivePath(dir, logArchivePrefix, day)
+}
+
+func archivePath(dir, prefix, day string) string {
+	return filepath.Join(dir, day[:4], day[5:7], prefix+day+logArchiveSuffix)
+}
+
+// archiveDay parses the day, as midnight in loc, out of a log or attempt
+// archive file name.
+func archiveDay(name string, loc *time.Location) (time.Time, bool) {
+	if !strings.HasSuffix(name, logArchiveSuffix) {
+		return time.Time{}, false
+	}
+	for _, prefix := range []string{logArchivePrefix, attemptArchivePrefix} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		day, err := time.ParseInLocation("2006-01-02", strings.TrimSuffix(strings.TrimPrefix(name, prefix), logArchiveSuffix), loc)
+		return day, err == nil
+	}
+	return time.Time{}, false
+}
+
+// PruneLogArchives deletes archive files older than retentionDays and then the
+// oldest remaining files until the total size fits in maxBytes. Zero disables
+// either limit. Days are counted in the location of now, which should be the
+// one the archives were written in. The files in keep, such as the ones an archival run has just
+// written, are never deleted but still count towards the size.
+func PruneLogArchives(dir string, retentionDays int, maxBytes int64, now time.Time, keep ...string) (int, error) {
+	type archive struct {
+		path string
+		day  time.Time
+		size int64
+	}
+	var archives []archive
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		day, ok := archiveDay(d.Name(), now.Location())
+		if !ok {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		archives = append(archives, archive{path: path, day: day, size: info.Size()})
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	sort.Slice(archives, func(i, j int) bool {
+		if !archives[i].day.Equal(archives[j].day) {
+			return archives[i].day.Before(archives[j].day)
+		}
+		return archives[i].path < archives[j].path
+	})
+	kept := make(map[string]bool, len(keep))
+	for _, path := range keep {
+		kept[filepath.Clean(path)] = true
+	}
+
+	var total int64
+	for _, a := range archives {
+		total += a.size
+	}
+
+	removed := 0
+	cutoff := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -retentionDays)
+	for _, a := range archives {
+		expired := retentionDays > 0 && a.day.Before(cutoff)
+		oversize := maxBytes > 0 && total > maxBytes
+		if !expired && !oversize {
+			break
+		}
+		if kept[filepath.Clean(a.path)] {
+			continue
+		}
+		if err := os.Remove(a.path); err != nil {
+			return removed, err
+		}
+		total -= a.size
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+)
+
+func TestLogService_ArchiveOlderThan(t *testing.T) {
+	t.Parallel()
+
+	logs := newLogServiceForTest(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	day1 := time.Date(2026, 3, 4, 10, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	cutoff := day2.AddDate(0, 0, 1)
+	seed := func(prefix string, at time.Time, n int) {
+		for i := 0; i < n; i++ {
+			entry := &models.RequestLog{RequestID: prefix + strconv.Itoa(i), Endpoint: "/search", StatusCode: 200, CreatedAt: at}
+			if err := logs.Create(ctx, entry); err != nil {
+				t.Fatalf("create log: %v", err)
+			}
+		}
+	}
+	seed("a-", day1, 3)
+	seed("b-", day2, 2)
+	seed("c-", cutoff.Add(time.Hour), 4)
+	for _, at := range []time.Time{day1, cutoff.Add(time.Hour)} {
+		attempt := &models.RequestAttempt{RequestID: "a-0", Attempt: 1, Endpoint: "/search", Outcome: AttemptOutcomeReturned, CreatedAt: at}
+		if err := logs.CreateAttempt(ctx, attempt); err != nil {
+			t.Fatalf("create attempt: %v", err)
+		}
+	}
+
+	result, err := logs.ArchiveOlderThan(ctx, dir, cutoff)
+	if err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	if result.Rows != 5 || result.Attempts != 1 || len(result.Files) != 3 {
+		t.Fatalf("unexpected archive result: %+v", result)
+	}
+	attempts := filepath.Join(dir, "2026", "03", "request-attempts-2026-03-04.jsonl.gz")
+	want := filepath.Join(dir, "2026", "03", "request-logs-2026-03-04.jsonl.gz")
+	if result.Files[0] != attempts || result.Files[1] != want {
+		t.Fatalf("unexpected archive paths: %v", result.Files)
+	}
+
+	// A second run appends a new gzip member to the same day file.
+	if _, err := logs.ArchiveOlderThan(ctx, dir, day2); err != nil {
+		t.Fatalf("archive again: %v", err)
+	}
+
+	f, err := os.Open(want)
+	if err != nil {
+		t.Fatalf("open archive: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	restored := newLogServiceForTest(t)
+	imported, err := restored.Import(ctx, gz, LogFormatJSONL)
+	if err != nil {
+		t.Fatalf("import archive: %v", err)
+	}
+	if imported.Imported != 3 || imported.Skipped != 3 {
+		t.Fatalf("unexpected import result: %+v", imported)
+	}
+}
+
+func TestLogService_ArchiveThenDeleteInOtherZone(t *testing.T) {
+	t.Parallel()
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+	logs := newLogServiceForTest(t).WithTimezone(tokyo)
+	ctx := context.Background()
+
+	// The cleanup job cuts at midnight in the reporting timezone.
+	cutoff := time.Date(2026, 3, 5, 0, 0, 0, 0, tokyo)
+	for i, at := range []time.Time{cutoff.Add(-time.Hour), cutoff.Add(time.Hour), cutoff.Add(10 * time.Hour)} {
+		id := "req-" + strconv.Itoa(i)
+		if err := logs.Create(ctx, &models.RequestLog{RequestID: id, Endpoint: "/search", StatusCode: 200, CreatedAt: at.Local()}); err != nil {
+			t.Fatalf("create log: %v", err)
+		}
+		if err := logs.CreateAttempt(ctx, &models.RequestAttempt{RequestID: id, Attempt: 1, Endpoint: "/search", Outcome: AttemptOutcomeReturned, CreatedAt: at.Local()}); err != nil {
+			t.Fatalf("create attempt: %v", err)
+		}
+	}
+
+	result, err := logs.ArchiveOlderThan(ctx, t.TempDir(), cutoff)
+	if err != nil {
+		t.Fatalf("archive: %v", err)
+	}
+	deleted, err := logs.DeleteOlderThan(ctx, cutoff)
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if result.Rows != 1 || deleted != result.Rows {
+		t.Fatalf("archived %d logs but deleted %d, want 1 each", result.Rows, deleted)
+	}
+	var attemptsLeft int64
+	if err := logs.db.Model(&models.RequestAttempt{}).Count(&attemptsLeft).Error; err != nil {
+		t.Fatalf("count attempts: %v", err)
+	}
+	if result.Attempts != 1 || attemptsLeft != 2 {
+		t.Fatalf("archived %d attempts with %d left, want 1 and 2", result.Attempts, attemptsLeft)
+	}
+}
+
+func TestPruneLogArchives(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.Local)
+	for _, day := range []string{"2026-03-01", "2026-03-10", "2026-03-18", "2026-03-19"} {
+		path := logArchivePath(dir, day)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(path, make([]byte, 1024), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	removed, err := PruneLogArchives(dir, 14, 0, now)
+	if err != nil {
+		t.Fatalf("prune by age: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("unexpected removed by age: got %d want %d", removed, 1)
+	}
+
+	removed, err = PruneLogArchives(dir, 0, 2048, now)
+	if err != nil {
+		t.Fatalf("prune by size: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("unexpected removed by size: got %d want %d", removed, 1)
+	}
+	if _, err := os.Stat(logArchivePath(dir, "2026-03-10")); !os.IsNotExist(err) {
+		t.Fatalf("oldest archive should be removed first")
+	}
+
+	// Files just written survive the caps even when they alone exceed them.
+	fresh := logArchivePath(dir, "2026-03-18")
+	removed, err = PruneLogArchives(dir, 1, 512, now, fresh)
+	if err != nil {
+		t.Fatalf("prune with kept file: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("unexpected removed with kept file: got %d want %d", removed, 1)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatalf("kept archive was removed: %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+)
+
+const (
+	LogFormatJSONL = "jsonl"
+	LogFormatCSV   = "csv"
+
+	logExportBatchSize = 500
+)
+
+var ErrUnknownLogFormat = errors.New("unknown log format")
+
+// logColumn maps one RequestLog field to and from its CSV cell.
+type logColumn struct {
+	name string
+	get  func(*models.RequestLog) string
+	set  func(*models.RequestLog, string) error
+}
+
+func stringColumn(name string, field func(*models.RequestLog) *string) logColumn {
+	return logColumn{
+		name: name,
+		get:  func(l *models.RequestLog) string { return *field(l) },
+		set:  func(l *models.RequestLog, v string) error { *field(l) = v; return nil },
+	}
+}
+
+func intColumn[T int | int64 | uint](name string, field func(*models.RequestLog) *T) logColumn {
+	return logColumn{
+		name: name,
+		get:  func(l *models.RequestLog) string { return fmt.Sprint(*field(l)) },
+		set: func(l *models.RequestLog, v string) error {
+			if v == "" {
+				return nil
+			}
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return err
+			}
+			*field(l) = T(n)
+			return nil
+		},
+	}
+}
+
+func boolColumn(name string, field func(*models.RequestLog) *bool) logColumn {
+	return logColumn{
+		name: name,
+		get:  func(l *models.RequestLog) string { return strconv.FormatBool(*field(l)) },
+		set: func(l *models.RequestLog, v string) error {
+			if v == "" {
+				return nil
+			}
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			*field(l) = b
+			return nil
+		},
+	}
+}
+
+// logColumns is the CSV layout; it matches the JSON field names.
+var logColumns = []logColumn{
+	stringColumn("request_id", func(l *models.RequestLog) *string { return &l.RequestID }),
+	{
+		name: "created_at",
+		get:  func(l *models.RequestLog) string { return l.CreatedAt.Format(time.RFC3339Nano) },
+		set: func(l *models.RequestLog, v string) error {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return err
+			}
+			l.CreatedAt = t
+			return nil
+		},
+	},
+	stringColumn("endpoint", func(l *models.RequestLog) *string { return &l.Endpoint }),
+	intColumn("status_code", func(l *models.RequestLog) *int { return &l.StatusCode }),
+	intColumn("latency", func(l *models.RequestLog) *int64 { return &l.LatencyMs }),
+	intColumn("key_used", func(l *models.RequestLog) *uint { return &l.KeyUsed }),
+	stringColumn("key_alias", func(l *models.RequestLog) *string { return &l.KeyAlias }),
+	stringColumn("client_ip", func(l *models.RequestLog) *string { return &l.ClientIP }),
+	intColumn("client_key_id", func(l *models.RequestLog) *uint { return &l.ClientKeyID }),
+	stringColumn("client_key_name", func(l *models.RequestLog) *string { return &l.ClientKeyName }),
+	boolColumn("cache_hit", func(l *models.RequestLog) *bool { return &l.CacheHit }),
+	boolColumn("coalesced", func(l *models.RequestLog) *bool { return &l.Coalesced }),
+	intColumn("credits", func(l *models.RequestLog) *int { return &l.Credits }),
+	boolColumn("credits_estimated", func(l *models.RequestLog) *bool { return &l.CreditsEstimated }),
+	intColumn("attempt", func(l *models.RequestLog) *int { return &l.Attempt }),
+	boolColumn("hedged", func(l *models.RequestLog) *bool { return &l.Hedged }),
+	stringColumn("query", func(l *models.RequestLog) *string { return &l.Query }),
+	stringColumn("topic", func(l *models.RequestLog) *string { return &l.Topic }),
+	stringColumn("search_depth", func(l *models.RequestLog) *string { return &l.SearchDepth }),
+	intColumn("max_results", func(l *models.RequestLog) *int { return &l.MaxResults }),
+	stringColumn("include_domains", func(l *models.RequestLog) *string { return &l.IncludeDomains }),
+	stringColumn("trace_id", func(l *models.RequestLog) *string { return &l.TraceID }),
+	stringColumn("request_body", func(l *models.RequestLog) *string { return &l.RequestBody }),
+	boolColumn("request_truncated", func(l *models.RequestLog) *bool { return &l.RequestTruncated }),
+	stringColumn("response_body", func(l *models.RequestLog) *string { return &l.ResponseBody }),
+	boolColumn("response_truncated", func(l *models.RequestLog) *bool { return &l.ResponseTruncated }),
+}
+
+// Each walks the logs matching filter in ascending id order, loading them in
+// batches so the full result set is never held in memory.
+func (s *LogService) Each(ctx context.Context, filter LogFilter, fn func(*models.RequestLog) error) error {
+	var lastID uint
+	for {
+		var batch []models.RequestLog
+		if err := filter.apply(s.db.WithContext(ctx).Model(&models.RequestLog{})).
+			Where("id > ?", lastID).
+			Order("id asc").
+			Limit(logExportBatchSize).
+			Find(&batch).Error; err != nil {
+			return err
+		}
+		for i := range batch {
+			if err := fn(&batch[i]); err != nil {
+				return err
+			}
+		}
+		if len(batch) < logExportBatchSize {
+			return nil
+		}
+		lastID = batch[len(batch)-1].ID
+	}
+}
+
+// Export writes the logs matching filter to w as JSONL or CSV.
+func (s *LogService) Export(ctx context.Context, w io.Writer, format string, filter LogFilter) (int64, error) {
+	var n int64
+	switch format {
+	case LogFormatJSONL:
+		enc := json.NewEncoder(w)
+		err := s.Each(ctx, filter, func(entry *models.RequestLog) error {
+			n++
+			return enc.Encode(entry)
+		})
+		return n, err
+	case LogFormatCSV:
+		cw := csv.NewWriter(w)
+		header := make([]string, len(logColumns))
+		for i, col := range logColumns {
+			header[i] = col.name
+		}
+		if err := cw.Write(header); err != nil {
+			return 0, err
+		}
+		record := make([]string, len(logColumns))
+		err := s.Each(ctx, filter, func(entry *models.RequestLog) error {
+			for i, col := range logColumns {
+				record[i] = col.get(entry)
+			}
+			n++
+			if n%logExportBatchSize == 0 {
+				cw.Flush()
+			}
+			return cw.Write(record)
+		})
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+		return n, err
+	default:
+		return 0, ErrUnknownLogFormat
+	}
+}
+
+// LogImportResult counts rows read by Import.
+type LogImportResult struct {
+	Imported int64 `json:"imported"`
+	Skipped  int64 `json:"skipped"`
+}
+
+// Import loads logs written by Export. Row ids are reassigned; rows whose
+// request_id already exists are skipped, so importing the same file twice is
+// harmless. Imported rows are not added to request_stats: an archive restored
+// into the instance that wrote it was already counted there.
+func (s *LogService) Import(ctx context.Context, r io.Reader, format string) (LogImportResult, error) {
+	var result LogImportResult
+	batch := make([]models.RequestLog, 0, logExportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ids := make([]string, 0, len(batch))
+		for _, entry := range batch {
+			ids = append(ids, entry.RequestID)
+		}
+		var existing []string
+		if err := s.db.WithContext(ctx).Model(&models.RequestLog{}).
+			Where("request_id IN ?", ids).
+			Pluck("request_id", &existing).Error; err != nil {
+			return err
+		}
+		seen := make(map[string]struct{}, len(existing))
+		for _, id := range existing {
+			seen[id] = struct{}{}
+		}
+		fresh := batch[:0]
+		for _, entry := range batch {
+			if _, ok := seen[entry.RequestID]; ok {
+				result.Skipped++
+				continue
+			}
+			seen[entry.RequestID] = struct{}{}
+			entry.ID = 0
+			fresh = append(fresh, entry)
+		}
+		if len(fresh) > 0 {
+			if err := s.db.WithContext(ctx).Create(&fresh).Error; err != nil {
+				return err
+			}
+			result.Imported += int64(len(fresh))
+		}
+		batch = batch[:0]
+		return nil
+	}
+	add := func(entry models.RequestLog) error {
+		if strings.TrimSpace(entry.RequestID) == "" {
+			result.Skipped++
+			return nil
+		}
+		entry.CreatedAt = storageTime(entry.CreatedAt)
+		batch = append(batch, entry)
+		if len(batch) >= logExportBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	switch format {
+	case LogFormatJSONL:
+		sc := bufio.NewScanner(r)
+		sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		line := 0
+		for sc.Scan() {
+			line++
+			text := strings.TrimSpace(sc.Text())
+			if text == "" {
+				continue
+			}
+			var entry models.RequestLog
+			if err := json.Unmarshal([]byte(text), &entry); err != nil {
+				return result, fmt.Errorf("line %d: %w", line, err)
+			}
+			if err := add(entry); err != nil {
+				return result, err
+			}
+		}
+		if err := sc.Err(); err != nil {
+			return result, err
+		}
+	case LogFormatCSV:
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err != nil {
+			return result, err
+		}
+		columns := make([]*logColumn, len(header))
+		for i, name := range header {
+			for j := range logColumns {
+				if logColumns[j].name == strings.TrimSpace(name) {
+					columns[i] = &logColumns[j]
+				}
+			}
+		}
+		for row := 2; ; row++ {
+			record, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return result, err
+			}
+			var entry models.RequestLog
+			for i, v := range record {
+				if i >= len(columns) || columns[i] == nil {
+					continue
+				}
+				if err := columns[i].set(&entry, v); err != nil {
+					return result, fmt.Errorf("row %d, column %s: %w", row, columns[i].name, err)
+				}
+			}
+			if err := add(entry); err != nil {
+				return result, err
+			}
+		}
+	default:
+		return result, ErrUnknownLogFormat
+	}
+	return result, flush()
+}
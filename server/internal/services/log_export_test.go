@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func newLogServiceForTest(t *testing.T) *LogService {
+	t.Helper()
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	return NewLogService(database, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestLogService_ExportImportRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, format := range []string{LogFormatJSONL, LogFormatCSV} {
+		format := format
+		t.Run(format, func(t *testing.T) {
+			t.Parallel()
+
+			src := newLogServiceForTest(t)
+			ctx := context.Background()
+			createdAt := time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC)
+			for i := 0; i < logExportBatchSize+3; i++ {
+				entry := &models.RequestLog{
+					RequestID:   "req-" + strconv.Itoa(i),
+					Endpoint:    "/search",
+					StatusCode:  200,
+					LatencyMs:   int64(i),
+					KeyUsed:     7,
+					CacheHit:    i%2 == 0,
+					Query:       "q, with \"quotes\"",
+					RequestBody: "{\"query\":\"q\"}\nline",
+					CreatedAt:   createdAt,
+				}
+				if i == 0 {
+					entry.Endpoint = "/extract"
+				}
+				if err := src.Create(ctx, entry); err != nil {
+					t.Fatalf("create log: %v", err)
+				}
+			}
+
+			var buf bytes.Buffer
+			n, err := src.Export(ctx, &buf, format, LogFilter{Endpoint: "/search"})
+			if err != nil {
+				t.Fatalf("export: %v", err)
+			}
+			if n != logExportBatchSize+2 {
+				t.Fatalf("unexpected exported rows: got %d want %d", n, logExportBatchSize+2)
+			}
+
+			dst := newLogServiceForTest(t)
+			exported := buf.Bytes()
+			result, err := dst.Import(ctx, bytes.NewReader(exported), format)
+			if err != nil {
+				t.Fatalf("import: %v", err)
+			}
+			if result.Imported != n || result.Skipped != 0 {
+				t.Fatalf("unexpected import result: %+v", result)
+			}
+			again, err := dst.Import(ctx, bytes.NewReader(exported), format)
+			if err != nil {
+				t.Fatalf("re-import: %v", err)
+			}
+			if again.Imported != 0 || again.Skipped != n {
+				t.Fatalf("re-import should skip existing rows: %+v", again)
+			}
+
+			page, err := dst.List(ctx, LogQuery{Size: 1})
+			if err != nil {
+				t.Fatalf("list: %v", err)
+			}
+			got := page.Items[0]
+			if page.Total != n || got.Query != "q, with \"quotes\"" || got.RequestBody != "{\"query\":\"q\"}\nline" || !got.CreatedAt.Equal(createdAt) || got.KeyUsed != 7 {
+				t.Fatalf("unexpected imported row: total=%d %+v", page.Total, got)
+			}
+		})
+	}
+}
+
+func TestLogService_ListTimeBoundsInOtherZone(t *testing.T) {
+	t.Parallel()
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+	logs := newLogServiceForTest(t)
+	ctx := context.Background()
+	at := time.Date(2026, 3, 4, 9, 0, 0, 0, time.UTC)
+	if err := logs.Create(ctx, &models.RequestLog{RequestID: "req-1", Endpoint: "/search", StatusCode: 200, CreatedAt: at}); err != nil {
+		t.Fatalf("create log: %v", err)
+	}
+
+	for _, tc := range []struct {
+		from, to time.Time
+		want     int64
+	}{
+		{at.Add(-time.Minute), at.Add(time.Minute), 1},
+		{at.Add(time.Minute), at.Add(time.Hour), 0},
+	} {
+		from, to := tc.from.In(tokyo), tc.to.In(tokyo)
+		page, err := logs.List(ctx, LogQuery{LogFilter: LogFilter{From: &from, To: &to}})
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		if page.Total != tc.want {
+			t.Fatalf("logs between %s and %s: got %d want %d", from, to, page.Total, tc.want)
+		}
+	}
+}
+
+func TestLogService_ImportNormalizesCreatedAt(t *testing.T) {
+	t.Parallel()
+
+	logs := newLogServiceForTest(t)
+	ctx := context.Background()
+	// 09:00 UTC written with a +09:00 offset.
+	line := `{"request_id":"req-1","endpoint":"/search","status_code":200,"created_at":"2026-03-04T18:00:00+09:00"}`
+	if _, err := logs.Import(ctx, strings.NewReader(line), LogFormatJSONL); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+
+	from := time.Date(2026, 3, 4, 8, 30, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	page, err := logs.List(ctx, LogQuery{LogFilter: LogFilter{From: &from, To: &to}})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if page.Total != 1 {
+		t.Fatalf("imported logs in range: got %d want %d", page.Total, 1)
+	}
+}
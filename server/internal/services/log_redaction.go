@@ -0,0 +1,275 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"tavily-proxy/server/internal/models"
+)
+
+// LogRedactionPolicy controls what of a request and response body reaches the
+// request log. Rules run on the full body before it is cut to CaptureBytes.
+type LogRedactionPolicy struct {
+	// Mode is "full" (store redacted bodies) or "metadata_only" (store no
+	// bodies and no query text, only status, latency, key and parameters).
+	Mode string `json:"mode"`
+	// DropFields are JSON paths removed from request and response bodies, such
+	// as "query" or "results.*.raw_content". "*" matches any array element or
+	// object key; "$." and "[*]" are accepted for familiarity.
+	DropFields []string `json:"drop_fields"`
+	MaskEmails bool     `json:"mask_emails"`
+	MaskPhones bool     `json:"mask_phones"`
+	// MaskPatterns are extra regular expressions whose matches are masked.
+	MaskPatterns []string `json:"mask_patterns"`
+
+	CaptureBytes     int      `json:"capture_bytes"`
+	CaptureEndpoints []string `json:"capture_endpoints"`
+}
+
+const (
+	LogRedactionModeFull         = "full"
+	LogRedactionModeMetadataOnly = "metadata_only"
+
+	maxLogCaptureBytes = 1024 * 1024
+
+	redactedMask = "[redacted]"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	phonePattern = regexp.MustCompile(`\+\d[\d\s\-]{7,}\d|\(?\b\d{3}\)?[\s.\-]\d{3}[\s.\-]\d{4}\b`)
+)
+
+func DefaultLogRedactionPolicy() LogRedactionPolicy {
+	return LogRedactionPolicy{
+		Mode:             LogRedactionModeFull,
+		CaptureBytes:     maxLogBytes,
+		CaptureEndpoints: []string{"/search"},
+	}
+}
+
+// Validate reports the first invalid field as a snake_case error code.
+func (r LogRedactionPolicy) Validate() error {
+	if r.Mode != LogRedactionModeFull && r.Mode != LogRedactionModeMetadataOnly {
+		return errors.New("invalid_mode")
+	}
+	for _, field := range r.DropFields {
+		if len(parseJSONPath(field)) == 0 {
+			return errors.New("invalid_drop_fields")
+		}
+	}
+	for _, pattern := range r.MaskPatterns {
+		if _, err := regexp.Compile(pattern); err != nil || pattern == "" {
+			return errors.New("invalid_mask_patterns")
+		}
+	}
+	if r.CaptureBytes <= 0 || r.CaptureBytes > maxLogCaptureBytes {
+		return errors.New("invalid_capture_bytes")
+	}
+	return nil
+}
+
+// LogRedaction returns the stored redaction policy, or the default when none
+// has been saved.
+func (p *TavilyProxy) LogRedaction(ctx context.Context) (LogRedactionPolicy, error) {
+	policy := DefaultLogRedactionPolicy()
+	if p.settings == nil {
+		return policy, nil
+	}
+	raw, ok, err := p.settings.Get(ctx, SettingLogRedaction)
+	if err != nil {
+		return policy, err
+	}
+	if !ok || strings.TrimSpace(raw) == "" {
+		return policy, nil
+	}
+	var stored LogRedactionPolicy
+	if err := json.Unmarshal([]byte(raw), &stored); err != nil || stored.Validate() != nil {
+		return policy, nil
+	}
+	return stored, nil
+}
+
+func (p *TavilyProxy) SetLogRedaction(ctx context.Context, policy LogRedactionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	policy.CaptureEndpoints = SplitEndpoints(JoinEndpoints(policy.CaptureEndpoints))
+	raw, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return p.settings.Set(ctx, SettingLogRedaction, string(raw))
+}
+
+// logRedactor is a compiled LogRedactionPolicy.
+type logRedactor struct {
+	metadataOnly bool
+	captureBytes int
+	endpoints    string
+	drops        [][]string
+	masks        []*regexp.Regexp
+}
+
+func newLogRedactor(policy LogRedactionPolicy) *logRedactor {
+	r := &logRedactor{
+		metadataOnly: policy.Mode == LogRedactionModeMetadataOnly,
+		captureBytes: policy.CaptureBytes,
+		endpoints:    JoinEndpoints(policy.CaptureEndpoints),
+	}
+	for _, field := range policy.DropFields {
+		r.drops = append(r.drops, parseJSONPath(field))
+	}
+	if policy.MaskEmails {
+		r.masks = append(r.masks, emailPattern)
+	}
+	if policy.MaskPhones {
+		r.masks = append(r.masks, phonePattern)
+	}
+	for _, pattern := range policy.MaskPatterns {
+		if re, err := regexp.Compile(pattern); err == nil {
+			r.masks = append(r.masks, re)
+		}
+	}
+	return r
+}
+
+// redactorCache keeps the compiled policy until the stored setting changes, so
+// regular expressions are not recompiled for every request.
+type redactorCache struct {
+	mu       sync.Mutex
+	raw      string
+	redactor *logRedactor
+}
+
+func (p *TavilyProxy) logRedactor(ctx context.Context) *logRedactor {
+	raw := ""
+	if p.settings != nil {
+		raw, _, _ = p.settings.Get(ctx, SettingLogRedaction)
+	}
+
+	p.redactors.mu.Lock()
+	defer p.redactors.mu.Unlock()
+	if p.redactors.redactor != nil && p.redactors.raw == raw {
+		return p.redactors.redactor
+	}
+	policy, _ := p.LogRedaction(ctx)
+	p.redactors.raw = raw
+	p.redactors.redactor = newLogRedactor(policy)
+	return p.redactors.redactor
+}
+
+// captures reports whether bodies of req are logged at all.
+func (r *logRedactor) captures(req ProxyRequest) bool {
+	return strings.EqualFold(req.Method, http.MethodPost) && endpointListContains(r.endpoints, req.Path)
+}
+
+// body returns the loggable form of a captured body. A body that has fields to
+// drop but cannot be parsed, for example because a stream was cut short, is
+// replaced entirely rather than stored with those fields intact.
+func (r *logRedactor) body(data []byte) (string, bool) {
+	if r.metadataOnly || len(data) == 0 {
+		return "", false
+	}
+	if len(r.drops) > 0 {
+		var doc any
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return redactedMask, false
+		}
+		for _, path := range r.drops {
+			doc = dropJSONPath(doc, path)
+		}
+		var out bytes.Buffer
+		enc := json.NewEncoder(&out)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(doc); err != nil {
+			return redactedMask, false
+		}
+		data = bytes.TrimRight(out.Bytes(), "\n")
+	}
+	return truncateForLog([]byte(r.text(string(data))), r.captureBytes)
+}
+
+// text applies the mask patterns to free text.
+func (r *logRedactor) text(s string) string {
+	for _, re := range r.masks {
+		s = re.ReplaceAllString(s, redactedMask)
+	}
+	return s
+}
+
+// query redacts the query column filled by applySearchParams.
+func (r *logRedactor) query(entry *models.RequestLog) {
+	if entry.Query == "" {
+		return
+	}
+	for _, path := range r.drops {
+		if len(path) == 1 && path[0] == "query" {
+			entry.Query = ""
+			return
+		}
+	}
+	if r.metadataOnly {
+		entry.Query = ""
+		return
+	}
+	entry.Query = r.text(entry.Query)
+}
+
+func parseJSONPath(path string) []string {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+	path = strings.ReplaceAll(path, "[*]", ".*")
+	path = strings.Trim(path, ".")
+	if path == "" {
+		return nil
+	}
+	parts := strings.Split(path, ".")
+	for _, part := range parts {
+		if part == "" {
+			return nil
+		}
+	}
+	return parts
+}
+
+func dropJSONPath(node any, path []string) any {
+	if len(path) == 0 {
+		return node
+	}
+	head, rest := path[0], path[1:]
+	switch v := node.(type) {
+	case map[string]any:
+		if len(rest) == 0 {
+			if head == "*" {
+				return map[string]any{}
+			}
+			delete(v, head)
+			return v
+		}
+		for k, child := range v {
+			if head == "*" || head == k {
+				v[k] = dropJSONPath(child, rest)
+			}
+		}
+		return v
+	case []any:
+		if head != "*" {
+			return v
+		}
+		if len(rest) == 0 {
+			return []any{}
+		}
+		for i, child := range v {
+			v[i] = dropJSONPath(child, rest)
+		}
+		return v
+	}
+	return node
+}
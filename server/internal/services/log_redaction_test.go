@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestLogRedactor_Body(t *testing.T) {
+	t.Parallel()
+
+	policy := DefaultLogRedactionPolicy()
+	policy.DropFields = []string{"$.results[*].raw_content", "api_key"}
+	policy.MaskEmails = true
+	policy.MaskPhones = true
+	r := newLogRedactor(policy)
+
+	got, truncated := r.body([]byte(`{"api_key":"tvly-x","answer":"mail a@b.com or call +1 415 555 0100","results":[{"url":"u","score":0.98765432,"raw_content":"secret"}]}`))
+	want := `{"answer":"mail [redacted] or call [redacted]","results":[{"score":0.98765432,"url":"u"}]}`
+	if got != want || truncated {
+		t.Fatalf("unexpected redacted body:\n got %s\nwant %s", got, want)
+	}
+
+	if got, _ := r.body([]byte(`{"results":[{"raw_content":"cut`)); got != redactedMask {
+		t.Fatalf("unparsable body should be fully redacted, got %q", got)
+	}
+
+	policy.Mode = LogRedactionModeMetadataOnly
+	if got, _ := newLogRedactor(policy).body([]byte(`{"query":"q"}`)); got != "" {
+		t.Fatalf("metadata_only should drop bodies, got %q", got)
+	}
+}
+
+func TestTavilyProxy_AppliesLogRedaction(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[{"url":"u","raw_content":"page text for jane@example.com"}]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	settings := NewSettingsService(database)
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	if _, err := keys.Create(ctx, "tvly-test", "test", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger).
+		WithSettings(settings)
+	policy := DefaultLogRedactionPolicy()
+	policy.MaskEmails = true
+	policy.CaptureBytes = 24
+	policy.CaptureEndpoints = []string{"/search", "/extract"}
+	if err := proxy.SetLogRedaction(ctx, policy); err != nil {
+		t.Fatalf("set redaction: %v", err)
+	}
+
+	if _, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"who is jane@example.com"}`)}); err != nil {
+		t.Fatalf("search: %v", err)
+	}
+	if _, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/extract", Body: []byte(`{"urls":["u"]}`)}); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+
+	var entries []models.RequestLog
+	if err := database.WithContext(ctx).Order("id ASC").Find(&entries).Error; err != nil {
+		t.Fatalf("load logs: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected logs count: got %d want %d", len(entries), 2)
+	}
+	search := entries[0]
+	if search.Query != "who is [redacted]" {
+		t.Fatalf("unexpected query column: %q", search.Query)
+	}
+	if search.RequestBody != `{"query":"who is [redact` || !search.RequestTruncated {
+		t.Fatalf("unexpected request body: %q truncated=%v", search.RequestBody, search.RequestTruncated)
+	}
+	if entries[1].ResponseBody == "" {
+		t.Fatalf("extract bodies should be captured once the endpoint is configured")
+	}
+}
@@ -2,7 +2,10 @@ package services
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
 	"tavily-proxy/server/internal/models"
@@ -13,21 +16,76 @@ import (
 type LogService struct {
 	db     *gorm.DB
 	logger *slog.Logger
+	// loc is the reporting timezone, which archive files are split by.
+	loc *time.Location
 }
 
 func NewLogService(db *gorm.DB, logger *slog.Logger) *LogService {
-	return &LogService{db: db, logger: logger}
+	return &LogService{db: db, logger: logger, loc: time.Local}
+}
+
+// WithTimezone sets the reporting timezone: plain dates in filters and the
+// calendar days archive files cover are taken in loc.
+func (s *LogService) WithTimezone(loc *time.Location) *LogService {
+	s.loc = loc
+	return s
+}
+
+func (s *LogService) Location() *time.Location {
+	return s.loc
 }
 
 func (s *LogService) Create(ctx context.Context, entry *models.RequestLog) error {
+	entry.CreatedAt = storageTime(entry.CreatedAt)
 	return s.db.WithContext(ctx).Create(entry).Error
 }
 
+// CreateBatch inserts logs and attempts in one transaction.
+func (s *LogService) CreateBatch(ctx context.Context, logs []*models.RequestLog, attempts []*models.RequestAttempt) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(logs) > 0 {
+			for _, l := range logs {
+				l.CreatedAt = storageTime(l.CreatedAt)
+			}
+			if err := tx.CreateInBatches(logs, 200).Error; err != nil {
+				return err
+			}
+		}
+		if len(attempts) > 0 {
+			for _, a := range attempts {
+				a.CreatedAt = storageTime(a.CreatedAt)
+			}
+			if err := tx.CreateInBatches(attempts, 200).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *LogService) CreateAttempt(ctx context.Context, attempt *models.RequestAttempt) error {
+	attempt.CreatedAt = storageTime(attempt.CreatedAt)
+	return s.db.WithContext(ctx).Create(attempt).Error
+}
+
+// ListAttempts returns the upstream calls made for a proxy request, in order.
+func (s *LogService) ListAttempts(ctx context.Context, requestID string) ([]models.RequestAttempt, error) {
+	var attempts []models.RequestAttempt
+	if err := s.db.WithContext(ctx).
+		Where("request_id = ?", requestID).
+		Order("attempt asc, id asc").
+		Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
 type PaginatedLogs struct {
-	Items []models.RequestLog `json:"items"`
-	Total int64               `json:"total"`
-	Page  int                 `json:"page"`
-	Size  int                 `json:"page_size"`
+	Items      []models.RequestLog `json:"items"`
+	Total      int64               `json:"total"`
+	Page       int                 `json:"page"`
+	Size       int                 `json:"page_size"`
+	NextCursor string              `json:"next_cursor,omitempty"`
 }
 
 type StatusCodeCount struct {
@@ -35,39 +93,125 @@ type StatusCodeCount struct {
 	Count      int64 `json:"count"`
 }
 
-func (s *LogService) List(ctx context.Context, page, size int, statusCode *int) (PaginatedLogs, error) {
+// LogFilter narrows request logs. Zero values match everything.
+type LogFilter struct {
+	StatusCode   *int
+	Endpoint     string
+	KeyID        *uint
+	KeyAlias     string
+	ClientIP     string
+	TraceID      string
+	MinLatencyMs *int64
+	MaxLatencyMs *int64
+	From         *time.Time
+	To           *time.Time
+	// Search matches a substring of the captured request body, such as the
+	// search query. It is a LIKE scan over every row the other filters leave,
+	// so combine it with a time range on large logs.
+	Search string
+}
+
+// LogQuery is a page of filtered logs, newest first. With a Cursor (the
+// next_cursor of the previous page) the page is fetched by keyset instead of
+// OFFSET and Total is not computed, so deep pages stay cheap.
+type LogQuery struct {
+	LogFilter
+	Page   int
+	Size   int
+	Cursor string
+}
+
+func (f LogFilter) apply(db *gorm.DB) *gorm.DB {
+	if f.StatusCode != nil {
+		db = db.Where("status_code = ?", *f.StatusCode)
+	}
+	if f.Endpoint != "" {
+		db = db.Where("endpoint = ?", f.Endpoint)
+	}
+	if f.KeyID != nil {
+		db = db.Where("key_used = ?", *f.KeyID)
+	}
+	if f.KeyAlias != "" {
+		db = db.Where("key_alias = ?", f.KeyAlias)
+	}
+	if f.ClientIP != "" {
+		db = db.Where("client_ip = ?", f.ClientIP)
+	}
+	if f.TraceID != "" {
+		db = db.Where("trace_id = ?", f.TraceID)
+	}
+	if f.MinLatencyMs != nil {
+		db = db.Where("latency_ms >= ?", *f.MinLatencyMs)
+	}
+	if f.MaxLatencyMs != nil {
+		db = db.Where("latency_ms <= ?", *f.MaxLatencyMs)
+	}
+	if f.From != nil {
+		db = db.Where("created_at >= ?", storageTime(*f.From))
+	}
+	if f.To != nil {
+		db = db.Where("created_at < ?", storageTime(*f.To))
+	}
+	if f.Search != "" {
+		db = db.Where("request_body LIKE ? ESCAPE '\\'", "%"+escapeLike(f.Search)+"%")
+	}
+	return db
+}
+
+// storageTime converts t to the zone request logs and attempts are stored in.
+// SQLite keeps times as text with their offset, so created_at values and the
+// bounds compared against them must share one zone for the string order to
+// match time order.
+func storageTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.In(time.Local)
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+func (s *LogService) List(ctx context.Context, q LogQuery) (PaginatedLogs, error) {
+	page, size := q.Page, q.Size
 	if page < 1 {
 		page = 1
 	}
 	if size <= 0 || size > 200 {
 		size = 20
 	}
-	offset := (page - 1) * size
 
-	base := s.db.WithContext(ctx).Model(&models.RequestLog{})
-	if statusCode != nil {
-		base = base.Where("status_code = ?", *statusCode)
-	}
+	query := q.LogFilter.apply(s.db.WithContext(ctx).Model(&models.RequestLog{})).
+		Order("id desc").
+		Limit(size)
 
 	var total int64
-	if err := base.Count(&total).Error; err != nil {
-		return PaginatedLogs{}, err
+	if q.Cursor != "" {
+		beforeID, err := strconv.ParseUint(q.Cursor, 10, 64)
+		if err != nil || beforeID == 0 {
+			return PaginatedLogs{}, ErrInvalidCursor
+		}
+		query = query.Where("id < ?", beforeID)
+	} else {
+		if err := q.LogFilter.apply(s.db.WithContext(ctx).Model(&models.RequestLog{})).Count(&total).Error; err != nil {
+			return PaginatedLogs{}, err
+		}
+		query = query.Offset((page - 1) * size)
 	}
 
 	var logs []models.RequestLog
-	query := s.db.WithContext(ctx).
-		Model(&models.RequestLog{}).
-		Order("id desc").
-		Limit(size).
-		Offset(offset)
-	if statusCode != nil {
-		query = query.Where("status_code = ?", *statusCode)
-	}
 	if err := query.Find(&logs).Error; err != nil {
 		return PaginatedLogs{}, err
 	}
 
-	return PaginatedLogs{Items: logs, Total: total, Page: page, Size: size}, nil
+	out := PaginatedLogs{Items: logs, Total: total, Page: page, Size: size}
+	if len(logs) == size {
+		out.NextCursor = strconv.FormatUint(uint64(logs[len(logs)-1].ID), 10)
+	}
+	return out, nil
+}
+
+func escapeLike(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(v)
 }
 
 func (s *LogService) StatusCodeCounts(ctx context.Context) ([]StatusCodeCount, error) {
@@ -84,11 +228,24 @@ func (s *LogService) StatusCodeCounts(ctx context.Context) ([]StatusCodeCount, e
 }
 
 func (s *LogService) DeleteOlderThan(ctx context.Context, before time.Time) (int64, error) {
+	before = storageTime(before)
 	result := s.db.WithContext(ctx).Where("created_at < ?", before).Delete(&models.RequestLog{})
-	return result.RowsAffected, result.Error
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if err := s.db.WithContext(ctx).Where("created_at < ?", before).Delete(&models.RequestAttempt{}).Error; err != nil {
+		return result.RowsAffected, err
+	}
+	return result.RowsAffected, nil
 }
 
 func (s *LogService) DeleteAll(ctx context.Context) (int64, error) {
 	result := s.db.WithContext(ctx).Where("1 = 1").Delete(&models.RequestLog{})
-	return result.RowsAffected, result.Error
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if err := s.db.WithContext(ctx).Where("1 = 1").Delete(&models.RequestAttempt{}).Error; err != nil {
+		return result.RowsAffected, err
+	}
+	return result.RowsAffected, nil
 }
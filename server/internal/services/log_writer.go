@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+)
+
+// LogWriter takes request logs, attempts and stat counts off the request path.
+// Entries are queued in memory and written by one goroutine in batches, every
+// BatchSize entries or FlushInterval, whichever comes first. When the queue is
+// full new entries are dropped and counted rather than blocking the proxy.
+type LogWriter struct {
+	logs   *LogService
+	stats  *StatsService
+	logger *slog.Logger
+
+	batchSize     int
+	flushInterval time.Duration
+
+	queue   chan logWriterItem
+	closing chan struct{}
+	done    chan struct{}
+
+	// mu orders enqueue against Close so nothing lands in the queue after the
+	// drain has finished.
+	mu     sync.RWMutex
+	closed bool
+
+	pending atomic.Int64
+	written atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+}
+
+type LogWriterConfig struct {
+	QueueSize     int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// LogWriterStats reports the writer's queue and counters. QueueDepth counts
+// every accepted entry not yet written, including the batch being assembled.
+type LogWriterStats struct {
+	QueueDepth    int   `json:"queue_depth"`
+	QueueCapacity int   `json:"queue_capacity"`
+	Written       int64 `json:"written"`
+	Dropped       int64 `json:"dropped"`
+	Failed        int64 `json:"failed"`
+}
+
+type logWriterItem struct {
+	log     *models.RequestLog
+	attempt *models.RequestAttempt
+	stat    *StatEvent
+}
+
+func NewLogWriter(logs *LogService, stats *StatsService, cfg LogWriterConfig, logger *slog.Logger) *LogWriter {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 200
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 500 * time.Millisecond
+	}
+	return &LogWriter{
+		logs:          logs,
+		stats:         stats,
+		logger:        logger,
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval,
+		queue:         make(chan logWriterItem, cfg.QueueSize),
+		closing:       make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// Start launches the background writer. Call Close to drain and stop it.
+func (w *LogWriter) Start() {
+	go w.run()
+}
+
+// Close stops accepting entries and waits until everything queued has been
+// written, or ctx expires.
+func (w *LogWriter) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.closing)
+	}
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *LogWriter) AddLog(entry *models.RequestLog) {
+	w.enqueue(logWriterItem{log: entry})
+}
+
+func (w *LogWriter) AddAttempt(attempt *models.RequestAttempt) {
+	w.enqueue(logWriterItem{attempt: attempt})
+}
+
+func (w *LogWriter) AddStat(ev StatEvent) {
+	w.enqueue(logWriterItem{stat: &ev})
+}
+
+func (w *LogWriter) enqueue(item logWriterItem) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.dropped.Add(1)
+		return
+	}
+	// Count the entry before the writer can see it, so a fast flush never
+	// takes pending below zero.
+	w.pending.Add(1)
+	select {
+	case w.queue <- item:
+	default:
+		w.pending.Add(-1)
+		w.dropped.Add(1)
+	}
+}
+
+// Stats is safe to call on a nil writer.
+func (w *LogWriter) Stats() LogWriterStats {
+	if w == nil {
+		return LogWriterStats{}
+	}
+	return LogWriterStats{
+		QueueDepth:    int(w.pending.Load()),
+		QueueCapacity: cap(w.queue),
+		Written:       w.written.Load(),
+		Dropped:       w.dropped.Load(),
+		Failed:        w.failed.Load(),
+	}
+}
+
+func (w *LogWriter) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]logWriterItem, 0, w.batchSize)
+	for {
+		select {
+		case item := <-w.queue:
+			batch = append(batch, item)
+			if len(batch) >= w.batchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-w.closing:
+			// Enqueue is already refusing new items, so the queue only shrinks.
+			for {
+				select {
+				case item := <-w.queue:
+					batch = append(batch, item)
+					if len(batch) >= w.batchSize {
+						w.flush(batch)
+						batch = batch[:0]
+					}
+				default:
+					if len(batch) > 0 {
+						w.flush(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (w *LogWriter) flush(batch []logWriterItem) {
+	defer w.pending.Add(-int64(len(batch)))
+
+	var (
+		logs     []*models.RequestLog
+		attempts []*models.RequestAttempt
+		events   []StatEvent
+	)
+	for _, item := range batch {
+		switch {
+		case item.log != nil:
+			logs = append(logs, item.log)
+		case item.attempt != nil:
+			attempts = append(attempts, item.attempt)
+		case item.stat != nil:
+			events = append(events, *item.stat)
+		}
+	}
+
+	// The writer outlives request contexts, and a shutdown drain must not be
+	// cut short by the cancelled server context.
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if len(logs) > 0 || len(attempts) > 0 {
+		if err := w.logs.CreateBatch(ctx, logs, attempts); err != nil {
+			w.failed.Add(int64(len(logs) + len(attempts)))
+			w.logger.Error("log-writer: log batch failed", "logs", len(logs), "attempts", len(attempts), "err", err)
+		} else {
+			w.written.Add(int64(len(logs) + len(attempts)))
+		}
+	}
+	if len(events) > 0 && w.stats != nil {
+		if err := w.stats.RecordBatch(ctx, events); err != nil {
+			w.failed.Add(int64(len(events)))
+			w.logger.Error("log-writer: stats batch failed", "events", len(events), "err", err)
+		} else {
+			w.written.Add(int64(len(events)))
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestLogWriter_BatchesAndDrainsOnClose(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+	stats := NewStatsService(database)
+
+	ctx := context.Background()
+	if _, err := keys.Create(ctx, "tvly-test", "test", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	// A long interval and large batch keep everything queued until Close.
+	writer := NewLogWriter(logs, stats, LogWriterConfig{QueueSize: 100, BatchSize: 100, FlushInterval: time.Hour}, logger)
+	writer.Start()
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, stats, logger).
+		WithLogWriter(writer)
+
+	const requests = 5
+	for i := 0; i < requests; i++ {
+		if _, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)}); err != nil {
+			t.Fatalf("proxy request: %v", err)
+		}
+	}
+
+	var count int64
+	if err := database.WithContext(ctx).Model(&models.RequestLog{}).Count(&count).Error; err != nil {
+		t.Fatalf("count logs: %v", err)
+	}
+	if count != 0 {
+		t.Fatalf("logs should still be queued: got %d rows", count)
+	}
+	if depth := writer.Stats().QueueDepth; depth != requests*3 {
+		t.Fatalf("unexpected queue depth: got %d want %d", depth, requests*3)
+	}
+
+	if err := writer.Close(ctx); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+
+	if err := database.WithContext(ctx).Model(&models.RequestLog{}).Count(&count).Error; err != nil {
+		t.Fatalf("count logs: %v", err)
+	}
+	if count != requests {
+		t.Fatalf("unexpected logs after drain: got %d want %d", count, requests)
+	}
+	var stat models.RequestStat
+	if err := database.WithContext(ctx).First(&stat, "granularity = ? AND endpoint = ?", "day", "/search").Error; err != nil {
+		t.Fatalf("load stat: %v", err)
+	}
+	if stat.Count != requests {
+		t.Fatalf("unexpected stat count: got %d want %d", stat.Count, requests)
+	}
+
+	got := writer.Stats()
+	if got.Written != requests*3 || got.Dropped != 0 || got.QueueDepth != 0 {
+		t.Fatalf("unexpected writer stats: %+v", got)
+	}
+
+	writer.AddLog(&models.RequestLog{RequestID: "late"})
+	if got := writer.Stats().Dropped; got != 1 {
+		t.Fatalf("entries after close should be dropped: got %d want %d", got, 1)
+	}
+}
+
+func TestLogWriter_DropsWhenQueueFull(t *testing.T) {
+	t.Parallel()
+
+	writer := NewLogWriter(nil, nil, LogWriterConfig{QueueSize: 1}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	writer.AddStat(StatEvent{Endpoint: "/search", OccurredAt: time.Now()})
+	writer.AddStat(StatEvent{Endpoint: "/search", OccurredAt: time.Now()})
+
+	got := writer.Stats()
+	if got.QueueDepth != 1 || got.Dropped != 1 {
+		t.Fatalf("unexpected writer stats: %+v", got)
+	}
+}
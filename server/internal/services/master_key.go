@@ -20,6 +20,8 @@ type MasterKeyService struct {
 	db     *gorm.DB
 	logger *slog.Logger
 
+	events *EventBus
+
 	mu  sync.RWMutex
 	key string
 }
@@ -28,6 +30,12 @@ func NewMasterKeyService(db *gorm.DB, logger *slog.Logger) *MasterKeyService {
 	return &MasterKeyService{db: db, logger: logger}
 }
 
+// WithEvents publishes master_key.reset whenever the key is rotated.
+func (s *MasterKeyService) WithEvents(events *EventBus) *MasterKeyService {
+	s.events = events
+	return s
+}
+
 func (s *MasterKeyService) LoadOrCreate(ctx context.Context) error {
 	var setting models.Setting
 	err := s.db.WithContext(ctx).First(&setting, "key = ?", masterKeySettingKey).Error
@@ -78,6 +86,7 @@ func (s *MasterKeyService) Reset(ctx context.Context) (string, error) {
 	s.mu.Lock()
 	s.key = newKey
 	s.mu.Unlock()
+	s.events.Publish(EventMasterKeyReset, "The master key was reset; clients using the old key are now rejected", nil)
 	return newKey, nil
 }
 
@@ -0,0 +1,685 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	NotificationFormatJSON    = "json"
+	NotificationFormatSlack   = "slack"
+	NotificationFormatDiscord = "discord"
+
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+
+	// Webhook request headers. The signature is hex HMAC-SHA256 over
+	// "<timestamp>.<body>" keyed with the target secret.
+	HeaderNotificationEvent     = "X-Tavily-Proxy-Event"
+	HeaderNotificationDelivery  = "X-Tavily-Proxy-Delivery"
+	HeaderNotificationTimestamp = "X-Tavily-Proxy-Timestamp"
+	HeaderNotificationSignature = "X-Tavily-Proxy-Signature"
+
+	maxDeliveryAttempts    = 6
+	baseDeliveryBackoff    = 30 * time.Second
+	maxDeliveryBackoff     = time.Hour
+	deliveryTimeout        = 10 * time.Second
+	deliveryRetention      = 30 * 24 * time.Hour
+	deliveryResponseLimit  = 1024
+	notificationQueueSize  = 256
+	notificationWorkers    = 4
+	notificationPollPeriod = 5 * time.Second
+	poolCheckPeriod        = time.Minute
+)
+
+var (
+	ErrInvalidNotificationURL    = errors.New("invalid notification url")
+	ErrInvalidNotificationFormat = errors.New("invalid notification format")
+	ErrUnknownEventType          = errors.New("unknown event type")
+)
+
+// NotificationService delivers events from the bus to webhook targets. Each
+// event becomes one delivery row per subscribed target; rows are retried with
+// exponential backoff and kept afterwards as the delivery log. It also watches
+// the key pool and raises pool.quota_low and pool.active_keys_low when the
+// configured thresholds are crossed.
+type NotificationService struct {
+	db       *gorm.DB
+	settings *SettingsService
+	keys     *KeyService
+	events   *EventBus
+	logger   *slog.Logger
+	client   *http.Client
+
+	queue chan Event
+	// kick wakes the dispatcher after new deliveries are recorded.
+	kick chan struct{}
+
+	// workers bounds concurrent deliveries; busy holds the targets with a
+	// delivery in flight, which get at most one at a time.
+	workers chan struct{}
+	busyMu  sync.Mutex
+	busy    map[uint]bool
+
+	// retryBase is baseDeliveryBackoff; tests shorten it.
+	retryBase time.Duration
+
+	// Pool alerts fire once when a threshold is crossed and re-arm after the
+	// pool recovers.
+	poolMu        sync.Mutex
+	quotaAlerted  bool
+	activeAlerted bool
+}
+
+type NotificationTargetInput struct {
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Format   string   `json:"format"`
+	Secret   string   `json:"secret"`
+	Events   []string `json:"events"`
+	IsActive *bool    `json:"is_active"`
+}
+
+type NotificationTargetUpdate struct {
+	Name     *string   `json:"name"`
+	URL      *string   `json:"url"`
+	Format   *string   `json:"format"`
+	Secret   *string   `json:"secret"`
+	Events   *[]string `json:"events"`
+	IsActive *bool     `json:"is_active"`
+}
+
+// NotificationThresholds configures the pool alerts. Zero disables an alert.
+type NotificationThresholds struct {
+	PoolQuotaPercent int `json:"pool_quota_percent"`
+	MinActiveKeys    int `json:"min_active_keys"`
+}
+
+type DeliveryFilter struct {
+	TargetID *uint
+	Status   string
+	Limit    int
+}
+
+func NewNotificationService(db *gorm.DB, settings *SettingsService, keys *KeyService, events *EventBus, logger *slog.Logger) *NotificationService {
+	s := &NotificationService{
+		db:        db,
+		settings:  settings,
+		keys:      keys,
+		events:    events,
+		logger:    logger,
+		client:    &http.Client{Timeout: deliveryTimeout},
+		queue:     make(chan Event, notificationQueueSize),
+		kick:      make(chan struct{}, 1),
+		workers:   make(chan struct{}, notificationWorkers),
+		busy:      make(map[uint]bool),
+		retryBase: baseDeliveryBackoff,
+	}
+	events.Subscribe(s.enqueue)
+	return s
+}
+
+// Start runs the event consumer and the delivery dispatcher until ctx is
+// cancelled. The consumer only records deliveries, so slow webhooks never
+// hold up the queue the bus feeds.
+func (s *NotificationService) Start(ctx context.Context) {
+	go func() {
+		poll := time.NewTicker(notificationPollPeriod)
+		defer poll.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.kick:
+			case <-poll.C:
+			}
+			// Each round only picks up targets that are not busy, so rounds
+			// may overlap while a slow target finishes.
+			go s.DispatchDue(ctx, time.Now())
+		}
+	}()
+
+	go func() {
+		pool := time.NewTicker(poolCheckPeriod)
+		defer pool.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-s.queue:
+				if err := s.record(ctx, ev); err != nil {
+					s.logger.Error("notifications: failed to queue event", "type", ev.Type, "err", err)
+				}
+				if ev.Type == EventKeyInvalid || ev.Type == EventKeyExhausted {
+					s.CheckPool(ctx)
+				}
+				select {
+				case s.kick <- struct{}{}:
+				default:
+				}
+			case <-pool.C:
+				s.CheckPool(ctx)
+				if err := s.pruneDeliveries(ctx, time.Now().Add(-deliveryRetention)); err != nil {
+					s.logger.Error("notifications: prune failed", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// enqueue is the bus subscriber. It never blocks the publisher; when the queue
+// is full the event is logged and dropped.
+func (s *NotificationService) enqueue(ev Event) {
+	select {
+	case s.queue <- ev:
+	default:
+		s.logger.Warn("notifications: queue full, dropping event", "type", ev.Type, "id", ev.ID)
+	}
+}
+
+func (s *NotificationService) List(ctx context.Context) ([]models.NotificationTarget, error) {
+	var items []models.NotificationTarget
+	if err := s.db.WithContext(ctx).Order("id asc").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Create stores a target. When no secret is given one is generated; the
+// secret is returned here so the receiver can verify signatures.
+func (s *NotificationService) Create(ctx context.Context, in NotificationTargetInput) (*models.NotificationTarget, string, error) {
+	target := models.NotificationTarget{
+		Name:     strings.TrimSpace(in.Name),
+		URL:      strings.TrimSpace(in.URL),
+		Format:   strings.TrimSpace(in.Format),
+		Secret:   in.Secret,
+		IsActive: in.IsActive == nil || *in.IsActive,
+	}
+	if target.Format == "" {
+		target.Format = NotificationFormatJSON
+	}
+	events, err := joinEventTypes(in.Events)
+	if err != nil {
+		return nil, "", err
+	}
+	target.Events = events
+	if err := validateNotificationTarget(target); err != nil {
+		return nil, "", err
+	}
+	if target.Secret == "" {
+		if target.Secret, err = generateSecret(32); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := s.db.WithContext(ctx).Create(&target).Error; err != nil {
+		return nil, "", err
+	}
+	return &target, target.Secret, nil
+}
+
+func (s *NotificationService) Update(ctx context.Context, id uint, upd NotificationTargetUpdate) (*models.NotificationTarget, error) {
+	var target models.NotificationTarget
+	if err := s.db.WithContext(ctx).First(&target, id).Error; err != nil {
+		return nil, err
+	}
+	if upd.Name != nil && strings.TrimSpace(*upd.Name) != "" {
+		target.Name = strings.TrimSpace(*upd.Name)
+	}
+	if upd.URL != nil {
+		target.URL = strings.TrimSpace(*upd.URL)
+	}
+	if upd.Format != nil {
+		target.Format = strings.TrimSpace(*upd.Format)
+	}
+	if upd.Secret != nil && *upd.Secret != "" {
+		target.Secret = *upd.Secret
+	}
+	if upd.Events != nil {
+		events, err := joinEventTypes(*upd.Events)
+		if err != nil {
+			return nil, err
+		}
+		target.Events = events
+	}
+	if upd.IsActive != nil {
+		target.IsActive = *upd.IsActive
+	}
+	if err := validateNotificationTarget(target); err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Save(&target).Error; err != nil {
+		return nil, err
+	}
+	return &target, nil
+}
+
+func (s *NotificationService) Delete(ctx context.Context, id uint) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("target_id = ?", id).Delete(&models.NotificationDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.NotificationTarget{}, id).Error
+	})
+}
+
+// SendTest queues a notification.test event for one target, regardless of the
+// events it subscribes to, and delivers it straight away.
+func (s *NotificationService) SendTest(ctx context.Context, id uint) (*models.NotificationDelivery, error) {
+	var target models.NotificationTarget
+	if err := s.db.WithContext(ctx).First(&target, id).Error; err != nil {
+		return nil, err
+	}
+	ev := Event{
+		ID:         uuid.NewString(),
+		Type:       EventNotificationTest,
+		OccurredAt: time.Now().UTC(),
+		Message:    "Test notification from tavily-proxy",
+	}
+	delivery, err := newDelivery(target.ID, ev)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.WithContext(ctx).Create(&delivery).Error; err != nil {
+		return nil, err
+	}
+	s.deliver(ctx, target, &delivery, time.Now())
+	return &delivery, nil
+}
+
+func (s *NotificationService) ListDeliveries(ctx context.Context, filter DeliveryFilter) ([]models.NotificationDelivery, error) {
+	limit := filter.Limit
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query := s.db.WithContext(ctx).Model(&models.NotificationDelivery{}).Order("id desc").Limit(limit)
+	if filter.TargetID != nil {
+		query = query.Where("target_id = ?", *filter.TargetID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	var items []models.NotificationDelivery
+	if err := query.Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (s *NotificationService) Thresholds(ctx context.Context) (NotificationThresholds, error) {
+	percent, err := s.settings.GetInt(ctx, SettingNotifyPoolQuotaPercent, 10)
+	if err != nil {
+		return NotificationThresholds{}, err
+	}
+	minKeys, err := s.settings.GetInt(ctx, SettingNotifyMinActiveKeys, 1)
+	if err != nil {
+		return NotificationThresholds{}, err
+	}
+	return NotificationThresholds{PoolQuotaPercent: percent, MinActiveKeys: minKeys}, nil
+}
+
+func (s *NotificationService) SetThresholds(ctx context.Context, t NotificationThresholds) error {
+	if err := s.settings.SetInt(ctx, SettingNotifyPoolQuotaPercent, t.PoolQuotaPercent); err != nil {
+		return err
+	}
+	return s.settings.SetInt(ctx, SettingNotifyMinActiveKeys, t.MinActiveKeys)
+}
+
+// CheckPool compares the key pool against the thresholds and publishes an
+// alert the first time either one is crossed.
+func (s *NotificationService) CheckPool(ctx context.Context) {
+	thresholds, err := s.Thresholds(ctx)
+	if err != nil {
+		s.logger.Error("notifications: failed to read thresholds", "err", err)
+		return
+	}
+	keys, err := s.keys.List(ctx)
+	if err != nil {
+		s.logger.Error("notifications: failed to list keys", "err", err)
+		return
+	}
+
+	var total, remaining int64
+	active := 0
+	for _, key := range keys {
+		if !key.IsActive || key.IsInvalid {
+			continue
+		}
+		total += int64(key.TotalQuota)
+		if key.UsedQuota < key.TotalQuota {
+			remaining += int64(key.TotalQuota - key.UsedQuota)
+			active++
+		}
+	}
+	percent := 0.0
+	if total > 0 {
+		percent = float64(remaining) * 100 / float64(total)
+	}
+
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+
+	quotaLow := thresholds.PoolQuotaPercent > 0 && percent < float64(thresholds.PoolQuotaPercent)
+	if quotaLow && !s.quotaAlerted {
+		s.events.Publish(EventPoolQuotaLow, fmt.Sprintf("Pool has %.1f%% of its quota left (%d of %d credits)", percent, remaining, total), map[string]any{
+			"remaining_quota":   remaining,
+			"total_quota":       total,
+			"remaining_percent": percent,
+			"threshold_percent": thresholds.PoolQuotaPercent,
+		})
+	}
+	s.quotaAlerted = quotaLow
+
+	keysLow := thresholds.MinActiveKeys > 0 && active < thresholds.MinActiveKeys
+	if keysLow && !s.activeAlerted {
+		s.events.Publish(EventPoolKeysLow, fmt.Sprintf("Only %d usable keys left (threshold %d)", active, thresholds.MinActiveKeys), map[string]any{
+			"active_keys": active,
+			"threshold":   thresholds.MinActiveKeys,
+		})
+	}
+	s.activeAlerted = keysLow
+}
+
+// record stores one pending delivery per active target subscribed to ev.
+func (s *NotificationService) record(ctx context.Context, ev Event) error {
+	targets, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	var deliveries []models.NotificationDelivery
+	for _, target := range targets {
+		if !target.IsActive || !subscribes(target, ev.Type) {
+			continue
+		}
+		delivery, err := newDelivery(target.ID, ev)
+		if err != nil {
+			return err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	return s.db.WithContext(ctx).Create(&deliveries).Error
+}
+
+// DispatchDue attempts the pending deliveries whose next attempt is due and
+// returns once they are done. Targets are served in parallel by up to
+// notificationWorkers deliveries, one at a time per target and in event
+// order; a target whose delivery fails gets no more attempts this round.
+// Targets still busy from an earlier round are skipped.
+func (s *NotificationService) DispatchDue(ctx context.Context, now time.Time) {
+	query := s.db.WithContext(ctx).
+		Where("status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)", DeliveryPending, now)
+	if busy := s.busyTargets(); len(busy) > 0 {
+		query = query.Where("target_id NOT IN ?", busy)
+	}
+	var due []models.NotificationDelivery
+	if err := query.
+		Order("id asc").
+		Limit(100).
+		Find(&due).Error; err != nil {
+		s.logger.Error("notifications: failed to load deliveries", "err", err)
+		return
+	}
+	if len(due) == 0 {
+		return
+	}
+
+	targets, err := s.List(ctx)
+	if err != nil {
+		s.logger.Error("notifications: failed to load targets", "err", err)
+		return
+	}
+	byID := make(map[uint]models.NotificationTarget, len(targets))
+	for _, t := range targets {
+		byID[t.ID] = t
+	}
+	var order []uint
+	byTarget := make(map[uint][]*models.NotificationDelivery)
+	for i := range due {
+		id := due[i].TargetID
+		if _, ok := byID[id]; !ok {
+			continue
+		}
+		if byTarget[id] == nil {
+			order = append(order, id)
+		}
+		byTarget[id] = append(byTarget[id], &due[i])
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range order {
+		if !s.claimTarget(id) {
+			continue
+		}
+		wg.Add(1)
+		go func(target models.NotificationTarget, deliveries []*models.NotificationDelivery) {
+			defer wg.Done()
+			defer s.releaseTarget(target.ID)
+			s.workers <- struct{}{}
+			defer func() { <-s.workers }()
+			for _, d := range deliveries {
+				if ctx.Err() != nil || !s.deliver(ctx, target, d, now) {
+					return
+				}
+			}
+		}(byID[id], byTarget[id])
+	}
+	wg.Wait()
+}
+
+func (s *NotificationService) busyTargets() []uint {
+	s.busyMu.Lock()
+	defer s.busyMu.Unlock()
+	ids := make([]uint, 0, len(s.busy))
+	for id := range s.busy {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (s *NotificationService) claimTarget(id uint) bool {
+	s.busyMu.Lock()
+	defer s.busyMu.Unlock()
+	if s.busy[id] {
+		return false
+	}
+	s.busy[id] = true
+	return true
+}
+
+func (s *NotificationService) releaseTarget(id uint) {
+	s.busyMu.Lock()
+	defer s.busyMu.Unlock()
+	delete(s.busy, id)
+}
+
+// deliver makes one attempt at d and reports whether it was delivered.
+func (s *NotificationService) deliver(ctx context.Context, target models.NotificationTarget, d *models.NotificationDelivery, now time.Time) bool {
+	d.Attempts++
+	status, err := s.post(ctx, target, d, now)
+	d.StatusCode = status
+	if err == nil {
+		delivered := time.Now()
+		d.Status = DeliveryDelivered
+		d.Error = ""
+		d.NextAttemptAt = nil
+		d.DeliveredAt = &delivered
+	} else {
+		d.Error = err.Error()
+		if d.Attempts >= maxDeliveryAttempts {
+			d.Status = DeliveryFailed
+			d.NextAttemptAt = nil
+			s.logger.Warn("notifications: delivery failed", "target", target.Name, "event", d.EventType, "attempts", d.Attempts, "err", err)
+		} else {
+			next := now.Add(s.deliveryBackoff(d.Attempts))
+			d.NextAttemptAt = &next
+		}
+	}
+	if err := s.db.WithContext(ctx).Save(d).Error; err != nil {
+		s.logger.Error("notifications: failed to save delivery", "id", d.ID, "err", err)
+	}
+	return d.Status == DeliveryDelivered
+}
+
+func (s *NotificationService) post(ctx context.Context, target models.NotificationTarget, d *models.NotificationDelivery, now time.Time) (int, error) {
+	body, err := renderNotification(target.Format, d.Payload)
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tavily-proxy-notifications")
+	req.Header.Set(HeaderNotificationEvent, d.EventType)
+	req.Header.Set(HeaderNotificationDelivery, d.EventID)
+	req.Header.Set(HeaderNotificationTimestamp, timestamp)
+	if target.Secret != "" {
+		req.Header.Set(HeaderNotificationSignature, "sha256="+SignNotification(target.Secret, timestamp, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, deliveryResponseLimit))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, strings.TrimSpace(string(snippet)))
+	}
+	return resp.StatusCode, nil
+}
+
+func (s *NotificationService) deliveryBackoff(attempts int) time.Duration {
+	d := s.retryBase
+	for i := 1; i < attempts && d < maxDeliveryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxDeliveryBackoff {
+		d = maxDeliveryBackoff
+	}
+	return d
+}
+
+func (s *NotificationService) pruneDeliveries(ctx context.Context, before time.Time) error {
+	return s.db.WithContext(ctx).
+		Where("status <> ? AND created_at < ?", DeliveryPending, before).
+		Delete(&models.NotificationDelivery{}).Error
+}
+
+// SignNotification returns the hex HMAC-SHA256 receivers should compare with
+// the X-Tavily-Proxy-Signature header (after its "sha256=" prefix).
+func SignNotification(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// renderNotification shapes the stored event for the target: the event itself
+// for plain webhooks, or a text message for chat webhooks.
+func renderNotification(format, payload string) ([]byte, error) {
+	switch format {
+	case NotificationFormatJSON, "":
+		return []byte(payload), nil
+	case NotificationFormatSlack, NotificationFormatDiscord:
+		var ev Event
+		if err := json.Unmarshal([]byte(payload), &ev); err != nil {
+			return nil, err
+		}
+		text := fmt.Sprintf("[tavily-proxy] %s: %s", ev.Type, ev.Message)
+		if format == NotificationFormatSlack {
+			return json.Marshal(map[string]string{"text": text})
+		}
+		return json.Marshal(map[string]string{"content": text})
+	default:
+		return nil, ErrInvalidNotificationFormat
+	}
+}
+
+func newDelivery(targetID uint, ev Event) (models.NotificationDelivery, error) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return models.NotificationDelivery{}, err
+	}
+	return models.NotificationDelivery{
+		TargetID:  targetID,
+		EventID:   ev.ID,
+		EventType: ev.Type,
+		Payload:   string(payload),
+		Status:    DeliveryPending,
+	}, nil
+}
+
+func subscribes(target models.NotificationTarget, eventType string) bool {
+	if target.Events == "" {
+		return true
+	}
+	for _, t := range strings.Split(target.Events, ",") {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+func joinEventTypes(types []string) (string, error) {
+	seen := make(map[string]struct{}, len(types))
+	out := make([]string, 0, len(types))
+	for _, t := range types {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !IsEventType(t) {
+			return "", fmt.Errorf("%w: %s", ErrUnknownEventType, t)
+		}
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		out = append(out, t)
+	}
+	return strings.Join(out, ","), nil
+}
+
+func validateNotificationTarget(t models.NotificationTarget) error {
+	u, err := url.Parse(t.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidNotificationURL
+	}
+	switch t.Format {
+	case NotificationFormatJSON, NotificationFormatSlack, NotificationFormatDiscord:
+		return nil
+	default:
+		return ErrInvalidNotificationFormat
+	}
+}
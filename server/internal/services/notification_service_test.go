@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+
+	"github.com/google/uuid"
+)
+
+func TestNotificationService_SignsRetriesAndLogsDeliveries(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	var lastEvent Event
+	var signatureOK atomic.Bool
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		want := "sha256=" + SignNotification("s3cret", r.Header.Get(HeaderNotificationTimestamp), body)
+		signatureOK.Store(r.Header.Get(HeaderNotificationSignature) == want)
+		_ = json.Unmarshal(body, &lastEvent)
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(receiver.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	bus := NewEventBus()
+	settings := NewSettingsService(database)
+	keys := NewKeyService(database, logger).WithSettings(settings).WithEvents(bus)
+	notifications := NewNotificationService(database, settings, keys, bus, logger)
+	notifications.retryBase = time.Second
+
+	key, err := keys.Create(ctx, "tvly-test", "primary", 100)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if _, _, err := notifications.Create(ctx, NotificationTargetInput{Name: "ops", URL: receiver.URL, Secret: "s3cret", Events: []string{EventKeyInvalid}}); err != nil {
+		t.Fatalf("create target: %v", err)
+	}
+	if _, _, err := notifications.Create(ctx, NotificationTargetInput{Name: "other", URL: receiver.URL, Events: []string{EventMasterKeyReset}}); err != nil {
+		t.Fatalf("create target: %v", err)
+	}
+
+	if err := keys.MarkInvalidWithReason(ctx, key.ID, InvalidReasonProxyUnauthorized); err != nil {
+		t.Fatalf("mark invalid: %v", err)
+	}
+	// Marking an already invalid key again is not a new event.
+	if err := keys.MarkInvalidWithReason(ctx, key.ID, InvalidReasonProxyUnauthorized); err != nil {
+		t.Fatalf("mark invalid again: %v", err)
+	}
+	if got := len(notifications.queue); got != 1 {
+		t.Fatalf("unexpected queued events: got %d want %d", got, 1)
+	}
+	if err := notifications.record(ctx, <-notifications.queue); err != nil {
+		t.Fatalf("record event: %v", err)
+	}
+
+	now := time.Now()
+	notifications.DispatchDue(ctx, now)
+	pending, err := notifications.ListDeliveries(ctx, DeliveryFilter{Status: DeliveryPending})
+	if err != nil {
+		t.Fatalf("list deliveries: %v", err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].StatusCode != http.StatusInternalServerError {
+		t.Fatalf("unexpected deliveries after failed attempt: %+v", pending)
+	}
+
+	// Not due yet: the retry waits for its backoff.
+	notifications.DispatchDue(ctx, now)
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("unexpected calls before backoff: got %d want %d", got, 1)
+	}
+
+	notifications.DispatchDue(ctx, now.Add(2*time.Second))
+	delivered, err := notifications.ListDeliveries(ctx, DeliveryFilter{Status: DeliveryDelivered})
+	if err != nil {
+		t.Fatalf("list deliveries: %v", err)
+	}
+	if len(delivered) != 1 || delivered[0].Attempts != 2 || delivered[0].DeliveredAt == nil {
+		t.Fatalf("unexpected deliveries after retry: %+v", delivered)
+	}
+	if !signatureOK.Load() {
+		t.Fatalf("signature header did not match")
+	}
+	if lastEvent.Type != EventKeyInvalid || lastEvent.Data["alias"] != "primary" {
+		t.Fatalf("unexpected event payload: %+v", lastEvent)
+	}
+
+	// With the only key gone the pool alerts fire once, not on every check.
+	notifications.CheckPool(ctx)
+	notifications.CheckPool(ctx)
+	var types []string
+	for len(notifications.queue) > 0 {
+		types = append(types, (<-notifications.queue).Type)
+	}
+	if len(types) != 2 || types[0] != EventPoolQuotaLow || types[1] != EventPoolKeysLow {
+		t.Fatalf("unexpected pool events: %v", types)
+	}
+}
+
+func TestKeyService_IncrementUsedPublishesExhaustedOnce(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	bus := NewEventBus()
+	var exhausted atomic.Int32
+	bus.Subscribe(func(ev Event) {
+		if ev.Type == EventKeyExhausted {
+			exhausted.Add(1)
+		}
+	})
+	keys := NewKeyService(database, logger).WithEvents(bus)
+
+	key, err := keys.Create(ctx, "tvly-test", "primary", 10)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	for _, credits := range []int{4, 4, 4, 1} {
+		if err := keys.IncrementUsed(ctx, key.ID, credits); err != nil {
+			t.Fatalf("increment used: %v", err)
+		}
+	}
+
+	got, err := keys.Get(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if got.UsedQuota != 10 || got.LastUsedAt == nil {
+		t.Fatalf("unexpected key after charges: used=%d last_used=%v", got.UsedQuota, got.LastUsedAt)
+	}
+	if n := exhausted.Load(); n != 1 {
+		t.Fatalf("exhausted events: got %d want %d", n, 1)
+	}
+}
+
+func TestNotificationService_SlowTargetDoesNotBlockOthers(t *testing.T) {
+	t.Parallel()
+
+	var slowCalls, fastCalls atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slowCalls.Add(1)
+		time.Sleep(300 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(slow.Close)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastCalls.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(fast.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	bus := NewEventBus()
+	settings := NewSettingsService(database)
+	keys := NewKeyService(database, logger)
+	notifications := NewNotificationService(database, settings, keys, bus, logger)
+
+	for _, target := range []NotificationTargetInput{{Name: "slow", URL: slow.URL}, {Name: "fast", URL: fast.URL}} {
+		if _, _, err := notifications.Create(ctx, target); err != nil {
+			t.Fatalf("create target: %v", err)
+		}
+	}
+	const events = 3
+	for i := 0; i < events; i++ {
+		if err := notifications.record(ctx, Event{ID: uuid.NewString(), Type: EventKeyInvalid, OccurredAt: time.Now()}); err != nil {
+			t.Fatalf("record event: %v", err)
+		}
+	}
+
+	now := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		notifications.DispatchDue(ctx, now)
+	}()
+
+	deadline := time.Now().Add(250 * time.Millisecond)
+	for fastCalls.Load() < events && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := fastCalls.Load(); got != events {
+		t.Fatalf("fast target deliveries while slow one is busy: got %d want %d", got, events)
+	}
+
+	// A second round while the slow target is busy must not deliver its rows
+	// again.
+	notifications.DispatchDue(ctx, now)
+	<-done
+	if got := slowCalls.Load(); got != events {
+		t.Fatalf("slow target deliveries: got %d want %d", got, events)
+	}
+}
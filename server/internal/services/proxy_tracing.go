@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"tavily-proxy/server/internal/models"
+	"tavily-proxy/server/internal/tracing"
+)
+
+var tracer = tracing.Tracer("services")
+
+// candidates wraps KeyService.Candidates in a span, since key selection runs
+// SQLite queries on every request.
+func (p *TavilyProxy) candidates(ctx context.Context) ([]models.APIKey, error) {
+	ctx, span := tracer.Start(ctx, "KeyService.Candidates")
+	defer span.End()
+
+	keys, err := p.keys.Candidates(ctx)
+	span.SetAttributes(attribute.Int("keys.candidates", len(keys)))
+	setSpanOutcome(span, 0, err)
+	return keys, err
+}
+
+// startAttemptSpan opens the span for one upstream call.
+func startAttemptSpan(ctx context.Context, key models.APIKey, number int, hedged bool) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "tavily.attempt", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.Int64("tavily.key.id", int64(key.ID)),
+		attribute.String("tavily.key.alias", key.Alias),
+		attribute.Int("proxy.attempt", number),
+		attribute.Bool("proxy.hedged", hedged),
+	))
+}
+
+// setSpanOutcome records the result on span; the caller still ends it. A
+// status of 0 means no HTTP answer was received.
+func setSpanOutcome(span trace.Span, status int, err error) {
+	if status > 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+	}
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case status >= http.StatusInternalServerError:
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestTavilyProxy_TracesAttemptsAndLogsTraceID(t *testing.T) {
+	t.Parallel()
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	parents := make(chan string, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parents <- r.Header.Get("Traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") == "Bearer tvly-revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"detail":"invalid key"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	if _, err := keys.Create(ctx, "tvly-revoked", "revoked", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if _, err := keys.Create(ctx, "tvly-good", "good", 100); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger)
+
+	ctx, root := otel.Tracer("test").Start(ctx, "client")
+	// The client's own header must not reach Tavily.
+	clientHeaders := http.Header{"Traceparent": []string{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}}
+	if _, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Headers: clientHeaders, Body: []byte(`{"query":"q"}`)}); err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	root.End()
+	traceID := root.SpanContext().TraceID()
+
+	close(parents)
+	sent := make(map[string]bool)
+	for parent := range parents {
+		sent[parent] = true
+	}
+
+	counts := make(map[string]int)
+	statusByAlias := make(map[string]int64)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() != traceID {
+			continue
+		}
+		counts[span.Name()]++
+		if span.Name() != "tavily.attempt" {
+			continue
+		}
+		sc := span.SpanContext()
+		if want := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"; !sent[want] {
+			t.Fatalf("upstream traceparent should name the attempt span %s, got %v", want, sent)
+		}
+		var alias string
+		var status int64
+		for _, kv := range span.Attributes() {
+			switch kv.Key {
+			case attribute.Key("tavily.key.alias"):
+				alias = kv.Value.AsString()
+			case attribute.Key("http.response.status_code"):
+				status = kv.Value.AsInt64()
+			}
+		}
+		statusByAlias[alias] = status
+	}
+	for name, want := range map[string]int{"TavilyProxy.Do": 1, "KeyService.Candidates": 1, "tavily.attempt": 2, "RequestLog.write": 1} {
+		if counts[name] != want {
+			t.Fatalf("unexpected %s spans: got %d want %d (all: %v)", name, counts[name], want, counts)
+		}
+	}
+	if statusByAlias["revoked"] != http.StatusUnauthorized || statusByAlias["good"] != http.StatusOK {
+		t.Fatalf("unexpected attempt statuses: %v", statusByAlias)
+	}
+
+	var entry models.RequestLog
+	if err := database.WithContext(ctx).First(&entry).Error; err != nil {
+		t.Fatalf("load log: %v", err)
+	}
+	if entry.TraceID != traceID.String() {
+		t.Fatalf("unexpected log trace id: got %q want %q", entry.TraceID, traceID.String())
+	}
+}
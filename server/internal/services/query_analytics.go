@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	defaultQueryAnalyticsLimit = 20
+	maxQueryAnalyticsLimit     = 200
+)
+
+type QueryCount struct {
+	Query string `json:"query"`
+	Count int64  `json:"count"`
+}
+
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// QueryAnalytics summarizes /search traffic over [From, To).
+type QueryAnalytics struct {
+	From            time.Time    `json:"from"`
+	To              time.Time    `json:"to"`
+	TotalSearches   int64        `json:"total_searches"`
+	DistinctQueries int64        `json:"distinct_queries"`
+	TopQueries      []QueryCount `json:"top_queries"`
+	Topics          []ValueCount `json:"topics"`
+	SearchDepths    []ValueCount `json:"search_depths"`
+	MaxResults      []ValueCount `json:"max_results"`
+	IncludeDomains  []ValueCount `json:"include_domains"`
+}
+
+// QueryAnalytics reports the most frequent search queries and how search
+// parameters are distributed between from and to. Queries are compared
+// case-insensitively.
+func (s *StatsService) QueryAnalytics(ctx context.Context, from, to time.Time, limit int) (QueryAnalytics, error) {
+	if limit <= 0 || limit > maxQueryAnalyticsLimit {
+		limit = defaultQueryAnalyticsLimit
+	}
+	out := QueryAnalytics{From: from, To: to}
+
+	searches := func() *gorm.DB {
+		return s.db.WithContext(ctx).Model(&models.RequestLog{}).
+			Where("endpoint = ? AND created_at >= ? AND created_at < ?", "/search", storageTime(from), storageTime(to))
+	}
+
+	if err := searches().Count(&out.TotalSearches).Error; err != nil {
+		return QueryAnalytics{}, err
+	}
+	if err := searches().Where("query <> ''").
+		Select("COUNT(DISTINCT LOWER(query))").
+		Scan(&out.DistinctQueries).Error; err != nil {
+		return QueryAnalytics{}, err
+	}
+
+	out.TopQueries = []QueryCount{}
+	if err := searches().Where("query <> ''").
+		Select("MIN(query) AS query, COUNT(*) AS count").
+		Group("LOWER(query)").
+		Order("count DESC, query ASC").
+		Limit(limit).
+		Scan(&out.TopQueries).Error; err != nil {
+		return QueryAnalytics{}, err
+	}
+
+	for _, dist := range []struct {
+		column string
+		dst    *[]ValueCount
+	}{
+		{"topic", &out.Topics},
+		{"search_depth", &out.SearchDepths},
+		{"CAST(max_results AS TEXT)", &out.MaxResults},
+	} {
+		*dist.dst = []ValueCount{}
+		if err := searches().
+			Select(dist.column + " AS value, COUNT(*) AS count").
+			Group("value").
+			Order("count DESC, value ASC").
+			Limit(limit).
+			Scan(dist.dst).Error; err != nil {
+			return QueryAnalytics{}, err
+		}
+	}
+
+	// include_domains is a comma-joined list, so it is counted in Go.
+	var domainLists []string
+	if err := searches().Where("include_domains <> ''").
+		Pluck("include_domains", &domainLists).Error; err != nil {
+		return QueryAnalytics{}, err
+	}
+	out.IncludeDomains = topValues(domainLists, limit)
+
+	return out, nil
+}
+
+func topValues(lists []string, limit int) []ValueCount {
+	counts := make(map[string]int64)
+	for _, list := range lists {
+		for _, v := range strings.Split(list, ",") {
+			if v != "" {
+				counts[v]++
+			}
+		}
+	}
+	out := make([]ValueCount, 0, len(counts))
+	for v, n := range counts {
+		out = append(out, ValueCount{Value: v, Count: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Value < out[j].Value
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestStatsService_QueryAnalytics(t *testing.T) {
+	t.Parallel()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+	stats := NewStatsService(database)
+
+	ctx := context.Background()
+	if _, err := keys.Create(ctx, "tvly-test", "test", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, stats, logger)
+	for _, body := range []string{
+		`{"query":"Go generics","topic":"general","max_results":5,"include_domains":["go.dev","github.com"]}`,
+		`{"query":"go generics","topic":"general","search_depth":"advanced","max_results":5,"include_domains":["go.dev"]}`,
+		`{"query":"election results","topic":"news","max_results":10}`,
+		`not json`,
+	} {
+		if _, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(body)}); err != nil {
+			t.Fatalf("proxy request: %v", err)
+		}
+	}
+
+	var entry models.RequestLog
+	if err := database.WithContext(ctx).Where("topic = ?", "news").First(&entry).Error; err != nil {
+		t.Fatalf("load log: %v", err)
+	}
+	if entry.Query != "election results" || entry.MaxResults != 10 || entry.SearchDepth != "" {
+		t.Fatalf("unexpected parsed columns: %+v", entry)
+	}
+
+	out, err := stats.QueryAnalytics(ctx, time.Now().Add(-time.Hour), time.Now().Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("query analytics: %v", err)
+	}
+	if out.TotalSearches != 4 || out.DistinctQueries != 2 {
+		t.Fatalf("unexpected totals: total=%d distinct=%d", out.TotalSearches, out.DistinctQueries)
+	}
+	if len(out.TopQueries) != 2 || out.TopQueries[0].Count != 2 || out.TopQueries[1].Query != "election results" {
+		t.Fatalf("unexpected top queries: %+v", out.TopQueries)
+	}
+	if len(out.Topics) == 0 || out.Topics[0] != (ValueCount{Value: "general", Count: 2}) {
+		t.Fatalf("unexpected topics: %+v", out.Topics)
+	}
+	if len(out.IncludeDomains) != 2 || out.IncludeDomains[0] != (ValueCount{Value: "go.dev", Count: 2}) {
+		t.Fatalf("unexpected include_domains: %+v", out.IncludeDomains)
+	}
+	if len(out.MaxResults) == 0 || out.MaxResults[0] != (ValueCount{Value: "5", Count: 2}) {
+		t.Fatalf("unexpected max_results: %+v", out.MaxResults)
+	}
+}
+
+func TestApplySearchParams_TruncatesQueryOnRuneBoundary(t *testing.T) {
+	t.Parallel()
+
+	// Byte 512 falls inside the 256th "é", so a plain byte cut would split it.
+	query := "a" + strings.Repeat("é", 300)
+	var entry models.RequestLog
+	applySearchParams(&entry, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"` + query + `"}`)})
+	if !utf8.ValidString(entry.Query) {
+		t.Fatalf("truncated query is not valid UTF-8: %q", entry.Query[len(entry.Query)-4:])
+	}
+	if len(entry.Query) != 511 {
+		t.Fatalf("query length: got %d want %d", len(entry.Query), 511)
+	}
+}
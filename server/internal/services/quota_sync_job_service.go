@@ -8,6 +8,7 @@ import (
 
 	"github.com/google/uuid"
 
+	"tavily-proxy/server/internal/metrics"
 	"tavily-proxy/server/internal/models"
 )
 
@@ -29,9 +30,10 @@ type QuotaSyncJobStatus struct {
 }
 
 type QuotaSyncJobService struct {
-	keys   *KeyService
-	sync   *QuotaSyncService
-	logger *slog.Logger
+	keys    *KeyService
+	sync    *QuotaSyncService
+	metrics *metrics.Metrics
+	logger  *slog.Logger
 
 	mu  sync.RWMutex
 	job *QuotaSyncJobStatus
@@ -41,6 +43,11 @@ func NewQuotaSyncJobService(keys *KeyService, sync *QuotaSyncService, logger *sl
 	return &QuotaSyncJobService{keys: keys, sync: sync, logger: logger}
 }
 
+func (s *QuotaSyncJobService) WithMetrics(m *metrics.Metrics) *QuotaSyncJobService {
+	s.metrics = m
+	return s
+}
+
 func (s *QuotaSyncJobService) Get() QuotaSyncJobStatus {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -105,6 +112,9 @@ func (s *QuotaSyncJobService) Start(interval time.Duration) (QuotaSyncJobStatus,
 
 func (s *QuotaSyncJobService) runJob(jobID string, keyItems []models.APIKey, interval time.Duration) {
 	ctx := context.Background()
+	started := time.Now()
+	failed := 0
+	defer func() { s.metrics.ObserveQuotaSync("manual", time.Since(started), failed, nil) }()
 
 	for i, k := range keyItems {
 		if interval > 0 && i > 0 {
@@ -125,6 +135,7 @@ func (s *QuotaSyncJobService) runJob(jobID string, keyItems []models.APIKey, int
 			s.job.Succeeded++
 		} else {
 			s.job.Failed++
+			failed++
 		}
 		s.mu.Unlock()
 	}
@@ -190,6 +190,65 @@ func (s *QuotaSyncService) SyncAllWithConcurrencyAndInterval(ctx context.Context
 	}, nil
 }
 
+// Revalidate probes an invalid key with GetUsage and restores it when Tavily
+// answers 200, syncing its usage at the same time. A scheduled probe that
+// fails pushes the next one back; a manual probe leaves the schedule alone.
+func (s *QuotaSyncService) Revalidate(ctx context.Context, id uint, scheduled bool) (bool, error) {
+	key, err := s.keys.Get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if !key.IsInvalid {
+		return false, nil
+	}
+
+	usage, limit, err := s.proxy.GetUsage(ctx, key.Key)
+	if err != nil {
+		if scheduled {
+			_ = s.keys.ScheduleRevalidation(ctx, key.ID, key.RevalidateAttempts+1)
+		}
+		return false, err
+	}
+
+	if err := s.keys.Restore(ctx, key.ID); err != nil {
+		return false, err
+	}
+	totalQuota := key.TotalQuota
+	if limit != nil && *limit > 0 {
+		totalQuota = *limit
+	}
+	if totalQuota > 0 && usage > totalQuota {
+		usage = totalQuota
+	}
+	_ = s.keys.SetUsage(ctx, key.ID, usage, &totalQuota)
+	_ = s.keys.RecordUsageSnapshot(ctx, key.ID, usage, totalQuota)
+	return true, nil
+}
+
+// RevalidateDue probes every invalid key whose backoff has elapsed and returns
+// how many were checked and restored.
+func (s *QuotaSyncService) RevalidateDue(ctx context.Context) (int, int, error) {
+	due, err := s.keys.RevalidationDue(ctx, time.Now())
+	if err != nil {
+		return 0, 0, err
+	}
+	restored := 0
+	for _, key := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		ok, err := s.Revalidate(ctx, key.ID, true)
+		if err != nil {
+			s.logger.Debug("key revalidation failed", "key_id", key.ID, "err", err)
+			continue
+		}
+		if ok {
+			restored++
+		}
+	}
+	return len(due), restored, nil
+}
+
 func (s *QuotaSyncService) syncKey(ctx context.Context, key models.APIKey) QuotaSyncItemResult {
 	item := QuotaSyncItemResult{ID: key.ID, Alias: key.Alias}
 	usage, limit, err := s.proxy.GetUsage(ctx, key.Key)
@@ -199,7 +258,7 @@ func (s *QuotaSyncService) syncKey(ctx context.Context, key models.APIKey) Quota
 		if ue := (*UpstreamStatusError)(nil); errors.As(err, &ue) {
 			switch ue.StatusCode {
 			case http.StatusUnauthorized:
-				_ = s.keys.MarkInvalid(ctx, key.ID)
+				_ = s.keys.MarkInvalidWithReason(ctx, key.ID, InvalidReasonUsageUnauthorized)
 			case 432, 433:
 				_ = s.keys.MarkExhausted(ctx, key.ID)
 			}
@@ -216,6 +275,7 @@ func (s *QuotaSyncService) syncKey(ctx context.Context, key models.APIKey) Quota
 	}
 
 	_ = s.keys.SetUsage(ctx, key.ID, usage, &totalQuota)
+	_ = s.keys.RecordUsageSnapshot(ctx, key.ID, usage, totalQuota)
 
 	item.Status = "ok"
 	item.UsedQuota = usage
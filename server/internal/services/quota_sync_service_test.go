@@ -300,3 +300,96 @@ func TestQuotaSyncService_SyncAllWithConcurrencyAndInterval_RespectsInterval(t *
 		t.Fatalf("sync finished too fast: elapsed=%s want >= %s", elapsed, minElapsed)
 	}
 }
+
+func TestQuotaSyncService_RevalidateDue_RestoresAcceptedKeys(t *testing.T) {
+	t.Parallel()
+
+	var accepted atomic.Bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/usage" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if !accepted.Load() {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"detail":"unauthorized"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"key":{"usage":12,"limit":1000}}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, nil, nil, logger)
+	sync := NewQuotaSyncService(keys, proxy, logger)
+
+	ctx := context.Background()
+	created, err := keys.Create(ctx, "tvly-test", "test", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if err := keys.MarkInvalidWithReason(ctx, created.ID, InvalidReasonProxyUnauthorized); err != nil {
+		t.Fatalf("mark invalid: %v", err)
+	}
+
+	// The first probe is scheduled in the future.
+	if checked, _, err := sync.RevalidateDue(ctx); err != nil || checked != 0 {
+		t.Fatalf("unexpected early probe: checked=%d err=%v", checked, err)
+	}
+
+	if err := keys.ScheduleRevalidation(ctx, created.ID, 0); err != nil {
+		t.Fatalf("schedule: %v", err)
+	}
+	if err := database.Exec("UPDATE api_keys SET next_revalidate_at = ? WHERE id = ?", time.Now().Add(-time.Minute), created.ID).Error; err != nil {
+		t.Fatalf("force due: %v", err)
+	}
+	if checked, restored, err := sync.RevalidateDue(ctx); err != nil || checked != 1 || restored != 0 {
+		t.Fatalf("unexpected rejected probe: checked=%d restored=%d err=%v", checked, restored, err)
+	}
+	got, err := keys.Get(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if !got.IsInvalid || got.RevalidateAttempts != 1 || got.InvalidReason != InvalidReasonProxyUnauthorized {
+		t.Fatalf("rejected probe should back off: %+v", got)
+	}
+
+	// Another rejection, as from a quota sync, keeps the original schedule.
+	if err := keys.MarkInvalidWithReason(ctx, created.ID, InvalidReasonUsageUnauthorized); err != nil {
+		t.Fatalf("mark invalid again: %v", err)
+	}
+	again, err := keys.Get(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if again.RevalidateAttempts != 1 || again.InvalidReason != InvalidReasonProxyUnauthorized || !again.InvalidatedAt.Equal(*got.InvalidatedAt) {
+		t.Fatalf("repeated rejection should leave the key alone: %+v", again)
+	}
+
+	accepted.Store(true)
+	restored, err := sync.Revalidate(ctx, created.ID, false)
+	if err != nil || !restored {
+		t.Fatalf("manual revalidate: restored=%v err=%v", restored, err)
+	}
+	got, err = keys.Get(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if got.IsInvalid || !got.IsActive || got.InvalidatedAt != nil || got.UsedQuota != 12 {
+		t.Fatalf("key not restored: %+v", got)
+	}
+}
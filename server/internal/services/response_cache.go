@@ -0,0 +1,399 @@
+package services
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	CacheBackendMemory = "memory"
+	CacheBackendSQLite = "sqlite"
+
+	defaultCacheMaxEntries = 1000
+	maxCacheMaxEntries     = 100000
+)
+
+var defaultCacheTTLSeconds = map[string]int{
+	"/search":  300,
+	"/extract": 3600,
+}
+
+// CachedResponse is an upstream answer that can be replayed for an identical
+// request without spending a Tavily credit.
+type CachedResponse struct {
+	StatusCode      int
+	Headers         http.Header
+	Body            []byte
+	TavilyRequestID string
+	ExpiresAt       time.Time
+}
+
+// ResponseCacheBackend stores cached responses by key.
+type ResponseCacheBackend interface {
+	Get(ctx context.Context, key string) (*CachedResponse, bool, error)
+	Set(ctx context.Context, key, endpoint string, resp CachedResponse) error
+	Purge(ctx context.Context) error
+}
+
+type ResponseCacheConfig struct {
+	Enabled    bool           `json:"enabled"`
+	Backend    string         `json:"backend"`
+	MaxEntries int            `json:"max_entries"`
+	TTLSeconds map[string]int `json:"ttl_seconds"`
+}
+
+// ResponseCache sits in front of TavilyProxy.Do and answers repeated
+// search/extract requests from a memory or SQLite backend.
+type ResponseCache struct {
+	settings *SettingsService
+	memory   *memoryResponseCache
+	sqlite   *sqliteResponseCache
+
+	// config holds the loaded settings so Lookup does not read them on every
+	// request. SetConfig is their only writer and clears it.
+	mu     sync.Mutex
+	config *ResponseCacheConfig
+}
+
+func NewResponseCache(db *gorm.DB, settings *SettingsService) *ResponseCache {
+	return &ResponseCache{
+		settings: settings,
+		memory:   newMemoryResponseCache(defaultCacheMaxEntries),
+		sqlite:   newSQLiteResponseCache(db, defaultCacheMaxEntries),
+	}
+}
+
+func (c *ResponseCache) Config(ctx context.Context) (ResponseCacheConfig, error) {
+	cfg, err := c.loadConfig(ctx)
+	if err != nil {
+		return ResponseCacheConfig{}, err
+	}
+	ttls := make(map[string]int, len(cfg.TTLSeconds))
+	for k, v := range cfg.TTLSeconds {
+		ttls[k] = v
+	}
+	cfg.TTLSeconds = ttls
+	return cfg, nil
+}
+
+// loadConfig returns the cached configuration, reading it from settings on
+// first use. Its TTLSeconds map is shared and must not be modified.
+func (c *ResponseCache) loadConfig(ctx context.Context) (ResponseCacheConfig, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config != nil {
+		return *c.config, nil
+	}
+	cfg, err := c.readConfig(ctx)
+	if err != nil {
+		return ResponseCacheConfig{}, err
+	}
+	c.config = &cfg
+	return cfg, nil
+}
+
+func (c *ResponseCache) readConfig(ctx context.Context) (ResponseCacheConfig, error) {
+	enabled, err := c.settings.GetBool(ctx, SettingCacheEnabled, false)
+	if err != nil {
+		return ResponseCacheConfig{}, err
+	}
+	backend, ok, err := c.settings.Get(ctx, SettingCacheBackend)
+	if err != nil {
+		return ResponseCacheConfig{}, err
+	}
+	if !ok || (backend != CacheBackendMemory && backend != CacheBackendSQLite) {
+		backend = CacheBackendMemory
+	}
+	maxEntries, err := c.settings.GetInt(ctx, SettingCacheMaxEntries, defaultCacheMaxEntries)
+	if err != nil {
+		return ResponseCacheConfig{}, err
+	}
+	if maxEntries < 1 || maxEntries > maxCacheMaxEntries {
+		maxEntries = defaultCacheMaxEntries
+	}
+
+	ttls := make(map[string]int, len(defaultCacheTTLSeconds))
+	for k, v := range defaultCacheTTLSeconds {
+		ttls[k] = v
+	}
+	if raw, ok, err := c.settings.Get(ctx, SettingCacheTTLs); err != nil {
+		return ResponseCacheConfig{}, err
+	} else if ok && strings.TrimSpace(raw) != "" {
+		var stored map[string]int
+		if err := json.Unmarshal([]byte(raw), &stored); err == nil {
+			ttls = stored
+		}
+	}
+
+	return ResponseCacheConfig{
+		Enabled:    enabled,
+		Backend:    backend,
+		MaxEntries: maxEntries,
+		TTLSeconds: ttls,
+	}, nil
+}
+
+func (c *ResponseCache) SetConfig(ctx context.Context, cfg ResponseCacheConfig) error {
+	defer func() {
+		c.mu.Lock()
+		c.config = nil
+		c.mu.Unlock()
+	}()
+	if err := c.settings.SetBool(ctx, SettingCacheEnabled, cfg.Enabled); err != nil {
+		return err
+	}
+	if err := c.settings.Set(ctx, SettingCacheBackend, cfg.Backend); err != nil {
+		return err
+	}
+	if err := c.settings.SetInt(ctx, SettingCacheMaxEntries, cfg.MaxEntries); err != nil {
+		return err
+	}
+	ttls := make(map[string]int, len(cfg.TTLSeconds))
+	for endpoint, seconds := range cfg.TTLSeconds {
+		if v := normalizeEndpoint(endpoint); v != "" && seconds > 0 {
+			ttls[v] = seconds
+		}
+	}
+	raw, err := json.Marshal(ttls)
+	if err != nil {
+		return err
+	}
+	return c.settings.Set(ctx, SettingCacheTTLs, string(raw))
+}
+
+// Lookup returns the cache key, TTL and backend for req, or an empty key when
+// req is not cacheable under the current configuration.
+func (c *ResponseCache) Lookup(ctx context.Context, req ProxyRequest) (string, time.Duration, ResponseCacheBackend) {
+	if c == nil || !strings.EqualFold(req.Method, http.MethodPost) {
+		return "", 0, nil
+	}
+	cfg, err := c.loadConfig(ctx)
+	if err != nil || !cfg.Enabled {
+		return "", 0, nil
+	}
+	seconds := cfg.TTLSeconds[normalizeEndpoint(req.Path)]
+	if seconds <= 0 {
+		return "", 0, nil
+	}
+
+	var backend ResponseCacheBackend = c.memory
+	if cfg.Backend == CacheBackendSQLite {
+		c.sqlite.SetCapacity(cfg.MaxEntries)
+		backend = c.sqlite
+	} else {
+		c.memory.SetCapacity(cfg.MaxEntries)
+	}
+	return requestFingerprint(req), time.Duration(seconds) * time.Second, backend
+}
+
+func (c *ResponseCache) Purge(ctx context.Context) error {
+	_ = c.memory.Purge(ctx)
+	return c.sqlite.Purge(ctx)
+}
+
+// requestFingerprint hashes the method, path, query and canonical JSON body, so
+// requests that differ only in key order or whitespace compare equal. Bodies
+// that are not a single JSON value are hashed verbatim.
+func requestFingerprint(req ProxyRequest) string {
+	h := sha256.New()
+	h.Write([]byte(strings.ToUpper(req.Method)))
+	h.Write([]byte{0})
+	h.Write([]byte(normalizeEndpoint(req.Path)))
+	h.Write([]byte{0})
+	h.Write([]byte(req.RawQuery))
+	h.Write([]byte{0})
+	h.Write(canonicalJSON(req.Body))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func canonicalJSON(body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return body
+	}
+	// Tavily may read more than the first value, so trailing input must still
+	// tell requests apart.
+	if _, err := dec.Token(); err != io.EOF {
+		return body
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// memoryResponseCache is a size-bounded LRU.
+type memoryResponseCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	items    map[string]*list.Element
+}
+
+type memoryCacheEntry struct {
+	key  string
+	resp CachedResponse
+}
+
+func newMemoryResponseCache(capacity int) *memoryResponseCache {
+	return &memoryResponseCache{
+		capacity: capacity,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryResponseCache) SetCapacity(capacity int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.capacity = capacity
+	m.evictLocked()
+}
+
+func (m *memoryResponseCache) Get(_ context.Context, key string) (*CachedResponse, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := el.Value.(*memoryCacheEntry)
+	if !time.Now().Before(entry.resp.ExpiresAt) {
+		m.order.Remove(el)
+		delete(m.items, key)
+		return nil, false, nil
+	}
+	m.order.MoveToFront(el)
+	resp := entry.resp
+	return &resp, true, nil
+}
+
+func (m *memoryResponseCache) Set(_ context.Context, key, _ string, resp CachedResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		el.Value.(*memoryCacheEntry).resp = resp
+		m.order.MoveToFront(el)
+		return nil
+	}
+	m.items[key] = m.order.PushFront(&memoryCacheEntry{key: key, resp: resp})
+	m.evictLocked()
+	return nil
+}
+
+func (m *memoryResponseCache) Purge(_ context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.order.Init()
+	m.items = make(map[string]*list.Element)
+	return nil
+}
+
+func (m *memoryResponseCache) evictLocked() {
+	for m.capacity > 0 && m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.items, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// sqliteResponseCache persists entries in the response_cache_entries table so
+// they survive restarts. Each Set drops expired rows and, past capacity, the
+// rows closest to expiry.
+type sqliteResponseCache struct {
+	db       *gorm.DB
+	capacity atomic.Int64
+}
+
+func newSQLiteResponseCache(db *gorm.DB, capacity int) *sqliteResponseCache {
+	s := &sqliteResponseCache{db: db}
+	s.SetCapacity(capacity)
+	return s
+}
+
+func (s *sqliteResponseCache) SetCapacity(capacity int) {
+	s.capacity.Store(int64(capacity))
+}
+
+func (s *sqliteResponseCache) Get(ctx context.Context, key string) (*CachedResponse, bool, error) {
+	var entry models.ResponseCacheEntry
+	err := s.db.WithContext(ctx).First(&entry, "key = ?", key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if !time.Now().Before(entry.ExpiresAt) {
+		_ = s.db.WithContext(ctx).Where("key = ? OR expires_at <= ?", key, time.Now()).Delete(&models.ResponseCacheEntry{}).Error
+		return nil, false, nil
+	}
+
+	headers := make(http.Header)
+	if entry.Headers != "" {
+		_ = json.Unmarshal([]byte(entry.Headers), &headers)
+	}
+	return &CachedResponse{
+		StatusCode:      entry.StatusCode,
+		Headers:         headers,
+		Body:            entry.Body,
+		TavilyRequestID: entry.TavilyRequestID,
+		ExpiresAt:       entry.ExpiresAt,
+	}, true, nil
+}
+
+func (s *sqliteResponseCache) Set(ctx context.Context, key, endpoint string, resp CachedResponse) error {
+	headers, err := json.Marshal(resp.Headers)
+	if err != nil {
+		return err
+	}
+	entry := models.ResponseCacheEntry{
+		Key:             key,
+		Endpoint:        endpoint,
+		StatusCode:      resp.StatusCode,
+		Headers:         string(headers),
+		Body:            resp.Body,
+		TavilyRequestID: resp.TavilyRequestID,
+		ExpiresAt:       resp.ExpiresAt,
+	}
+	if err := s.db.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).Create(&entry).Error; err != nil {
+		return err
+	}
+	return s.prune(ctx)
+}
+
+func (s *sqliteResponseCache) prune(ctx context.Context) error {
+	db := s.db.WithContext(ctx)
+	if err := db.Where("expires_at <= ?", time.Now()).Delete(&models.ResponseCacheEntry{}).Error; err != nil {
+		return err
+	}
+	capacity := s.capacity.Load()
+	if capacity <= 0 {
+		return nil
+	}
+	overflow := db.Model(&models.ResponseCacheEntry{}).Select("key").Order("expires_at DESC").Limit(-1).Offset(int(capacity))
+	return db.Where("key IN (?)", overflow).Delete(&models.ResponseCacheEntry{}).Error
+}
+
+func (s *sqliteResponseCache) Purge(ctx context.Context) error {
+	return s.db.WithContext(ctx).Where("1 = 1").Delete(&models.ResponseCacheEntry{}).Error
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestTavilyProxy_ResponseCache_HitSkipsUpstreamAndQuota(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range []string{CacheBackendMemory, CacheBackendSQLite} {
+		backend := backend
+		t.Run(backend, func(t *testing.T) {
+			t.Parallel()
+
+			var upstreamCalls int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&upstreamCalls, 1)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				_, _ = w.Write([]byte(`{"request_id":"abc","results":[]}`))
+			}))
+			t.Cleanup(upstream.Close)
+
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+			if err != nil {
+				t.Fatalf("db open: %v", err)
+			}
+			sqlDB, err := database.DB()
+			if err != nil {
+				t.Fatalf("db handle: %v", err)
+			}
+			t.Cleanup(func() { _ = sqlDB.Close() })
+
+			settings := NewSettingsService(database)
+			keys := NewKeyService(database, logger)
+			logs := NewLogService(database, logger)
+			stats := NewStatsService(database)
+			cache := NewResponseCache(database, settings)
+
+			ctx := context.Background()
+			key, err := keys.Create(ctx, "tvly-test", "test", 1000)
+			if err != nil {
+				t.Fatalf("create key: %v", err)
+			}
+			if err := cache.SetConfig(ctx, ResponseCacheConfig{
+				Enabled:    true,
+				Backend:    backend,
+				MaxEntries: 10,
+				TTLSeconds: map[string]int{"/search": 60},
+			}); err != nil {
+				t.Fatalf("set cache config: %v", err)
+			}
+
+			proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, stats, logger).
+				WithSettings(settings).
+				WithCache(cache)
+
+			first, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"hello","max_results":5}`)})
+			if err != nil {
+				t.Fatalf("first request: %v", err)
+			}
+			if first.CacheStatus != CacheStatusMiss {
+				t.Fatalf("unexpected first cache status: %q", first.CacheStatus)
+			}
+
+			// Same payload with a different key order and whitespace.
+			second, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{ "max_results": 5, "query": "hello" }`)})
+			if err != nil {
+				t.Fatalf("second request: %v", err)
+			}
+			if second.CacheStatus != CacheStatusHit {
+				t.Fatalf("unexpected second cache status: %q", second.CacheStatus)
+			}
+			if string(second.Body) != string(first.Body) || second.TavilyRequestID != "abc" {
+				t.Fatalf("unexpected cached response: body=%q request_id=%q", second.Body, second.TavilyRequestID)
+			}
+
+			if got := atomic.LoadInt32(&upstreamCalls); got != 1 {
+				t.Fatalf("unexpected upstream calls: got %d want %d", got, 1)
+			}
+			got, err := keys.Get(ctx, key.ID)
+			if err != nil {
+				t.Fatalf("get key: %v", err)
+			}
+			if got.UsedQuota != 1 {
+				t.Fatalf("cache hit should not charge the key: used_quota=%d", got.UsedQuota)
+			}
+
+			summary, err := stats.Get(ctx)
+			if err != nil {
+				t.Fatalf("stats: %v", err)
+			}
+			if summary.TodayRequests != 2 || summary.TodayCacheHits != 1 || summary.TotalCacheHits != 1 {
+				t.Fatalf("unexpected stats: %+v", summary)
+			}
+		})
+	}
+}
+
+func TestRequestFingerprint_TrailingInput(t *testing.T) {
+	t.Parallel()
+
+	fingerprint := func(body string) string {
+		return requestFingerprint(ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(body)})
+	}
+	base := fingerprint(`{"query":"a","topic":"news"}`)
+	if got := fingerprint(" {\"topic\":\"news\", \"query\":\"a\"}\n"); got != base {
+		t.Fatalf("key order and whitespace should not change the fingerprint")
+	}
+	for _, body := range []string{
+		`{"query":"a","topic":"news"}{"query":"b"}`,
+		`{"query":"a","topic":"news"} garbage`,
+	} {
+		if fingerprint(body) == base {
+			t.Fatalf("trailing input should change the fingerprint: %s", body)
+		}
+	}
+}
+
+func TestMemoryResponseCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cache := newMemoryResponseCache(2)
+	expires := time.Now().Add(time.Minute)
+
+	_ = cache.Set(ctx, "a", "/search", CachedResponse{StatusCode: 200, ExpiresAt: expires})
+	_ = cache.Set(ctx, "b", "/search", CachedResponse{StatusCode: 200, ExpiresAt: expires})
+	if _, ok, _ := cache.Get(ctx, "a"); !ok {
+		t.Fatalf("expected a to be cached")
+	}
+	_ = cache.Set(ctx, "c", "/search", CachedResponse{StatusCode: 200, ExpiresAt: expires})
+
+	if _, ok, _ := cache.Get(ctx, "b"); ok {
+		t.Fatalf("expected b to be evicted")
+	}
+	if _, ok, _ := cache.Get(ctx, "a"); !ok {
+		t.Fatalf("expected a to survive eviction")
+	}
+
+	_ = cache.Set(ctx, "expired", "/search", CachedResponse{StatusCode: 200, ExpiresAt: time.Now().Add(-time.Second)})
+	if _, ok, _ := cache.Get(ctx, "expired"); ok {
+		t.Fatalf("expected expired entry to miss")
+	}
+}
+
+func TestSQLiteResponseCache_PrunesOnSet(t *testing.T) {
+	t.Parallel()
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	cache := newSQLiteResponseCache(database, 2)
+	now := time.Now()
+
+	for _, entry := range []struct {
+		key     string
+		expires time.Time
+	}{
+		{"expired", now.Add(-time.Second)},
+		{"soon", now.Add(time.Minute)},
+		{"later", now.Add(2 * time.Minute)},
+		{"latest", now.Add(3 * time.Minute)},
+	} {
+		if err := cache.Set(ctx, entry.key, "/search", CachedResponse{StatusCode: 200, ExpiresAt: entry.expires}); err != nil {
+			t.Fatalf("set %s: %v", entry.key, err)
+		}
+	}
+
+	var keys []string
+	if err := database.Model(&models.ResponseCacheEntry{}).Order("key").Pluck("key", &keys).Error; err != nil {
+		t.Fatalf("list entries: %v", err)
+	}
+	if len(keys) != 2 || keys[0] != "later" || keys[1] != "latest" {
+		t.Fatalf("unexpected entries after pruning: %v", keys)
+	}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how TavilyProxy retries upstream answers that are not
+// tied to a key's state. 401/429/432/433 always fail over to the next key;
+// RetryStatuses adds statuses (typically 5xx) that are retried on another key.
+type RetryPolicy struct {
+	RetryStatuses []int `json:"retry_statuses"`
+	// MaxAttempts caps upstream calls per request; 0 tries every candidate.
+	MaxAttempts int `json:"max_attempts"`
+	// DeadlineMs bounds the whole request across all attempts; 0 disables it.
+	DeadlineMs int `json:"deadline_ms"`
+	// HedgeAfterMs fires the same request on a second key when the first has
+	// not answered within the threshold; 0 disables hedging.
+	HedgeAfterMs   int      `json:"hedge_after_ms"`
+	HedgeEndpoints []string `json:"hedge_endpoints"`
+}
+
+const (
+	maxRetryAttempts   = 20
+	maxRetryDeadlineMs = 5 * 60 * 1000
+)
+
+var ErrRetryDeadlineExceeded = errors.New("retry deadline exceeded")
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		RetryStatuses:  []int{502, 503, 504},
+		HedgeEndpoints: []string{"/search"},
+	}
+}
+
+// Validate reports the first out-of-range field as a snake_case error code.
+func (r RetryPolicy) Validate() error {
+	for _, status := range r.RetryStatuses {
+		if status < 400 || status > 599 {
+			return errors.New("invalid_retry_statuses")
+		}
+	}
+	if r.MaxAttempts < 0 || r.MaxAttempts > maxRetryAttempts {
+		return errors.New("invalid_max_attempts")
+	}
+	if r.DeadlineMs < 0 || r.DeadlineMs > maxRetryDeadlineMs {
+		return errors.New("invalid_deadline_ms")
+	}
+	if r.HedgeAfterMs < 0 || r.HedgeAfterMs > maxRetryDeadlineMs {
+		return errors.New("invalid_hedge_after_ms")
+	}
+	return nil
+}
+
+func (r RetryPolicy) retryable(status int) bool {
+	for _, s := range r.RetryStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// hedgeDelay returns how long to wait before hedging req, or 0 when req is not
+// hedged. Only buffered POST requests are hedged.
+func (r RetryPolicy) hedgeDelay(req ProxyRequest) time.Duration {
+	if r.HedgeAfterMs <= 0 || !strings.EqualFold(req.Method, http.MethodPost) {
+		return 0
+	}
+	if !endpointListContains(JoinEndpoints(r.HedgeEndpoints), req.Path) {
+		return 0
+	}
+	return time.Duration(r.HedgeAfterMs) * time.Millisecond
+}
+
+func (r RetryPolicy) attemptsLeft(made int) bool {
+	return r.MaxAttempts <= 0 || made < r.MaxAttempts
+}
+
+// RetryPolicy returns the stored retry policy, or the default when none has
+// been saved.
+func (p *TavilyProxy) RetryPolicy(ctx context.Context) (RetryPolicy, error) {
+	policy := DefaultRetryPolicy()
+	if p.settings == nil {
+		return policy, nil
+	}
+	raw, ok, err := p.settings.Get(ctx, SettingRetryPolicy)
+	if err != nil {
+		return policy, err
+	}
+	if !ok || strings.TrimSpace(raw) == "" {
+		return policy, nil
+	}
+	var stored RetryPolicy
+	if err := json.Unmarshal([]byte(raw), &stored); err != nil || stored.Validate() != nil {
+		return policy, nil
+	}
+	return stored, nil
+}
+
+func (p *TavilyProxy) SetRetryPolicy(ctx context.Context, policy RetryPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	endpoints := SplitEndpoints(JoinEndpoints(policy.HedgeEndpoints))
+	policy.HedgeEndpoints = endpoints
+	raw, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	return p.settings.Set(ctx, SettingRetryPolicy, string(raw))
+}
@@ -0,0 +1,198 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// newRetryTestProxy wires a proxy with two keys, "tvly-first" (tried first by
+// the default strategy) and "tvly-second", against handler.
+func newRetryTestProxy(t *testing.T, handler http.HandlerFunc, policy RetryPolicy) (*TavilyProxy, *gorm.DB, *models.APIKey, *models.APIKey) {
+	t.Helper()
+
+	upstream := httptest.NewServer(handler)
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	settings := NewSettingsService(database)
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	first, err := keys.Create(ctx, "tvly-first", "first", 2000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	second, err := keys.Create(ctx, "tvly-second", "second", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger).
+		WithSettings(settings)
+	if err := proxy.SetRetryPolicy(ctx, policy); err != nil {
+		t.Fatalf("set retry policy: %v", err)
+	}
+	return proxy, database, first, second
+}
+
+func TestTavilyProxy_RetriesConfiguredStatusOnNextKey(t *testing.T) {
+	t.Parallel()
+
+	proxy, database, first, second := newRetryTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Header.Get("Authorization") == "Bearer tvly-first" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"detail":"unavailable"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}, RetryPolicy{RetryStatuses: []int{http.StatusServiceUnavailable}})
+
+	ctx := context.Background()
+	resp, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status: got %d want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	var entries []models.RequestLog
+	if err := database.WithContext(ctx).Find(&entries).Error; err != nil {
+		t.Fatalf("load logs: %v", err)
+	}
+	if len(entries) != 1 || entries[0].KeyUsed != second.ID || entries[0].Attempt != 2 {
+		t.Fatalf("unexpected log rows: %+v", entries)
+	}
+
+	var attempts []models.RequestAttempt
+	if err := database.WithContext(ctx).Where("request_id = ?", resp.ProxyRequestID).Order("attempt asc").Find(&attempts).Error; err != nil {
+		t.Fatalf("load attempts: %v", err)
+	}
+	if len(attempts) != 2 {
+		t.Fatalf("unexpected attempts: got %d want %d", len(attempts), 2)
+	}
+	if attempts[0].KeyID != first.ID || attempts[0].Outcome != AttemptOutcomeRetried || attempts[0].StatusCode != http.StatusServiceUnavailable || attempts[0].Error == "" {
+		t.Fatalf("unexpected first attempt: %+v", attempts[0])
+	}
+	if attempts[1].KeyID != second.ID || attempts[1].Outcome != AttemptOutcomeReturned {
+		t.Fatalf("unexpected final attempt: %+v", attempts[1])
+	}
+
+	// Endpoints outside the capture policy keep no upstream error bodies.
+	resp, err = proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/extract", Body: []byte(`{"urls":["u"]}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	var uncaptured models.RequestAttempt
+	if err := database.WithContext(ctx).Where("request_id = ? AND outcome = ?", resp.ProxyRequestID, AttemptOutcomeRetried).First(&uncaptured).Error; err != nil {
+		t.Fatalf("load attempt: %v", err)
+	}
+	if uncaptured.Error != "" {
+		t.Fatalf("uncaptured endpoint stored an error body: %q", uncaptured.Error)
+	}
+}
+
+func TestTavilyProxy_RetryPassesLastAnswerThroughWhenAttemptsRunOut(t *testing.T) {
+	t.Parallel()
+
+	proxy, _, _, _ := newRetryTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		_, _ = w.Write([]byte(`{"detail":"bad gateway"}`))
+	}, RetryPolicy{RetryStatuses: []int{http.StatusBadGateway}, MaxAttempts: 1})
+
+	resp, err := proxy.Do(context.Background(), ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("unexpected status: got %d want %d", resp.StatusCode, http.StatusBadGateway)
+	}
+}
+
+func TestTavilyProxy_HedgesSlowAttempt(t *testing.T) {
+	t.Parallel()
+
+	proxy, database, first, second := newRetryTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer tvly-first" {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(2 * time.Second):
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}, RetryPolicy{HedgeAfterMs: 50, HedgeEndpoints: []string{"/search"}})
+
+	ctx := context.Background()
+	start := time.Now()
+	resp, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("hedged request took too long: %s", elapsed)
+	}
+
+	var winner models.RequestLog
+	if err := database.WithContext(ctx).First(&winner).Error; err != nil {
+		t.Fatalf("load winner: %v", err)
+	}
+	if winner.KeyUsed != second.ID || !winner.Hedged || winner.RequestID != resp.ProxyRequestID {
+		t.Fatalf("unexpected winner: %+v", winner)
+	}
+	var loser models.RequestAttempt
+	if err := database.WithContext(ctx).Where("outcome = ?", AttemptOutcomeCancelled).First(&loser).Error; err != nil {
+		t.Fatalf("load loser: %v", err)
+	}
+	if loser.KeyID != first.ID || loser.RequestID != resp.ProxyRequestID {
+		t.Fatalf("unexpected loser: %+v", loser)
+	}
+	if got := proxy.keys.BreakerState(first.ID); got != BreakerClosed {
+		t.Fatalf("losing a hedge should not count against the key: breaker=%q", got)
+	}
+}
+
+func TestTavilyProxy_RetryDeadline(t *testing.T) {
+	t.Parallel()
+
+	proxy, _, _, _ := newRetryTestProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}, RetryPolicy{DeadlineMs: 100})
+
+	_, err := proxy.Do(context.Background(), ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"q"}`)})
+	if !errors.Is(err, ErrRetryDeadlineExceeded) {
+		t.Fatalf("unexpected error: got %v want %v", err, ErrRetryDeadlineExceeded)
+	}
+}
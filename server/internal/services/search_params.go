@@ -0,0 +1,43 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"tavily-proxy/server/internal/models"
+)
+
+// maxLoggedQueryBytes caps the query text stored in its own column.
+const maxLoggedQueryBytes = 512
+
+// applySearchParams copies the /search parameters used by query analytics
+// from a POST /search body onto entry. Other requests and unparsable bodies
+// leave entry untouched.
+func applySearchParams(entry *models.RequestLog, req ProxyRequest) {
+	if !strings.EqualFold(req.Method, http.MethodPost) || normalizeEndpoint(req.Path) != "/search" || len(req.Body) == 0 {
+		return
+	}
+	var params map[string]any
+	if err := json.Unmarshal(req.Body, &params); err != nil {
+		return
+	}
+
+	query, _ := params["query"].(string)
+	entry.Query, _ = truncateForLog([]byte(strings.TrimSpace(query)), maxLoggedQueryBytes)
+	entry.Topic = stringParam(params, "topic")
+	entry.SearchDepth = stringParam(params, "search_depth")
+	entry.MaxResults = intParam(params, "max_results", 0)
+
+	var domains []string
+	if list, ok := params["include_domains"].([]any); ok {
+		for _, d := range list {
+			if v, ok := d.(string); ok {
+				if v = strings.ToLower(strings.TrimSpace(v)); v != "" && !strings.Contains(v, ",") {
+					domains = append(domains, v)
+				}
+			}
+		}
+	}
+	entry.IncludeDomains = strings.Join(domains, ",")
+}
@@ -11,7 +11,29 @@ const (
 
 	SettingRequestLoggingEnabled = "request_logging_enabled"
 
+	SettingKeySelectionStrategy = "key_selection_strategy"
+	SettingStreamingEndpoints   = "streaming_endpoints"
+	SettingCoalescingEnabled    = "request_coalescing_enabled"
+	SettingRetryPolicy          = "retry_policy"
+
+	SettingCacheEnabled    = "response_cache_enabled"
+	SettingCacheBackend    = "response_cache_backend"
+	SettingCacheMaxEntries = "response_cache_max_entries"
+	SettingCacheTTLs       = "response_cache_ttls"
+
+	SettingLogRedaction        = "log_redaction"
 	SettingLogRetentionDays    = "log_retention_days"
 	SettingLogCleanupLastRunAt = "log_cleanup_last_run_at"
 	SettingLogCleanupLastError = "log_cleanup_last_error"
+
+	SettingLogArchiveDir           = "log_archive_dir"
+	SettingLogArchiveRetentionDays = "log_archive_retention_days"
+	SettingLogArchiveMaxMB         = "log_archive_max_mb"
+	SettingLogArchiveLastPath      = "log_archive_last_path"
+
+	SettingNotifyPoolQuotaPercent = "notify_pool_quota_percent"
+	SettingNotifyMinActiveKeys    = "notify_min_active_keys"
+
+	// SettingStatsTimezone records the timezone the stats buckets were cut in.
+	SettingStatsTimezone = "stats_timezone"
 )
@@ -89,3 +89,20 @@ func (s *SettingsService) GetTime(ctx context.Context, key string) (*time.Time,
 func (s *SettingsService) SetTime(ctx context.Context, key string, value time.Time) error {
 	return s.Set(ctx, key, value.UTC().Format(time.RFC3339))
 }
+
+// Ping checks that the database accepts writes. The probe takes SQLite's write
+// lock in a transaction that is rolled back, so a locked database fails here
+// without anything being written.
+func (s *SettingsService) Ping(ctx context.Context) error {
+	errRollback := errors.New("rollback")
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("UPDATE settings SET value = value WHERE key = ?", "").Error; err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if errors.Is(err, errRollback) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"tavily-proxy/server/internal/models"
+)
+
+var errFlightAborted = errors.New("in-flight request aborted")
+
+type flightResult struct {
+	resp ProxyResponse
+	key  *models.APIKey
+	err  error
+}
+
+type flightCall struct {
+	done   chan struct{}
+	result flightResult
+}
+
+// flightGroup deduplicates concurrent calls that share a key: the first caller
+// runs fn and every caller that arrives before it finishes gets its result.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Do runs fn for key unless an identical call is already in flight. shared is
+// true for callers that waited on another caller's call; a waiter whose ctx
+// ends stops waiting and gets ctx's error, leaving the call to the others.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() flightResult) (result flightResult, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			return c.result, true
+		case <-ctx.Done():
+			return flightResult{err: ctx.Err()}, true
+		}
+	}
+	c := &flightCall{done: make(chan struct{}), result: flightResult{err: errFlightAborted}}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.result = fn()
+	return c.result, false
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"tavily-proxy/server/internal/models"
@@ -14,10 +16,33 @@ import (
 
 type StatsService struct {
 	db *gorm.DB
+	// loc is the reporting timezone buckets are cut in; resetLoc is the one
+	// the monthly quota reset follows.
+	loc      *time.Location
+	resetLoc *time.Location
 }
 
 func NewStatsService(db *gorm.DB) *StatsService {
-	return &StatsService{db: db}
+	return &StatsService{db: db, loc: time.Local, resetLoc: time.Local}
+}
+
+// WithTimezone sets the reporting timezone. Buckets already written in another
+// zone must be moved with MigrateTimezone.
+func (s *StatsService) WithTimezone(loc *time.Location) *StatsService {
+	s.loc = loc
+	return s
+}
+
+// WithResetTimezone sets the timezone of the monthly quota reset, which ends
+// the month in forecasts.
+func (s *StatsService) WithResetTimezone(loc *time.Location) *StatsService {
+	s.resetLoc = loc
+	return s
+}
+
+// Location returns the reporting timezone.
+func (s *StatsService) Location() *time.Location {
+	return s.loc
 }
 
 type Stats struct {
@@ -27,17 +52,8 @@ type Stats struct {
 	KeyCount       int64 `json:"key_count"`
 	ActiveKeyCount int64 `json:"active_key_count"`
 	TodayRequests  int64 `json:"today_requests"`
-}
-
-type TimeSeriesSeries struct {
-	Name string  `json:"name"`
-	Data []int64 `json:"data"`
-}
-
-type TimeSeries struct {
-	Granularity string             `json:"granularity"`
-	Labels      []string           `json:"labels"`
-	Series      []TimeSeriesSeries `json:"series"`
+	TodayCacheHits int64 `json:"today_cache_hits"`
+	TotalCacheHits int64 `json:"total_cache_hits"`
 }
 
 func (s *StatsService) Get(ctx context.Context) (Stats, error) {
@@ -67,21 +83,31 @@ func (s *StatsService) Get(ctx context.Context) (Stats, error) {
 	totalQuota = a.TotalQuota
 	totalUsed = a.TotalUsed
 
-	now := time.Now()
+	now := time.Now().In(s.loc)
 	todayBucket := now.Format("2006-01-02")
 	var todayRequests int64
+	var todayCacheHits int64
 	{
 		var rs models.RequestStat
-		err := s.db.WithContext(ctx).First(&rs, "granularity = ? AND bucket = ? AND endpoint = ?", "day", todayBucket, "").Error
+		err := totalsOnly(s.db.WithContext(ctx)).First(&rs, "granularity = ? AND bucket = ? AND endpoint = ?", "day", todayBucket, "").Error
 		if err != nil {
 			if !errors.Is(err, gorm.ErrRecordNotFound) {
 				return Stats{}, err
 			}
 		} else {
 			todayRequests = rs.Count
+			todayCacheHits = rs.CacheHits
 		}
 	}
 
+	var totalCacheHits int64
+	if err := totalsOnly(s.db.WithContext(ctx).Model(&models.RequestStat{})).
+		Select("COALESCE(SUM(cache_hits),0)").
+		Where("granularity = ? AND endpoint = ?", "month", "").
+		Scan(&totalCacheHits).Error; err != nil {
+		return Stats{}, err
+	}
+
 	totalRemaining := totalQuota - totalUsed
 	if totalRemaining < 0 {
 		totalRemaining = 0
@@ -94,148 +120,212 @@ func (s *StatsService) Get(ctx context.Context) (Stats, error) {
 		KeyCount:       keyCount,
 		ActiveKeyCount: activeKeyCount,
 		TodayRequests:  todayRequests,
+		TodayCacheHits: todayCacheHits,
+		TotalCacheHits: totalCacheHits,
 	}, nil
 }
 
-func (s *StatsService) TimeSeries(ctx context.Context, granularity string) (TimeSeries, error) {
-	now := time.Now()
-
-	var (
-		start       time.Time
-		points      int
-		step        func(time.Time) time.Time
-		bucketKey   func(time.Time) string
-		labelFormat func(time.Time) string
-	)
-
-	switch granularity {
-	case "", "hour":
-		granularity = "hour"
-		// Last 24 hours, inclusive.
-		start = time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location()).Add(-23 * time.Hour)
-		points = 24
-		step = func(t time.Time) time.Time { return t.Add(time.Hour) }
-		bucketKey = func(t time.Time) string { return t.Format("2006-01-02 15:00") }
-		labelFormat = func(t time.Time) string { return t.Format("01-02 15:00") }
-	case "day":
-		start = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -29)
-		points = 30
-		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
-		bucketKey = func(t time.Time) string { return t.Format("2006-01-02") }
-		labelFormat = func(t time.Time) string { return t.Format("01-02") }
-	case "month":
-		firstOfThisMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-		start = firstOfThisMonth.AddDate(0, -11, 0)
-		points = 12
-		step = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
-		bucketKey = func(t time.Time) string { return t.Format("2006-01") }
-		labelFormat = func(t time.Time) string { return t.Format("2006-01") }
-	default:
-		return TimeSeries{}, fmt.Errorf("invalid granularity: %s", granularity)
+// RecordRequest counts one request in the overall, endpoint, key and status
+// buckets. Cache hits are included in the request totals as well as the cache
+// hit counters.
+func (s *StatsService) RecordRequest(ctx context.Context, ev StatEvent) error {
+	deltas := make(map[statKey]*statDelta)
+	addStatDeltas(deltas, ev, s.loc)
+	return s.applyDeltas(ctx, deltas)
+}
+
+// StatEvent is one request to be counted. KeyID is zero when no upstream key
+// answered, such as for cache hits. LatencyMs is negative when the latency was
+// not measured, such as for requests that never reached Tavily.
+type StatEvent struct {
+	Endpoint   string
+	KeyID      uint
+	StatusCode int
+	LatencyMs  int64
+	OccurredAt time.Time
+	CacheHit   bool
+}
+
+// RecordBatch counts many requests at once, merging events that land in the
+// same bucket into a single upsert.
+func (s *StatsService) RecordBatch(ctx context.Context, events []StatEvent) error {
+	deltas := make(map[statKey]*statDelta)
+	for _, ev := range events {
+		addStatDeltas(deltas, ev, s.loc)
 	}
+	return s.applyDeltas(ctx, deltas)
+}
 
-	startBucket := bucketKey(start)
-	totalCounts, err := s.bucketCountsFromStats(ctx, granularity, "", startBucket)
-	if err != nil {
-		return TimeSeries{}, err
+type statKey struct {
+	granularity string
+	bucket      string
+	start       int64
+	endpoint    string
+	keyID       uint
+	status      int
+}
+
+type statDelta struct {
+	count          int64
+	cacheHits      int64
+	status2xx      int64
+	status3xx      int64
+	status4xx      int64
+	status5xx      int64
+	latencySumMs   int64
+	latencySamples int64
+}
+
+func (d *statDelta) add(ev StatEvent) {
+	d.count++
+	if ev.CacheHit {
+		d.cacheHits++
 	}
-	searchCounts, err := s.bucketCountsFromStats(ctx, granularity, "/search", startBucket)
-	if err != nil {
-		return TimeSeries{}, err
+	switch ev.StatusCode / 100 {
+	case 2:
+		d.status2xx++
+	case 3:
+		d.status3xx++
+	case 4:
+		d.status4xx++
+	case 5:
+		d.status5xx++
 	}
-
-	labels := make([]string, 0, points)
-	totalSeries := make([]int64, 0, points)
-	searchSeries := make([]int64, 0, points)
-
-	t := start
-	for i := 0; i < points; i++ {
-		key := bucketKey(t)
-		labels = append(labels, labelFormat(t))
-		totalSeries = append(totalSeries, totalCounts[key])
-		searchSeries = append(searchSeries, searchCounts[key])
-		t = step(t)
+	if ev.LatencyMs >= 0 {
+		d.latencySumMs += ev.LatencyMs
+		d.latencySamples++
 	}
+}
 
-	return TimeSeries{
-		Granularity: granularity,
-		Labels:      labels,
-		Series: []TimeSeriesSeries{
-			{Name: "All Requests", Data: totalSeries},
-			{Name: "Search", Data: searchSeries},
-		},
-	}, nil
+// merge adds the counters of an existing row.
+func (d *statDelta) merge(r models.RequestStat) {
+	d.count += r.Count
+	d.cacheHits += r.CacheHits
+	d.status2xx += r.Status2xx
+	d.status3xx += r.Status3xx
+	d.status4xx += r.Status4xx
+	d.status5xx += r.Status5xx
+	d.latencySumMs += r.LatencySumMs
+	d.latencySamples += r.LatencySamples
 }
 
-func (s *StatsService) bucketCountsFromStats(ctx context.Context, granularity, endpoint, startBucket string) (map[string]int64, error) {
-	type row struct {
-		Bucket string `gorm:"column:bucket"`
-		Count  int64  `gorm:"column:count"`
+func deltaFor(deltas map[statKey]*statDelta, k statKey) *statDelta {
+	d, ok := deltas[k]
+	if !ok {
+		d = &statDelta{}
+		deltas[k] = d
 	}
+	return d
+}
 
-	var rows []row
-	if err := s.db.WithContext(ctx).
-		Model(&models.RequestStat{}).
-		Select("bucket, count").
-		Where("granularity = ? AND endpoint = ? AND bucket >= ?", granularity, endpoint, startBucket).
-		Order("bucket").
-		Scan(&rows).Error; err != nil {
-		return nil, err
+func (k statKey) row(d *statDelta, updatedAt time.Time) models.RequestStat {
+	return models.RequestStat{
+		Granularity:    k.granularity,
+		Bucket:         k.bucket,
+		BucketStart:    k.start,
+		Endpoint:       k.endpoint,
+		KeyID:          k.keyID,
+		StatusCode:     k.status,
+		Count:          d.count,
+		CacheHits:      d.cacheHits,
+		Status2xx:      d.status2xx,
+		Status3xx:      d.status3xx,
+		Status4xx:      d.status4xx,
+		Status5xx:      d.status5xx,
+		LatencySumMs:   d.latencySumMs,
+		LatencySamples: d.latencySamples,
+		UpdatedAt:      updatedAt,
 	}
+}
 
-	out := make(map[string]int64, len(rows))
-	for _, r := range rows {
-		out[r.Bucket] = r.Count
+// statBuckets returns dim's hour, day and month buckets containing t, cut in
+// t's location.
+func statBuckets(dim statKey, t time.Time) []statKey {
+	keys := []statKey{
+		{granularity: "hour", bucket: t.Format("2006-01-02 15:00"), start: hourStart(t).Unix()},
+		{granularity: "day", bucket: t.Format("2006-01-02")},
+		{granularity: "month", bucket: t.Format("2006-01")},
 	}
-	return out, nil
+	for i := range keys {
+		keys[i].endpoint, keys[i].keyID, keys[i].status = dim.endpoint, dim.keyID, dim.status
+	}
+	return keys
 }
 
-func (s *StatsService) RecordRequest(ctx context.Context, endpoint string, occurredAt time.Time) error {
-	loc := occurredAt.Location()
-	hour := time.Date(occurredAt.Year(), occurredAt.Month(), occurredAt.Day(), occurredAt.Hour(), 0, 0, 0, loc)
-	day := time.Date(occurredAt.Year(), occurredAt.Month(), occurredAt.Day(), 0, 0, 0, 0, loc)
-	month := time.Date(occurredAt.Year(), occurredAt.Month(), 1, 0, 0, 0, 0, loc)
+// hourStart is the instant t's wall-clock hour began, found by subtracting
+// rather than by rebuilding the wall-clock time, which a repeated DST hour
+// makes ambiguous.
+func hourStart(t time.Time) time.Time {
+	return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+}
 
-	updatedAt := time.Now()
+// statEndpoints are the endpoints counted separately. The proxy forwards any
+// path, so everything else is counted under statEndpointOther.
+var statEndpoints = map[string]struct{}{
+	"/search": {}, "/extract": {}, "/crawl": {}, "/map": {}, "/usage": {}, "/research": {},
+}
 
-	if err := s.upsertIncrement(ctx, "hour", hour.Format("2006-01-02 15:00"), "", 1, updatedAt); err != nil {
-		return err
+const statEndpointOther = "other"
+
+// StatEndpoint maps a request path to the endpoint it is counted under. The
+// metrics endpoint label uses it too, so both bound the same set.
+func StatEndpoint(path string) string {
+	if _, ok := statEndpoints[path]; ok {
+		return path
 	}
-	if err := s.upsertIncrement(ctx, "day", day.Format("2006-01-02"), "", 1, updatedAt); err != nil {
-		return err
+	return statEndpointOther
+}
+
+// addStatDeltas adds one request to the hour, day and month buckets of the
+// overall totals, of its endpoint, and of its key and status, as seen in loc.
+func addStatDeltas(deltas map[statKey]*statDelta, ev StatEvent, loc *time.Location) {
+	occurredAt := ev.OccurredAt.In(loc)
+	dims := []statKey{{}}
+	if ev.Endpoint != "" {
+		dims = append(dims, statKey{endpoint: StatEndpoint(ev.Endpoint)})
 	}
-	if err := s.upsertIncrement(ctx, "month", month.Format("2006-01"), "", 1, updatedAt); err != nil {
-		return err
+	if ev.KeyID != 0 {
+		dims = append(dims, statKey{keyID: ev.KeyID})
 	}
-
-	if endpoint == "/search" {
-		if err := s.upsertIncrement(ctx, "hour", hour.Format("2006-01-02 15:00"), "/search", 1, updatedAt); err != nil {
-			return err
-		}
-		if err := s.upsertIncrement(ctx, "day", day.Format("2006-01-02"), "/search", 1, updatedAt); err != nil {
-			return err
-		}
-		if err := s.upsertIncrement(ctx, "month", month.Format("2006-01"), "/search", 1, updatedAt); err != nil {
-			return err
+	if ev.StatusCode != 0 {
+		dims = append(dims, statKey{status: ev.StatusCode})
+	}
+	for _, dim := range dims {
+		for _, k := range statBuckets(dim, occurredAt) {
+			deltaFor(deltas, k).add(ev)
 		}
 	}
-
-	return nil
 }
 
-func (s *StatsService) upsertIncrement(ctx context.Context, granularity, bucket, endpoint string, inc int64, updatedAt time.Time) error {
-	stat := models.RequestStat{
-		Granularity: granularity,
-		Bucket:      bucket,
-		Endpoint:    endpoint,
-		Count:       inc,
-		UpdatedAt:   updatedAt,
+func (s *StatsService) applyDeltas(ctx context.Context, deltas map[statKey]*statDelta) error {
+	if len(deltas) == 0 {
+		return nil
 	}
-	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
-		Columns: []clause.Column{{Name: "granularity"}, {Name: "bucket"}, {Name: "endpoint"}},
+	updatedAt := time.Now()
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for k, d := range deltas {
+			if err := upsertIncrement(tx, k, d, updatedAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func upsertIncrement(tx *gorm.DB, k statKey, d *statDelta, updatedAt time.Time) error {
+	stat := k.row(d, updatedAt)
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "granularity"}, {Name: "bucket"}, {Name: "bucket_start"}, {Name: "endpoint"}, {Name: "key_id"}, {Name: "status_code"}},
 		DoUpdates: clause.Assignments(map[string]any{
-			"count":      gorm.Expr("count + ?", inc),
-			"updated_at": updatedAt,
+			"count":           gorm.Expr("count + ?", d.count),
+			"cache_hits":      gorm.Expr("cache_hits + ?", d.cacheHits),
+			"status_2xx":      gorm.Expr("status_2xx + ?", d.status2xx),
+			"status_3xx":      gorm.Expr("status_3xx + ?", d.status3xx),
+			"status_4xx":      gorm.Expr("status_4xx + ?", d.status4xx),
+			"status_5xx":      gorm.Expr("status_5xx + ?", d.status5xx),
+			"latency_sum_ms":  gorm.Expr("latency_sum_ms + ?", d.latencySumMs),
+			"latency_samples": gorm.Expr("latency_samples + ?", d.latencySamples),
+			"updated_at":      updatedAt,
 		}),
 	}).Create(&stat).Error
 }
@@ -249,78 +339,72 @@ func (s *StatsService) BackfillFromLogsIfEmpty(ctx context.Context) error {
 		return nil
 	}
 
-	type row struct {
-		Bucket string `gorm:"column:bucket"`
-		Count  int64  `gorm:"column:count"`
+	// SQLite only knows UTC and the process's zone, so logs are grouped by hour
+	// in a fixed zone carrying the sub-hour part of the reporting timezone's
+	// offset, such as +00:30 for India, and cut into its buckets afterwards.
+	_, offset := time.Now().In(s.loc).Zone()
+	hourZone := time.FixedZone("", offset%3600)
+	hourExpr := fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:00', created_at, '%+d seconds')", offset%3600)
+
+	// Each dimension selects the same columns so the rows map straight onto
+	// RequestStat; the ones a dimension does not split by are constants.
+	type dimension struct {
+		columns string
+		where   string
+		group   string
 	}
 
-	type spec struct {
-		granularity string
-		expr        string
+	known := make([]string, 0, len(statEndpoints))
+	for ep := range statEndpoints {
+		known = append(known, "'"+ep+"'")
 	}
-
-	specs := []spec{
-		{granularity: "hour", expr: "strftime('%Y-%m-%d %H:00', created_at, 'localtime')"},
-		{granularity: "day", expr: "strftime('%Y-%m-%d', created_at, 'localtime')"},
-		{granularity: "month", expr: "strftime('%Y-%m', created_at, 'localtime')"},
+	sort.Strings(known)
+	endpointExpr := "CASE WHEN endpoint IN (" + strings.Join(known, ", ") + ") THEN endpoint ELSE '" + statEndpointOther + "' END"
+
+	dimensions := []dimension{
+		{columns: "'' as endpoint, 0 as key_id, 0 as status_code"},
+		{columns: endpointExpr + " as endpoint, 0 as key_id, 0 as status_code", where: "endpoint <> ''", group: endpointExpr},
+		{columns: "'' as endpoint, key_used as key_id, 0 as status_code", where: "key_used <> 0", group: "key_used"},
+		{columns: "'' as endpoint, 0 as key_id, status_code", where: "status_code <> 0", group: "status_code"},
 	}
 
-	updatedAt := time.Now()
-
-	for _, sp := range specs {
-		var allRows []row
-		if err := s.db.WithContext(ctx).
+	// Failed requests that never reached Tavily are logged with no key and no
+	// latency, so they are left out of the latency average.
+	measured := "(key_used <> 0 OR cache_hit)"
+	aggregates := "COUNT(*) as count" +
+		", SUM(CASE WHEN cache_hit THEN 1 ELSE 0 END) as cache_hits" +
+		", SUM(CASE WHEN status_code BETWEEN 200 AND 299 THEN 1 ELSE 0 END) as status_2xx" +
+		", SUM(CASE WHEN status_code BETWEEN 300 AND 399 THEN 1 ELSE 0 END) as status_3xx" +
+		", SUM(CASE WHEN status_code BETWEEN 400 AND 499 THEN 1 ELSE 0 END) as status_4xx" +
+		", SUM(CASE WHEN status_code BETWEEN 500 AND 599 THEN 1 ELSE 0 END) as status_5xx" +
+		", SUM(CASE WHEN " + measured + " THEN latency_ms ELSE 0 END) as latency_sum_ms" +
+		", SUM(CASE WHEN " + measured + " THEN 1 ELSE 0 END) as latency_samples"
+
+	deltas := make(map[statKey]*statDelta)
+	for _, dim := range dimensions {
+		q := s.db.WithContext(ctx).
 			Model(&models.RequestLog{}).
-			Select(sp.expr + " as bucket, COUNT(*) as count").
-			Group("bucket").
-			Order("bucket").
-			Scan(&allRows).Error; err != nil {
-			return err
+			Select(hourExpr + " as bucket, " + dim.columns + ", " + aggregates)
+		if dim.where != "" {
+			q = q.Where(dim.where)
 		}
-
-		var searchRows []row
-		if err := s.db.WithContext(ctx).
-			Model(&models.RequestLog{}).
-			Select(sp.expr+" as bucket, COUNT(*) as count").
-			Where("endpoint = ?", "/search").
-			Group("bucket").
-			Order("bucket").
-			Scan(&searchRows).Error; err != nil {
-			return err
+		group := "bucket"
+		if dim.group != "" {
+			group += ", " + dim.group
 		}
-
-		if len(allRows) > 0 {
-			stats := make([]models.RequestStat, 0, len(allRows))
-			for _, r := range allRows {
-				stats = append(stats, models.RequestStat{
-					Granularity: sp.granularity,
-					Bucket:      r.Bucket,
-					Endpoint:    "",
-					Count:       r.Count,
-					UpdatedAt:   updatedAt,
-				})
-			}
-			if err := s.db.WithContext(ctx).CreateInBatches(stats, 500).Error; err != nil {
-				return err
-			}
+		var rows []models.RequestStat
+		if err := q.Group(group).Scan(&rows).Error; err != nil {
+			return err
 		}
-
-		if len(searchRows) > 0 {
-			stats := make([]models.RequestStat, 0, len(searchRows))
-			for _, r := range searchRows {
-				stats = append(stats, models.RequestStat{
-					Granularity: sp.granularity,
-					Bucket:      r.Bucket,
-					Endpoint:    "/search",
-					Count:       r.Count,
-					UpdatedAt:   updatedAt,
-				})
-			}
-			if err := s.db.WithContext(ctx).CreateInBatches(stats, 500).Error; err != nil {
-				return err
-			}
+		if err := rebucketHours(deltas, rows, hourZone, s.loc); err != nil {
+			return err
 		}
 	}
 
-	return nil
+	return insertDeltas(s.db.WithContext(ctx), deltas)
+}
+
+// totalsOnly narrows a RequestStat query to rows not split by key or status.
+func totalsOnly(db *gorm.DB) *gorm.DB {
+	return db.Where("key_id = ? AND status_code = ?", 0, 0)
 }
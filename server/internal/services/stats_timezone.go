@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+	"tavily-proxy/server/internal/util"
+
+	"gorm.io/gorm"
+)
+
+// MigrateTimezone moves the stats buckets to the reporting timezone when they
+// were written in another one, as recorded under SettingStatsTimezone.
+// Buckets from before the setting existed are taken to be in the server's
+// local zone. Every bucket is rebuilt from the hour buckets, which are never
+// pruned. The reporting zone is recorded under its IANA name even when nothing
+// moves; a local zone whose name cannot be found is not recorded. It reports
+// whether anything was moved.
+func (s *StatsService) MigrateTimezone(ctx context.Context, settings *SettingsService) (bool, error) {
+	recorded, _, err := settings.Get(ctx, SettingStatsTimezone)
+	if err != nil {
+		return false, err
+	}
+	from := namedLocation(time.Local)
+	// Earlier builds could record the local zone as "Local".
+	if recorded != "" && recorded != "Local" {
+		if from, err = time.LoadLocation(recorded); err != nil {
+			return false, err
+		}
+	}
+	to := namedLocation(s.loc)
+	name := to.String()
+
+	if from.String() == name {
+		if recorded == name || name == "Local" {
+			return false, nil
+		}
+		return false, settings.Set(ctx, SettingStatsTimezone, name)
+	}
+
+	migrated := false
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var hours []models.RequestStat
+		if err := tx.Where("granularity = ?", "hour").Find(&hours).Error; err != nil {
+			return err
+		}
+		if len(hours) > 0 {
+			deltas := make(map[statKey]*statDelta)
+			if err := rebucketHours(deltas, hours, from, to); err != nil {
+				return err
+			}
+			if err := tx.Where("1 = 1").Delete(&models.RequestStat{}).Error; err != nil {
+				return err
+			}
+			if err := insertDeltas(tx, deltas); err != nil {
+				return err
+			}
+			migrated = true
+		}
+		if name == "Local" {
+			return nil
+		}
+		return tx.Save(&models.Setting{Key: SettingStatsTimezone, Value: name}).Error
+	})
+	return migrated, err
+}
+
+// namedLocation swaps time.Local for the same zone under its IANA name.
+func namedLocation(loc *time.Location) *time.Location {
+	if loc != time.Local {
+		return loc
+	}
+	named, _ := util.LocalLocation()
+	return named
+}
+
+// rebucketHours adds hour rows, whose buckets are wall-clock hours in from, to
+// the hour, day and month buckets of to. Rows are placed by the instant they
+// start at when it was recorded, and otherwise by their wall-clock hour in
+// from. Between zones whose offsets differ by a fraction of an hour, each hour
+// lands in the bucket holding its start.
+func rebucketHours(deltas map[statKey]*statDelta, rows []models.RequestStat, from, to *time.Location) error {
+	for _, r := range rows {
+		start := time.Unix(r.BucketStart, 0)
+		if r.BucketStart == 0 {
+			var err error
+			if start, err = time.ParseInLocation("2006-01-02 15:00", r.Bucket, from); err != nil {
+				return err
+			}
+		}
+		dim := statKey{endpoint: r.Endpoint, keyID: r.KeyID, status: r.StatusCode}
+		for _, k := range statBuckets(dim, start.In(to)) {
+			deltaFor(deltas, k).merge(r)
+		}
+	}
+	return nil
+}
+
+// insertDeltas writes deltas as new rows; the buckets must not exist yet.
+func insertDeltas(tx *gorm.DB, deltas map[statKey]*statDelta) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	updatedAt := time.Now()
+	rows := make([]models.RequestStat, 0, len(deltas))
+	for k, d := range deltas {
+		rows = append(rows, k.row(d, updatedAt))
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Granularity != rows[j].Granularity {
+			return rows[i].Granularity < rows[j].Granularity
+		}
+		return rows[i].Bucket < rows[j].Bucket
+	})
+	return tx.CreateInBatches(rows, 500).Error
+}
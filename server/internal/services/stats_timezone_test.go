@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+	"tavily-proxy/server/internal/util"
+)
+
+func TestStatsService_MigrateTimezone(t *testing.T) {
+	t.Parallel()
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	settings := NewSettingsService(database)
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+
+	utcStats := NewStatsService(database).WithTimezone(time.UTC)
+	if _, err := utcStats.MigrateTimezone(ctx, settings); err != nil {
+		t.Fatalf("record initial timezone: %v", err)
+	}
+	// 20:00 UTC on the 31st is already 05:00 on the 1st in Tokyo.
+	at := time.Date(2026, 1, 31, 20, 15, 0, 0, time.UTC)
+	events := []StatEvent{
+		{Endpoint: "/search", KeyID: 7, StatusCode: 200, LatencyMs: 120, OccurredAt: at},
+		{Endpoint: "/search", KeyID: 7, StatusCode: 500, LatencyMs: 80, OccurredAt: at},
+	}
+	if err := utcStats.RecordBatch(ctx, events); err != nil {
+		t.Fatalf("record stats: %v", err)
+	}
+
+	tokyoStats := NewStatsService(database).WithTimezone(tokyo)
+	migrated, err := tokyoStats.MigrateTimezone(ctx, settings)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if !migrated {
+		t.Fatalf("expected buckets to be migrated")
+	}
+
+	for _, want := range []struct{ granularity, bucket string }{
+		{"hour", "2026-02-01 05:00"},
+		{"day", "2026-02-01"},
+		{"month", "2026-02"},
+	} {
+		var row models.RequestStat
+		if err := totalsOnly(database).First(&row, "granularity = ? AND bucket = ? AND endpoint = ?", want.granularity, want.bucket, "").Error; err != nil {
+			t.Fatalf("%s bucket %s: %v", want.granularity, want.bucket, err)
+		}
+		if row.Count != 2 || row.Status5xx != 1 || row.LatencySumMs != 200 {
+			t.Fatalf("%s bucket %s: unexpected row %+v", want.granularity, want.bucket, row)
+		}
+	}
+	var stale int64
+	if err := database.Model(&models.RequestStat{}).Where("bucket IN ?", []string{"2026-01-31", "2026-01"}).Count(&stale).Error; err != nil {
+		t.Fatalf("count stale buckets: %v", err)
+	}
+	if stale != 0 {
+		t.Fatalf("buckets in the old timezone: got %d want %d", stale, 0)
+	}
+	var perKey int64
+	if err := database.Model(&models.RequestStat{}).Where("granularity = ? AND key_id = ?", "day", 7).Count(&perKey).Error; err != nil {
+		t.Fatalf("count key buckets: %v", err)
+	}
+	if perKey != 1 {
+		t.Fatalf("per-key day buckets: got %d want %d", perKey, 1)
+	}
+
+	if recorded, _, _ := settings.Get(ctx, SettingStatsTimezone); recorded != "Asia/Tokyo" {
+		t.Fatalf("recorded timezone: got %q want %q", recorded, "Asia/Tokyo")
+	}
+	if migrated, err := tokyoStats.MigrateTimezone(ctx, settings); err != nil || migrated {
+		t.Fatalf("second migration should be a no-op: migrated=%v err=%v", migrated, err)
+	}
+}
+
+func TestStatsService_MigrateTimezoneRecordsLocalZoneName(t *testing.T) {
+	t.Parallel()
+
+	if _, ok := util.LocalLocation(); !ok {
+		t.Skip("local timezone has no name on this host")
+	}
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	settings := NewSettingsService(database)
+	if migrated, err := NewStatsService(database).MigrateTimezone(ctx, settings); err != nil || migrated {
+		t.Fatalf("nothing to move: migrated=%v err=%v", migrated, err)
+	}
+	recorded, ok, err := settings.Get(ctx, SettingStatsTimezone)
+	if err != nil || !ok {
+		t.Fatalf("timezone not recorded: ok=%v err=%v", ok, err)
+	}
+	if recorded == "Local" {
+		t.Fatalf("recorded timezone should be a zone name, got %q", recorded)
+	}
+	if _, err := time.LoadLocation(recorded); err != nil {
+		t.Fatalf("recorded timezone %q does not load: %v", recorded, err)
+	}
+}
+
+func TestStatsService_MigrateTimezoneSplitsRepeatedHour(t *testing.T) {
+	t.Parallel()
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	settings := NewSettingsService(database)
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("tzdata unavailable: %v", err)
+	}
+
+	nyStats := NewStatsService(database).WithTimezone(newYork)
+	if _, err := nyStats.MigrateTimezone(ctx, settings); err != nil {
+		t.Fatalf("record initial timezone: %v", err)
+	}
+	// Clocks fell back at 06:00 UTC, so both requests land in 01:00 New York
+	// time: the first in EDT, the second an hour later in EST.
+	events := []StatEvent{
+		{Endpoint: "/search", StatusCode: 200, OccurredAt: time.Date(2025, 11, 2, 5, 30, 0, 0, time.UTC)},
+		{Endpoint: "/search", StatusCode: 200, OccurredAt: time.Date(2025, 11, 2, 6, 30, 0, 0, time.UTC)},
+	}
+	if err := nyStats.RecordBatch(ctx, events); err != nil {
+		t.Fatalf("record stats: %v", err)
+	}
+	series, err := nyStats.TimeSeries(ctx, TimeSeriesQuery{
+		Granularity: "hour",
+		From:        time.Date(2025, 11, 2, 4, 0, 0, 0, time.UTC),
+		To:          time.Date(2025, 11, 2, 5, 0, 0, 0, newYork),
+	})
+	if err != nil {
+		t.Fatalf("time series: %v", err)
+	}
+	if data := series.Series[0].Data; data[1] != 2 {
+		t.Fatalf("01:00 New York: got %d want %d (%v)", data[1], 2, series.Labels)
+	}
+
+	utcStats := NewStatsService(database).WithTimezone(time.UTC)
+	if _, err := utcStats.MigrateTimezone(ctx, settings); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	for _, bucket := range []string{"2025-11-02 05:00", "2025-11-02 06:00"} {
+		var row models.RequestStat
+		if err := totalsOnly(database).First(&row, "granularity = ? AND bucket = ? AND endpoint = ?", "hour", bucket, "").Error; err != nil {
+			t.Fatalf("hour bucket %s: %v", bucket, err)
+		}
+		if row.Count != 1 {
+			t.Fatalf("hour bucket %s: got %d want %d", bucket, row.Count, 1)
+		}
+	}
+}
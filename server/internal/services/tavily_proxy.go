@@ -9,23 +9,45 @@ import (
 	"io"
 	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
+	"tavily-proxy/server/internal/metrics"
 	"tavily-proxy/server/internal/models"
+	"tavily-proxy/server/internal/tracing"
 )
 
 type TavilyProxy struct {
 	baseURL string
 	client  *http.Client
 
+	// streamClient has no overall timeout, since a streamed body may outlive
+	// it; its transport bounds the wait for response headers and streamIdle
+	// bounds each gap between body reads.
+	streamClient *http.Client
+	streamIdle   time.Duration
+
 	settings *SettingsService
+	cache    *ResponseCache
 	keys     *KeyService
 	logs     *LogService
 	stats    *StatsService
+	writer   *LogWriter
+	metrics  *metrics.Metrics
 	logger   *slog.Logger
+
+	flights   flightGroup
+	redactors redactorCache
 }
 
 type ProxyRequest struct {
@@ -36,6 +58,11 @@ type ProxyRequest struct {
 	Body        []byte
 	ClientIP    string
 	ContentType string
+
+	// ClientKeyID and ClientKeyName identify the client key that issued the
+	// request; both are empty when the master key was used.
+	ClientKeyID   uint
+	ClientKeyName string
 }
 
 type ProxyResponse struct {
@@ -44,19 +71,28 @@ type ProxyResponse struct {
 	Body            []byte
 	ProxyRequestID  string
 	TavilyRequestID string
+
+	// CacheStatus is "HIT" or "MISS" for cacheable requests and empty otherwise.
+	CacheStatus string
 }
 
 func NewTavilyProxy(baseURL string, timeout time.Duration, keys *KeyService, logs *LogService, stats *StatsService, logger *slog.Logger) *TavilyProxy {
+	streamTransport := http.DefaultTransport.(*http.Transport).Clone()
+	streamTransport.ResponseHeaderTimeout = timeout
 	return &TavilyProxy{
 		baseURL: strings.TrimRight(baseURL, "/"),
 		client: &http.Client{
 			Timeout: timeout,
 		},
-		keys:     keys,
-		logs:     logs,
-		stats:    stats,
-		logger:   logger,
-		settings: nil,
+		streamClient: &http.Client{
+			Transport: streamTransport,
+		},
+		streamIdle: timeout,
+		keys:       keys,
+		logs:       logs,
+		stats:      stats,
+		logger:     logger,
+		settings:   nil,
 	}
 }
 
@@ -67,6 +103,51 @@ func (p *TavilyProxy) WithSettings(settings *SettingsService) *TavilyProxy {
 	return p
 }
 
+func (p *TavilyProxy) WithCache(cache *ResponseCache) *TavilyProxy {
+	p.cache = cache
+	return p
+}
+
+// WithLogWriter sends logs, attempts and stat counts through w instead of
+// writing them before the response is returned.
+func (p *TavilyProxy) WithLogWriter(w *LogWriter) *TavilyProxy {
+	p.writer = w
+	return p
+}
+
+func (p *TavilyProxy) WithMetrics(m *metrics.Metrics) *TavilyProxy {
+	p.metrics = m
+	return p
+}
+
+func (p *TavilyProxy) writeLog(ctx context.Context, entry *models.RequestLog) {
+	entry.TraceID = tracing.TraceID(ctx)
+	ctx, span := tracer.Start(ctx, "RequestLog.write", trace.WithAttributes(attribute.Bool("log.async", p.writer != nil)))
+	defer span.End()
+
+	if p.writer != nil {
+		p.writer.AddLog(entry)
+		return
+	}
+	_ = p.logs.Create(ctx, entry)
+}
+
+func (p *TavilyProxy) writeAttempt(ctx context.Context, attempt *models.RequestAttempt) {
+	if p.writer != nil {
+		p.writer.AddAttempt(attempt)
+		return
+	}
+	_ = p.logs.CreateAttempt(ctx, attempt)
+}
+
+func (p *TavilyProxy) writeStat(ctx context.Context, ev StatEvent) {
+	if p.writer != nil {
+		p.writer.AddStat(ev)
+		return
+	}
+	_ = p.stats.RecordRequest(ctx, ev)
+}
+
 func (p *TavilyProxy) isRequestLoggingEnabled(ctx context.Context) bool {
 	if p.settings == nil {
 		return true
@@ -78,145 +159,841 @@ func (p *TavilyProxy) isRequestLoggingEnabled(ctx context.Context) bool {
 	return enabled
 }
 
+func (p *TavilyProxy) isCoalescingEnabled(ctx context.Context) bool {
+	if p.settings == nil {
+		return false
+	}
+	enabled, err := p.settings.GetBool(ctx, SettingCoalescingEnabled, false)
+	if err != nil {
+		return false
+	}
+	return enabled
+}
+
+// maxLogBytes is the default cap on how much of a request or response body is
+// stored on a log row; LogRedactionPolicy.CaptureBytes overrides it.
+const maxLogBytes = 32 * 1024
+
+// proxyCall holds the per-request state shared by Do and DoStream.
+type proxyCall struct {
+	req              ProxyRequest
+	id               string
+	loggingEnabled   bool
+	captureBodies    bool
+	redactor         *logRedactor
+	requestBody      string
+	requestTruncated bool
+
+	// coalesced marks a caller that reused another caller's in-flight upstream
+	// request; it is logged but never charged.
+	coalesced bool
+
+	cacheKey     string
+	cacheTTL     time.Duration
+	cacheBackend ResponseCacheBackend
+
+	// attempt and hedged describe the upstream call whose answer was returned.
+	attempt int
+	hedged  bool
+}
+
+func (p *TavilyProxy) newCall(ctx context.Context, req ProxyRequest) *proxyCall {
+	call := &proxyCall{
+		req:            req,
+		id:             uuid.NewString(),
+		loggingEnabled: p.logs != nil && p.isRequestLoggingEnabled(ctx),
+	}
+	if call.loggingEnabled {
+		call.redactor = p.logRedactor(ctx)
+		call.captureBodies = call.redactor.captures(req)
+	}
+	if call.captureBodies {
+		call.requestBody, call.requestTruncated = call.redactor.body(req.Body)
+	}
+	return call
+}
+
+const (
+	CacheStatusHit  = "HIT"
+	CacheStatusMiss = "MISS"
+)
+
 func (p *TavilyProxy) Do(ctx context.Context, req ProxyRequest) (ProxyResponse, error) {
-	const maxLogBytes = 32 * 1024
+	ctx, span := tracer.Start(ctx, "TavilyProxy.Do", trace.WithAttributes(
+		attribute.String("http.request.method", req.Method),
+		attribute.String("tavily.endpoint", req.Path),
+	))
+	defer span.End()
 
-	proxyReqID := uuid.NewString()
+	resp, err := p.do(ctx, req)
+	span.SetAttributes(attribute.String("proxy.request_id", resp.ProxyRequestID))
+	if resp.CacheStatus != "" {
+		span.SetAttributes(attribute.String("proxy.cache", resp.CacheStatus))
+	}
+	setSpanOutcome(span, resp.StatusCode, err)
+	return resp, err
+}
 
-	loggingEnabled := p.logs != nil && p.isRequestLoggingEnabled(ctx)
-	captureBodies := strings.EqualFold(req.Method, http.MethodPost) && req.Path == "/search"
-	requestBody, requestTruncated := "", false
-	if loggingEnabled && captureBodies && len(req.Body) > 0 {
-		requestBody, requestTruncated = truncateForLog(req.Body, maxLogBytes)
+func (p *TavilyProxy) do(ctx context.Context, req ProxyRequest) (ProxyResponse, error) {
+	call := p.newCall(ctx, req)
+
+	call.cacheKey, call.cacheTTL, call.cacheBackend = p.cache.Lookup(ctx, req)
+	if call.cacheKey != "" {
+		start := time.Now()
+		if cached, ok, err := call.cacheBackend.Get(ctx, call.cacheKey); err == nil && ok {
+			p.recordCacheHit(ctx, call, cached, time.Since(start).Milliseconds())
+			return ProxyResponse{
+				StatusCode:      cached.StatusCode,
+				Headers:         cached.Headers.Clone(),
+				Body:            cached.Body,
+				ProxyRequestID:  call.id,
+				TavilyRequestID: cached.TavilyRequestID,
+				CacheStatus:     CacheStatusHit,
+			}, nil
+		}
 	}
 
-	candidates, err := p.keys.Candidates(ctx)
+	if !p.isCoalescingEnabled(ctx) {
+		resp, _, err := p.forward(ctx, call)
+		return resp, err
+	}
+
+	// Identical concurrent requests share the first caller's upstream call. The
+	// shared call is detached from the first caller's cancellation, so callers
+	// waiting on it still get an answer if that caller goes away; the retry
+	// deadline and upstream timeout still bound it.
+	start := time.Now()
+	flightCtx := context.WithoutCancel(ctx)
+	result, shared := p.flights.Do(ctx, requestFingerprint(req), func() flightResult {
+		resp, key, err := p.forward(flightCtx, call)
+		return flightResult{resp: resp, key: key, err: err}
+	})
+	if !shared {
+		return result.resp, result.err
+	}
+	return p.finishCoalesced(ctx, call, result, time.Since(start).Milliseconds())
+}
+
+// attemptResult is one upstream call made while forwarding a request.
+type attemptResult struct {
+	key     models.APIKey
+	number  int
+	hedged  bool
+	started time.Time
+
+	resp        ProxyResponse
+	status      int
+	latencyMs   int64
+	tavilyReqID string
+	err         error
+}
+
+type attemptOutcome int
+
+// Outcomes stored on models.RequestAttempt.
+const (
+	AttemptOutcomeReturned  = "returned"
+	AttemptOutcomeFailover  = "failover"
+	AttemptOutcomeRetried   = "retried"
+	AttemptOutcomeError     = "error"
+	AttemptOutcomeCancelled = "cancelled"
+)
+
+const (
+	attemptFinal attemptOutcome = iota
+	attemptFailover
+	attemptRetry
+)
+
+// forward sends the request upstream, failing over across candidate keys under
+// the configured retry policy, and returns the response together with the key
+// that produced it. With hedging enabled, a second key is raced against a slow
+// first attempt and the first usable answer wins.
+func (p *TavilyProxy) forward(ctx context.Context, call *proxyCall) (ProxyResponse, *models.APIKey, error) {
+	req := call.req
+	policy, _ := p.RetryPolicy(ctx)
+
+	// Upstream calls share a context bounded by the retry deadline; logging and
+	// accounting keep using ctx so they still run once the deadline has passed.
+	upstreamCtx, cancelUpstream := context.WithCancel(ctx)
+	if policy.DeadlineMs > 0 {
+		upstreamCtx, cancelUpstream = context.WithTimeout(ctx, time.Duration(policy.DeadlineMs)*time.Millisecond)
+	}
+	defer cancelUpstream()
+
+	candidates, err := p.candidates(ctx)
 	if err != nil {
-		return ProxyResponse{}, err
+		return ProxyResponse{}, nil, err
 	}
 
 	if len(candidates) == 0 {
-		if captureBodies {
-			createdAt := time.Now()
-			if loggingEnabled {
-				_ = p.logs.Create(ctx, &models.RequestLog{
-					RequestID:         proxyReqID,
-					KeyUsed:           0,
-					KeyAlias:          "",
-					Endpoint:          req.Path,
-					StatusCode:        http.StatusServiceUnavailable,
-					LatencyMs:         0,
-					RequestBody:       requestBody,
-					RequestTruncated:  requestTruncated,
-					ResponseBody:      `{"error":"no_available_keys","message":"No active Tavily API keys with remaining quota."}`,
-					ResponseTruncated: false,
-					ClientIP:          req.ClientIP,
-					CreatedAt:         createdAt,
-				})
+		p.recordFailure(ctx, call, http.StatusServiceUnavailable, noAvailableKeysBody)
+		return ProxyResponse{}, nil, ErrNoAvailableKeys
+	}
+
+	results := make(chan attemptResult, len(candidates))
+	inflight := make(map[int]attemptResult)
+	next, made := 0, 0
+	launch := func(hedged bool) bool {
+		for next < len(candidates) && policy.attemptsLeft(made) && upstreamCtx.Err() == nil {
+			key := candidates[next]
+			next++
+			if !p.keys.AllowRequest(key) {
+				continue
 			}
-			if p.stats != nil {
-				_ = p.stats.RecordRequest(ctx, req.Path, createdAt)
+			made++
+			pending := attemptResult{key: key, number: made, hedged: hedged, started: time.Now()}
+			inflight[pending.number] = pending
+			go func() {
+				res := pending
+				attemptCtx, span := startAttemptSpan(upstreamCtx, key, res.number, hedged)
+				res.resp, res.status, res.latencyMs, res.tavilyReqID, res.err = p.tryKey(attemptCtx, key.ID, key.Key, req, call.id)
+				setSpanOutcome(span, res.status, res.err)
+				span.End()
+				results <- res
+			}()
+			return true
+		}
+		return false
+	}
+
+	hedgeAfter := policy.hedgeDelay(req)
+	var lastErr error
+	var fallback *attemptResult
+
+	launch(false)
+	for len(inflight) > 0 {
+		var hedge *time.Timer
+		var hedgeC <-chan time.Time
+		canHedge := next < len(candidates) && policy.attemptsLeft(made) && upstreamCtx.Err() == nil
+		if hedgeAfter > 0 && len(inflight) == 1 && canHedge {
+			for _, only := range inflight {
+				hedge = time.NewTimer(time.Until(only.started.Add(hedgeAfter)))
+				hedgeC = hedge.C
+			}
+		}
+
+		select {
+		case res := <-results:
+			delete(inflight, res.number)
+			switch p.settleAttempt(ctx, upstreamCtx, res, policy) {
+			case attemptFinal:
+				cancelUpstream()
+				if fallback != nil {
+					p.recordAttempt(ctx, call, *fallback, AttemptOutcomeRetried)
+				}
+				for _, loser := range inflight {
+					loser.latencyMs = time.Since(loser.started).Milliseconds()
+					loser.err = errors.New("another attempt answered first")
+					p.keys.ReleaseAttempt(loser.key.ID)
+					p.recordAttempt(ctx, call, loser, AttemptOutcomeCancelled)
+				}
+				if hedge != nil {
+					hedge.Stop()
+				}
+				return p.finishForward(ctx, call, res)
+			case attemptRetry:
+				if fallback != nil {
+					p.recordAttempt(ctx, call, *fallback, AttemptOutcomeRetried)
+				}
+				r := res
+				fallback = &r
+			case attemptFailover:
+				outcome := AttemptOutcomeFailover
+				if res.err != nil {
+					lastErr = res.err
+					outcome = AttemptOutcomeError
+					if upstreamCtx.Err() != nil {
+						outcome = AttemptOutcomeCancelled
+					}
+				}
+				p.recordAttempt(ctx, call, res, outcome)
+			}
+			if len(inflight) == 0 {
+				launch(false)
 			}
+		case <-hedgeC:
+			launch(true)
+		}
+		if hedge != nil {
+			hedge.Stop()
+		}
+	}
+
+	// Retries ran out: pass the last retryable answer through as before.
+	if fallback != nil {
+		return p.finishForward(ctx, call, *fallback)
+	}
+
+	if errors.Is(upstreamCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+		p.recordFailure(ctx, call, http.StatusGatewayTimeout, ErrRetryDeadlineExceeded.Error())
+		return ProxyResponse{}, nil, ErrRetryDeadlineExceeded
+	}
+
+	switch {
+	case lastErr != nil:
+		p.recordFailure(ctx, call, http.StatusBadGateway, lastErr.Error())
+	case made == 0:
+		// Every candidate was refused by its breaker or rate limit.
+		p.recordFailure(ctx, call, http.StatusServiceUnavailable, noAvailableKeysBody)
+	}
+
+	return ProxyResponse{}, nil, ErrNoAvailableKeys
+}
+
+// settleAttempt applies the key-health and key-state side effects of an
+// attempt and decides whether its answer goes back to the client.
+func (p *TavilyProxy) settleAttempt(ctx, upstreamCtx context.Context, res attemptResult, policy RetryPolicy) attemptOutcome {
+	if res.err != nil {
+		// Attempts cut short by the deadline or a winning hedge say nothing
+		// about the key.
+		if upstreamCtx.Err() == nil {
+			p.keys.ReportFailure(res.key.ID)
+		} else {
+			p.keys.ReleaseAttempt(res.key.ID)
+		}
+		return attemptFailover
+	}
+	p.keys.ObserveLatency(res.key.ID, res.latencyMs)
+	p.reportHealth(res.key.ID, res.status)
+
+	if p.shouldFailover(ctx, res.key, res.status) {
+		return attemptFailover
+	}
+	if policy.retryable(res.status) {
+		return attemptRetry
+	}
+	return attemptFinal
+}
+
+// finishForward charges, logs and caches the attempt whose answer is returned.
+func (p *TavilyProxy) finishForward(ctx context.Context, call *proxyCall, res attemptResult) (ProxyResponse, *models.APIKey, error) {
+	req := call.req
+	resp := res.resp
+	call.attempt = res.number
+	call.hedged = res.hedged
+
+	p.recordAttempt(ctx, call, res, AttemptOutcomeReturned)
+	p.recordResult(ctx, call, res.key, res.status, res.latencyMs, resp.Body)
+
+	if call.cacheKey != "" {
+		if res.status == http.StatusOK {
+			_ = call.cacheBackend.Set(ctx, call.cacheKey, req.Path, CachedResponse{
+				StatusCode:      res.status,
+				Headers:         resp.Headers.Clone(),
+				Body:            resp.Body,
+				TavilyRequestID: res.tavilyReqID,
+				ExpiresAt:       time.Now().Add(call.cacheTTL),
+			})
+		}
+		resp.CacheStatus = CacheStatusMiss
+	}
+
+	resp.ProxyRequestID = call.id
+	resp.TavilyRequestID = res.tavilyReqID
+	key := res.key
+	return resp, &key, nil
+}
+
+// finishCoalesced logs a caller that waited on another caller's upstream
+// request and hands it its own copy of the shared response.
+func (p *TavilyProxy) finishCoalesced(ctx context.Context, call *proxyCall, result flightResult, waitedMs int64) (ProxyResponse, error) {
+	call.coalesced = true
+	if result.err != nil {
+		switch {
+		case errors.Is(result.err, ErrNoAvailableKeys):
+			p.recordFailure(ctx, call, http.StatusServiceUnavailable, noAvailableKeysBody)
+		case errors.Is(result.err, ErrRetryDeadlineExceeded):
+			p.recordFailure(ctx, call, http.StatusGatewayTimeout, ErrRetryDeadlineExceeded.Error())
+		}
+		return ProxyResponse{}, result.err
+	}
+
+	p.recordResult(ctx, call, *result.key, result.resp.StatusCode, waitedMs, result.resp.Body)
+
+	resp := result.resp
+	resp.Headers = resp.Headers.Clone()
+	resp.ProxyRequestID = call.id
+	return resp, nil
+}
+
+// StreamResponse is an upstream response whose body is still being received.
+// Closing Body finishes accounting and writes the request log.
+type StreamResponse struct {
+	StatusCode     int
+	Headers        http.Header
+	Body           io.ReadCloser
+	ProxyRequestID string
+}
+
+// DoStream proxies a request like Do, but hands the upstream body to the caller
+// as it arrives instead of buffering it. Failover is decided from the status
+// line alone; only the first maxLogBytes of the body are kept for the log.
+func (p *TavilyProxy) DoStream(ctx context.Context, req ProxyRequest) (*StreamResponse, error) {
+	// The span covers key selection and the upstream status line; the body
+	// is streamed after it ends.
+	ctx, span := tracer.Start(ctx, "TavilyProxy.DoStream", trace.WithAttributes(
+		attribute.String("http.request.method", req.Method),
+		attribute.String("tavily.endpoint", req.Path),
+	))
+	defer span.End()
+
+	resp, err := p.doStream(ctx, req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+		span.SetAttributes(attribute.String("proxy.request_id", resp.ProxyRequestID))
+	}
+	setSpanOutcome(span, status, err)
+	return resp, err
+}
+
+func (p *TavilyProxy) doStream(ctx context.Context, req ProxyRequest) (*StreamResponse, error) {
+	call := p.newCall(ctx, req)
+
+	candidates, err := p.candidates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		p.recordFailure(ctx, call, http.StatusServiceUnavailable, noAvailableKeysBody)
+		return nil, ErrNoAvailableKeys
+	}
+
+	// A captured stream that exceeds the limit cannot be parsed for field
+	// drops, so the redactor stores it as fully redacted.
+	captureLimit := 0
+	if call.captureBodies {
+		captureLimit = call.redactor.captureBytes
+	}
+
+	// Streams follow the retry statuses and attempt cap, but are never hedged
+	// and have no overall deadline since the body outlives this call.
+	policy, _ := p.RetryPolicy(ctx)
+
+	// stream hands an upstream answer to the client; the attempt is logged
+	// once the client closes the body.
+	stream := func(key models.APIKey, number int, start time.Time, upstreamResp *http.Response) *StreamResponse {
+		status := upstreamResp.StatusCode
+		call.attempt = number
+		body := newCaptureBody(upstreamResp.Body, captureLimit, func(captured []byte) {
+			p.recordResult(context.WithoutCancel(ctx), call, key, status, time.Since(start).Milliseconds(), captured)
+		})
+		return &StreamResponse{
+			StatusCode:     status,
+			Headers:        upstreamResp.Header.Clone(),
+			Body:           body,
+			ProxyRequestID: call.id,
+		}
+	}
+
+	// fallback is the last retryable answer, kept open and passed through when
+	// no later attempt does better.
+	type streamFallback struct {
+		attempt attemptResult
+		start   time.Time
+		resp    *http.Response
+	}
+	var fallback *streamFallback
+	dropFallback := func() {
+		if fallback != nil {
+			_ = fallback.resp.Body.Close()
+			p.recordAttempt(ctx, call, fallback.attempt, AttemptOutcomeRetried)
+			fallback = nil
 		}
-		return ProxyResponse{}, ErrNoAvailableKeys
 	}
 
 	var lastErr error
+	made := 0
 	for _, key := range candidates {
-		resp, status, latencyMs, tavilyReqID, err := p.tryKey(ctx, key.ID, key.Key, req, proxyReqID)
-
+		if !policy.attemptsLeft(made) {
+			break
+		}
+		if !p.keys.AllowRequest(key) {
+			continue
+		}
+		made++
+		start := time.Now()
+		attemptCtx, span := startAttemptSpan(ctx, key, made, false)
+		upstreamResp, latencyMs, err := p.openStream(attemptCtx, key.Key, req, call.id)
 		if err != nil {
+			setSpanOutcome(span, 0, err)
+			span.End()
+			attempt := attemptResult{key: key, number: made, latencyMs: latencyMs, err: err}
 			lastErr = err
+			if ctx.Err() != nil {
+				// The client went away, which says nothing about the key.
+				p.keys.ReleaseAttempt(key.ID)
+				p.recordAttempt(ctx, call, attempt, AttemptOutcomeCancelled)
+				dropFallback()
+				break
+			}
+			p.keys.ReportFailure(key.ID)
+			p.recordAttempt(ctx, call, attempt, AttemptOutcomeError)
 			continue
 		}
+		p.keys.ObserveLatency(key.ID, latencyMs)
+		p.reportHealth(key.ID, upstreamResp.StatusCode)
 
-		switch status {
-		case http.StatusUnauthorized:
-			_ = p.keys.MarkInvalid(ctx, key.ID)
+		status := upstreamResp.StatusCode
+		setSpanOutcome(span, status, nil)
+		span.End()
+		attempt := attemptResult{key: key, number: made, status: status, latencyMs: latencyMs}
+		if p.shouldFailover(ctx, key, status) {
+			_ = upstreamResp.Body.Close()
+			p.recordAttempt(ctx, call, attempt, AttemptOutcomeFailover)
 			continue
-		case http.StatusTooManyRequests, 432, 433:
-			_ = p.keys.MarkExhausted(ctx, key.ID)
+		}
+		if policy.retryable(status) {
+			dropFallback()
+			fallback = &streamFallback{attempt: attempt, start: start, resp: upstreamResp}
 			continue
 		}
+		dropFallback()
+		p.recordAttempt(ctx, call, attempt, AttemptOutcomeReturned)
+		return stream(key, made, start, upstreamResp), nil
+	}
 
-		if status == http.StatusOK && !strings.EqualFold(req.Method, http.MethodGet) {
-			_ = p.keys.IncrementUsed(ctx, key.ID)
-		}
+	// Retries ran out: pass the last retryable answer through.
+	if fallback != nil {
+		p.recordAttempt(ctx, call, fallback.attempt, AttemptOutcomeReturned)
+		return stream(fallback.attempt.key, fallback.attempt.number, fallback.start, fallback.resp), nil
+	}
 
-		createdAt := time.Now()
-		if loggingEnabled {
-			if captureBodies {
-				responseBody, responseTruncated := truncateForLog(resp.Body, maxLogBytes)
-				_ = p.logs.Create(ctx, &models.RequestLog{
-					RequestID:         proxyReqID,
-					KeyUsed:           key.ID,
-					KeyAlias:          key.Alias,
-					Endpoint:          req.Path,
-					StatusCode:        status,
-					LatencyMs:         latencyMs,
-					RequestBody:       requestBody,
-					RequestTruncated:  requestTruncated,
-					ResponseBody:      responseBody,
-					ResponseTruncated: responseTruncated,
-					ClientIP:          req.ClientIP,
-					CreatedAt:         createdAt,
-				})
-			} else {
-				_ = p.logs.Create(ctx, &models.RequestLog{
-					RequestID:  proxyReqID,
-					KeyUsed:    key.ID,
-					KeyAlias:   key.Alias,
-					Endpoint:   req.Path,
-					StatusCode: status,
-					LatencyMs:  latencyMs,
-					ClientIP:   req.ClientIP,
-					CreatedAt:  createdAt,
-				})
-			}
+	switch {
+	case lastErr != nil:
+		p.recordFailure(ctx, call, http.StatusBadGateway, lastErr.Error())
+	case made == 0:
+		p.recordFailure(ctx, call, http.StatusServiceUnavailable, noAvailableKeysBody)
+	}
+
+	return nil, ErrNoAvailableKeys
+}
+
+// StreamingEnabled reports whether responses for path should be streamed to
+// the client rather than buffered.
+func (p *TavilyProxy) StreamingEnabled(ctx context.Context, path string) bool {
+	if p.settings == nil {
+		return false
+	}
+	v, ok, err := p.settings.Get(ctx, SettingStreamingEndpoints)
+	if err != nil || !ok {
+		return false
+	}
+	return endpointListContains(v, path)
+}
+
+const noAvailableKeysBody = `{"error":"no_available_keys","message":"No active Tavily API keys with remaining quota."}`
+
+// shouldFailover applies the key-state side effects of an upstream status and
+// reports whether the next candidate key should be tried. A 429 is a transient
+// rate limit and only cools the key down; 432/433 mean the plan is spent.
+func (p *TavilyProxy) shouldFailover(ctx context.Context, key models.APIKey, status int) bool {
+	switch status {
+	case http.StatusUnauthorized:
+		_ = p.keys.MarkInvalidWithReason(ctx, key.ID, InvalidReasonProxyUnauthorized)
+		return true
+	case http.StatusTooManyRequests:
+		_, _ = p.keys.MarkCooldown(ctx, key.ID)
+		return true
+	case 432, 433:
+		_ = p.keys.MarkExhausted(ctx, key.ID)
+		return true
+	}
+	if key.CooldownStrikes > 0 && status < http.StatusBadRequest {
+		_ = p.keys.ClearCooldown(ctx, key.ID)
+	}
+	return false
+}
+
+// reportHealth feeds an upstream answer into the key's circuit breaker. Only
+// server errors count as failures; a 4xx means the key reached Tavily.
+func (p *TavilyProxy) reportHealth(keyID uint, status int) {
+	if status >= http.StatusInternalServerError {
+		p.keys.ReportFailure(keyID)
+		return
+	}
+	p.keys.ReportSuccess(keyID)
+}
+
+// recordResult charges the key and logs an upstream answer that was passed
+// back to the client.
+func (p *TavilyProxy) recordResult(ctx context.Context, call *proxyCall, key models.APIKey, status int, latencyMs int64, responseBody []byte) {
+	req := call.req
+	credits, estimated := 0, false
+	if status == http.StatusOK && !strings.EqualFold(req.Method, http.MethodGet) && !call.coalesced {
+		credits, estimated = creditCost(req, responseBody)
+		_ = p.keys.IncrementUsed(ctx, key.ID, credits)
+	}
+	p.metrics.ObserveRequest(req.Path, status, key, time.Duration(latencyMs)*time.Millisecond)
+
+	createdAt := time.Now()
+	if call.loggingEnabled {
+		entry := &models.RequestLog{
+			RequestID:        call.id,
+			KeyUsed:          key.ID,
+			KeyAlias:         key.Alias,
+			Endpoint:         req.Path,
+			StatusCode:       status,
+			LatencyMs:        latencyMs,
+			ClientIP:         req.ClientIP,
+			ClientKeyID:      req.ClientKeyID,
+			ClientKeyName:    req.ClientKeyName,
+			Coalesced:        call.coalesced,
+			Credits:          credits,
+			CreditsEstimated: estimated,
+			Attempt:          call.attempt,
+			Hedged:           call.hedged,
+			CreatedAt:        createdAt,
 		}
-		if p.stats != nil {
-			_ = p.stats.RecordRequest(ctx, req.Path, createdAt)
+		if call.captureBodies {
+			entry.RequestBody = call.requestBody
+			entry.RequestTruncated = call.requestTruncated
+			entry.ResponseBody, entry.ResponseTruncated = call.redactor.body(responseBody)
 		}
+		applySearchParams(entry, req)
+		call.redactor.query(entry)
+		p.writeLog(ctx, entry)
+	}
+	if p.stats != nil {
+		p.writeStat(ctx, StatEvent{Endpoint: req.Path, KeyID: key.ID, StatusCode: status, LatencyMs: latencyMs, OccurredAt: createdAt})
+	}
+}
 
-		resp.ProxyRequestID = proxyReqID
-		resp.TavilyRequestID = tavilyReqID
-		return resp, nil
-	}
-
-	if captureBodies && lastErr != nil {
-		createdAt := time.Now()
-		if loggingEnabled {
-			_ = p.logs.Create(ctx, &models.RequestLog{
-				RequestID:         proxyReqID,
-				KeyUsed:           0,
-				KeyAlias:          "",
-				Endpoint:          req.Path,
-				StatusCode:        http.StatusBadGateway,
-				LatencyMs:         0,
-				RequestBody:       requestBody,
-				RequestTruncated:  requestTruncated,
-				ResponseBody:      lastErr.Error(),
-				ResponseTruncated: false,
-				ClientIP:          req.ClientIP,
-				CreatedAt:         createdAt,
-			})
+// maxAttemptErrorBytes caps the upstream error body kept on an attempt row.
+const maxAttemptErrorBytes = 1024
+
+// recordAttempt stores one upstream call made for the request, whether or not
+// its answer was returned to the client.
+func (p *TavilyProxy) recordAttempt(ctx context.Context, call *proxyCall, res attemptResult, outcome string) {
+	switch outcome {
+	case AttemptOutcomeFailover, AttemptOutcomeRetried:
+		p.metrics.ObserveFailover(call.req.Path, strconv.Itoa(res.status))
+	case AttemptOutcomeError:
+		p.metrics.ObserveFailover(call.req.Path, "error")
+	}
+	if !call.loggingEnabled {
+		return
+	}
+	attempt := &models.RequestAttempt{
+		RequestID:  call.id,
+		Attempt:    res.number,
+		KeyID:      res.key.ID,
+		KeyAlias:   res.key.Alias,
+		Endpoint:   call.req.Path,
+		StatusCode: res.status,
+		LatencyMs:  res.latencyMs,
+		Outcome:    outcome,
+		Hedged:     res.hedged,
+		CreatedAt:  time.Now(),
+	}
+	switch {
+	case res.err != nil:
+		attempt.Error = res.err.Error()
+	case res.status >= http.StatusBadRequest && call.captureBodies && !call.redactor.metadataOnly:
+		// Upstream error bodies follow the same capture policy as logs.
+		attempt.Error, _ = truncateForLog([]byte(call.redactor.text(string(res.resp.Body))), maxAttemptErrorBytes)
+	}
+	p.writeAttempt(ctx, attempt)
+}
+
+// recordCacheHit logs a request answered from the response cache. No key is
+// charged for it.
+func (p *TavilyProxy) recordCacheHit(ctx context.Context, call *proxyCall, cached *CachedResponse, latencyMs int64) {
+	req := call.req
+	p.metrics.ObserveRequest(req.Path, cached.StatusCode, models.APIKey{}, time.Duration(latencyMs)*time.Millisecond)
+	createdAt := time.Now()
+	if call.loggingEnabled {
+		entry := &models.RequestLog{
+			RequestID:     call.id,
+			Endpoint:      req.Path,
+			StatusCode:    cached.StatusCode,
+			LatencyMs:     latencyMs,
+			ClientIP:      req.ClientIP,
+			ClientKeyID:   req.ClientKeyID,
+			ClientKeyName: req.ClientKeyName,
+			CacheHit:      true,
+			CreatedAt:     createdAt,
 		}
-		if p.stats != nil {
-			_ = p.stats.RecordRequest(ctx, req.Path, createdAt)
+		if call.captureBodies {
+			entry.RequestBody = call.requestBody
+			entry.RequestTruncated = call.requestTruncated
+			entry.ResponseBody, entry.ResponseTruncated = call.redactor.body(cached.Body)
 		}
+		applySearchParams(entry, req)
+		call.redactor.query(entry)
+		p.writeLog(ctx, entry)
+	}
+	if p.stats != nil {
+		p.writeStat(ctx, StatEvent{Endpoint: req.Path, StatusCode: cached.StatusCode, LatencyMs: latencyMs, OccurredAt: createdAt, CacheHit: true})
 	}
+}
 
-	return ProxyResponse{}, ErrNoAvailableKeys
+// recordFailure logs a request that never got a usable upstream answer.
+func (p *TavilyProxy) recordFailure(ctx context.Context, call *proxyCall, status int, message string) {
+	p.metrics.ObserveRequest(call.req.Path, status, models.APIKey{}, 0)
+	if !call.captureBodies {
+		return
+	}
+	req := call.req
+	createdAt := time.Now()
+	if call.loggingEnabled {
+		entry := &models.RequestLog{
+			RequestID:         call.id,
+			KeyUsed:           0,
+			KeyAlias:          "",
+			Endpoint:          req.Path,
+			StatusCode:        status,
+			LatencyMs:         0,
+			RequestBody:       call.requestBody,
+			RequestTruncated:  call.requestTruncated,
+			ResponseBody:      message,
+			ResponseTruncated: false,
+			ClientIP:          req.ClientIP,
+			ClientKeyID:       req.ClientKeyID,
+			ClientKeyName:     req.ClientKeyName,
+			Coalesced:         call.coalesced,
+			CreatedAt:         createdAt,
+		}
+		applySearchParams(entry, req)
+		call.redactor.query(entry)
+		p.writeLog(ctx, entry)
+	}
+	if p.stats != nil {
+		p.writeStat(ctx, StatEvent{Endpoint: req.Path, StatusCode: status, LatencyMs: -1, OccurredAt: createdAt})
+	}
 }
 
+// truncateForLog cuts data to at most maxBytes, backing up to a rune boundary
+// so a multi-byte character is never split.
 func truncateForLog(data []byte, maxBytes int) (string, bool) {
 	if maxBytes <= 0 || len(data) <= maxBytes {
 		return string(data), false
 	}
-	return string(data[:maxBytes]), true
+	cut := maxBytes
+	for i := 0; i < utf8.UTFMax-1 && cut > 0 && !utf8.RuneStart(data[cut]); i++ {
+		cut--
+	}
+	return string(data[:cut]), true
 }
 
-func (p *TavilyProxy) tryKey(ctx context.Context, keyID uint, tavilyKey string, req ProxyRequest, proxyReqID string) (ProxyResponse, int, int64, string, error) {
+// captureBody tees the first limit bytes read from an upstream body into a
+// buffer and reports them once the body is closed. One byte past the limit is
+// kept so truncateForLog can tell that the body was cut.
+type captureBody struct {
+	rc      io.ReadCloser
+	limit   int
+	buf     bytes.Buffer
+	onClose func(captured []byte)
+	once    sync.Once
+}
+
+func newCaptureBody(rc io.ReadCloser, limit int, onClose func(captured []byte)) *captureBody {
+	return &captureBody{rc: rc, limit: limit, onClose: onClose}
+}
+
+func (b *captureBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	if n > 0 && b.limit > 0 {
+		if room := b.limit + 1 - b.buf.Len(); room > 0 {
+			if room > n {
+				room = n
+			}
+			b.buf.Write(p[:room])
+		}
+	}
+	return n, err
+}
+
+func (b *captureBody) Close() error {
+	err := b.rc.Close()
+	b.once.Do(func() {
+		if b.onClose != nil {
+			b.onClose(b.buf.Bytes())
+		}
+	})
+	return err
+}
+
+// ErrStreamIdle is returned by a streamed body that received nothing from
+// Tavily for longer than the upstream timeout.
+var ErrStreamIdle = errors.New("upstream stream idle timeout")
+
+// idleTimeoutBody cancels a streamed upstream request when no read returns
+// within idle.
+type idleTimeoutBody struct {
+	rc     io.ReadCloser
+	idle   time.Duration
+	timer  *time.Timer
+	fired  atomic.Bool
+	cancel context.CancelFunc
+}
+
+func newIdleTimeoutBody(rc io.ReadCloser, idle time.Duration, cancel context.CancelFunc) *idleTimeoutBody {
+	b := &idleTimeoutBody{rc: rc, idle: idle, cancel: cancel}
+	b.timer = time.AfterFunc(idle, func() {
+		b.fired.Store(true)
+		cancel()
+	})
+	b.timer.Stop()
+	return b
+}
+
+// Read arms the timer only while it waits on Tavily, so time the caller spends
+// writing to a slow client does not count as upstream idleness.
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.idle)
+	n, err := b.rc.Read(p)
+	b.timer.Stop()
+	if err != nil && b.fired.Load() {
+		return n, ErrStreamIdle
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.rc.Close()
+	b.cancel()
+	return err
+}
+
+// openStream opens req like openUpstream for a streamed answer: only the wait
+// for response headers and the gaps between body reads are bounded.
+func (p *TavilyProxy) openStream(ctx context.Context, tavilyKey string, req ProxyRequest, proxyReqID string) (*http.Response, int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	upstreamResp, latencyMs, err := p.send(ctx, p.streamClient, tavilyKey, req, proxyReqID)
+	if err != nil {
+		cancel()
+		return nil, latencyMs, err
+	}
+	if p.streamIdle > 0 {
+		upstreamResp.Body = newIdleTimeoutBody(upstreamResp.Body, p.streamIdle, cancel)
+	} else {
+		upstreamResp.Body = cancelOnClose{ReadCloser: upstreamResp.Body, cancel: cancel}
+	}
+	return upstreamResp, latencyMs, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// openUpstream sends req to Tavily with the given key and returns the response
+// with its body unread, along with the time to response headers.
+func (p *TavilyProxy) openUpstream(ctx context.Context, tavilyKey string, req ProxyRequest, proxyReqID string) (*http.Response, int64, error) {
+	return p.send(ctx, p.client, tavilyKey, req, proxyReqID)
+}
+
+func (p *TavilyProxy) send(ctx context.Context, client *http.Client, tavilyKey string, req ProxyRequest, proxyReqID string) (*http.Response, int64, error) {
 	url := p.baseURL + req.Path
 	if req.RawQuery != "" {
 		url += "?" + req.RawQuery
@@ -224,7 +1001,7 @@ func (p *TavilyProxy) tryKey(ctx context.Context, keyID uint, tavilyKey string,
 
 	upstreamReq, err := http.NewRequestWithContext(ctx, req.Method, url, bytes.NewReader(req.Body))
 	if err != nil {
-		return ProxyResponse{}, 0, 0, "", err
+		return nil, 0, err
 	}
 
 	copyHeaders(upstreamReq.Header, req.Headers)
@@ -234,10 +1011,22 @@ func (p *TavilyProxy) tryKey(ctx context.Context, keyID uint, tavilyKey string,
 		upstreamReq.Header.Set("Content-Type", req.ContentType)
 	}
 	upstreamReq.Header.Set("X-Proxy-Request-Id", proxyReqID)
+	// The upstream hop is a child of this attempt's span, not of the client's.
+	upstreamReq.Header.Del("Traceparent")
+	upstreamReq.Header.Del("Tracestate")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(upstreamReq.Header))
 
 	start := time.Now()
-	upstreamResp, err := p.client.Do(upstreamReq)
+	upstreamResp, err := client.Do(upstreamReq)
 	latencyMs := time.Since(start).Milliseconds()
+	if err != nil {
+		return nil, latencyMs, err
+	}
+	return upstreamResp, latencyMs, nil
+}
+
+func (p *TavilyProxy) tryKey(ctx context.Context, keyID uint, tavilyKey string, req ProxyRequest, proxyReqID string) (ProxyResponse, int, int64, string, error) {
+	upstreamResp, latencyMs, err := p.openUpstream(ctx, tavilyKey, req, proxyReqID)
 	if err != nil {
 		return ProxyResponse{}, 0, latencyMs, "", err
 	}
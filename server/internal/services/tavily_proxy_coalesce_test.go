@@ -0,0 +1,216 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestTavilyProxy_Coalescing_SharesOneUpstreamCall(t *testing.T) {
+	t.Parallel()
+
+	const callers = 5
+
+	var upstreamCalls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&upstreamCalls, 1)
+		time.Sleep(300 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"request_id":"shared","results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	settings := NewSettingsService(database)
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	key, err := keys.Create(ctx, "tvly-test", "test", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if err := settings.SetBool(ctx, SettingCoalescingEnabled, true); err != nil {
+		t.Fatalf("enable coalescing: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger).
+		WithSettings(settings)
+
+	var wg sync.WaitGroup
+	ids := make([]string, callers)
+	errs := make([]error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := proxy.Do(ctx, ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"same"}`)})
+			ids[i], errs[i] = resp.ProxyRequestID, err
+		}(i)
+	}
+	wg.Wait()
+
+	seen := make(map[string]struct{}, callers)
+	for i := 0; i < callers; i++ {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: %v", i, errs[i])
+		}
+		seen[ids[i]] = struct{}{}
+	}
+	if len(seen) != callers {
+		t.Fatalf("each caller should get its own proxy request id, got %d distinct", len(seen))
+	}
+
+	if got := atomic.LoadInt32(&upstreamCalls); got != 1 {
+		t.Fatalf("unexpected upstream calls: got %d want %d", got, 1)
+	}
+	got, err := keys.Get(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("get key: %v", err)
+	}
+	if got.UsedQuota != 1 {
+		t.Fatalf("unexpected used_quota: got %d want %d", got.UsedQuota, 1)
+	}
+
+	var total, coalesced int64
+	if err := database.WithContext(ctx).Model(&models.RequestLog{}).Count(&total).Error; err != nil {
+		t.Fatalf("count logs: %v", err)
+	}
+	if err := database.WithContext(ctx).Model(&models.RequestLog{}).Where("coalesced = ?", true).Count(&coalesced).Error; err != nil {
+		t.Fatalf("count coalesced logs: %v", err)
+	}
+	if total != callers || coalesced != callers-1 {
+		t.Fatalf("unexpected log counts: total=%d coalesced=%d", total, coalesced)
+	}
+}
+
+func TestTavilyProxy_Coalescing_FollowerOutlivesLeader(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		time.Sleep(300 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"results":[]}`))
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	settings := NewSettingsService(database)
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	if _, err := keys.Create(ctx, "tvly-test", "test", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	if err := settings.SetBool(ctx, SettingCoalescingEnabled, true); err != nil {
+		t.Fatalf("enable coalescing: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger).
+		WithSettings(settings)
+	req := ProxyRequest{Method: http.MethodPost, Path: "/search", Body: []byte(`{"query":"same"}`)}
+
+	leaderCtx, cancelLeader := context.WithCancel(ctx)
+	defer cancelLeader()
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		_, _ = proxy.Do(leaderCtx, req)
+	}()
+	<-started
+
+	type outcome struct {
+		resp ProxyResponse
+		err  error
+	}
+	follower := make(chan outcome, 1)
+	go func() {
+		resp, err := proxy.Do(ctx, req)
+		follower <- outcome{resp, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancelLeader()
+
+	got := <-follower
+	if got.err != nil {
+		t.Fatalf("follower: %v", got.err)
+	}
+	if got.resp.StatusCode != http.StatusOK {
+		t.Fatalf("follower status: got %d want %d", got.resp.StatusCode, http.StatusOK)
+	}
+	<-leaderDone
+}
+
+func TestFlightGroup_WaiterStopsOnOwnCancellation(t *testing.T) {
+	t.Parallel()
+
+	var g flightGroup
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go g.Do(context.Background(), "k", func() flightResult {
+		close(started)
+		<-release
+		return flightResult{}
+	})
+	<-started
+	t.Cleanup(func() { close(release) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan flightResult, 1)
+	go func() {
+		result, _ := g.Do(ctx, "k", func() flightResult {
+			t.Errorf("waiter should not run its own call")
+			return flightResult{}
+		})
+		done <- result
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case result := <-done:
+		if !errors.Is(result.err, context.Canceled) {
+			t.Fatalf("waiter error: got %v want %v", result.err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("waiter kept waiting on the leader after its context ended")
+	}
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+	"tavily-proxy/server/internal/models"
+)
+
+func TestTavilyProxy_DoStream_FailsOverAndCapturesLogPrefix(t *testing.T) {
+	t.Parallel()
+
+	payload := bytes.Repeat([]byte("x"), maxLogBytes*2)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer tvly-revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(payload)
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	settings := NewSettingsService(database)
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	revoked, err := keys.Create(ctx, "tvly-revoked", "revoked", 1000)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	good, err := keys.Create(ctx, "tvly-good", "good", 500)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, logs, nil, logger).
+		WithSettings(settings)
+
+	if proxy.StreamingEnabled(ctx, "/search") {
+		t.Fatalf("streaming should be disabled by default")
+	}
+	if err := settings.Set(ctx, SettingStreamingEndpoints, JoinEndpoints([]string{"search"})); err != nil {
+		t.Fatalf("set streaming endpoints: %v", err)
+	}
+	if !proxy.StreamingEnabled(ctx, "/search") {
+		t.Fatalf("streaming should be enabled for /search")
+	}
+
+	resp, err := proxy.DoStream(ctx, ProxyRequest{
+		Method:   http.MethodPost,
+		Path:     "/search",
+		Body:     []byte(`{"query":"hello"}`),
+		ClientIP: "127.0.0.1",
+	})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		t.Fatalf("close body: %v", err)
+	}
+	if !bytes.Equal(body, payload) {
+		t.Fatalf("unexpected body length: got %d want %d", len(body), len(payload))
+	}
+
+	if got, err := keys.Get(ctx, revoked.ID); err != nil || !got.IsInvalid {
+		t.Fatalf("revoked key should be marked invalid (err=%v)", err)
+	}
+	if got, err := keys.Get(ctx, good.ID); err != nil || got.UsedQuota != 1 {
+		t.Fatalf("good key should be charged once (err=%v)", err)
+	}
+
+	var entry models.RequestLog
+	if err := database.WithContext(ctx).First(&entry).Error; err != nil {
+		t.Fatalf("load log: %v", err)
+	}
+	if entry.KeyUsed != good.ID || entry.StatusCode != http.StatusOK || entry.Attempt != 2 {
+		t.Fatalf("unexpected log entry: key=%d status=%d attempt=%d", entry.KeyUsed, entry.StatusCode, entry.Attempt)
+	}
+	attempts, err := logs.ListAttempts(ctx, entry.RequestID)
+	if err != nil {
+		t.Fatalf("list attempts: %v", err)
+	}
+	if len(attempts) != 2 || attempts[0].KeyID != revoked.ID || attempts[0].Outcome != AttemptOutcomeFailover || attempts[1].Outcome != AttemptOutcomeReturned {
+		t.Fatalf("unexpected attempts: %+v", attempts)
+	}
+	if len(entry.ResponseBody) != maxLogBytes || !entry.ResponseTruncated {
+		t.Fatalf("unexpected captured response: len=%d truncated=%v", len(entry.ResponseBody), entry.ResponseTruncated)
+	}
+}
+
+func TestTavilyProxy_DoStream_OutlivesUpstreamTimeout(t *testing.T) {
+	t.Parallel()
+
+	const chunks = 6
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < chunks; i++ {
+			_, _ = w.Write([]byte("data: {}\n\n"))
+			w.(http.Flusher).Flush()
+			if r.URL.Query().Get("stall") != "" && i == 1 {
+				time.Sleep(600 * time.Millisecond)
+			} else {
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	logs := NewLogService(database, logger)
+
+	ctx := context.Background()
+	if _, err := keys.Create(ctx, "tvly-test", "test", 1000); err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+
+	// The whole stream takes longer than the timeout, but no gap does.
+	proxy := NewTavilyProxy(upstream.URL, 300*time.Millisecond, keys, logs, nil, logger)
+	resp, err := proxy.DoStream(ctx, ProxyRequest{Method: http.MethodPost, Path: "/research", Body: []byte(`{"input":"q"}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if got := bytes.Count(body, []byte("data:")); got != chunks {
+		t.Fatalf("streamed events: got %d want %d", got, chunks)
+	}
+
+	// A client slower than the timeout does not make Tavily look idle.
+	resp, err = proxy.DoStream(ctx, ProxyRequest{Method: http.MethodPost, Path: "/research", Body: []byte(`{"input":"q"}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	buf := make([]byte, 1)
+	if _, err := resp.Body.Read(buf); err != nil {
+		t.Fatalf("read first byte: %v", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	body, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read after a slow client: %v", err)
+	}
+	if got := bytes.Count(append(buf, body...), []byte("data:")); got != chunks {
+		t.Fatalf("streamed events to a slow client: got %d want %d", got, chunks)
+	}
+
+	resp, err = proxy.DoStream(ctx, ProxyRequest{Method: http.MethodPost, Path: "/research", RawQuery: "stall=1", Body: []byte(`{"input":"q"}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	_, err = io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if !errors.Is(err, ErrStreamIdle) {
+		t.Fatalf("stalled stream: got %v want %v", err, ErrStreamIdle)
+	}
+}
+
+func TestTavilyProxy_DoStream_KeepsRetryableAnswerAsFallback(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":"busy"}`))
+			return
+		}
+		// The retry never gets an answer.
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+	}))
+	t.Cleanup(upstream.Close)
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	keys := NewKeyService(database, logger)
+	ctx := context.Background()
+	for _, name := range []string{"a", "b"} {
+		if _, err := keys.Create(ctx, "tvly-"+name, name, 1000); err != nil {
+			t.Fatalf("create key: %v", err)
+		}
+	}
+
+	proxy := NewTavilyProxy(upstream.URL, 5*time.Second, keys, nil, nil, logger)
+	resp, err := proxy.DoStream(ctx, ProxyRequest{Method: http.MethodPost, Path: "/research", Body: []byte(`{"input":"q"}`)})
+	if err != nil {
+		t.Fatalf("proxy request: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || string(body) != `{"error":"busy"}` {
+		t.Fatalf("fallback answer: got %d %q", resp.StatusCode, body)
+	}
+	if got := calls.Load(); got != 2 {
+		t.Fatalf("upstream calls: got %d want %d", got, 2)
+	}
+}
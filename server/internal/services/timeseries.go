@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"tavily-proxy/server/internal/models"
+)
+
+var (
+	ErrInvalidGranularity = errors.New("invalid granularity")
+	ErrTimeSeriesTooLong  = errors.New("time series has too many points")
+)
+
+const (
+	// maxTimeSeriesPoints bounds custom windows; it is about 83 days of hours.
+	maxTimeSeriesPoints = 2000
+
+	// TimeSeriesAllEndpoints asks TimeSeries for the overall series plus one
+	// series per endpoint that saw traffic in the window.
+	TimeSeriesAllEndpoints = "all"
+)
+
+type TimeSeriesSeries struct {
+	Name     string  `json:"name"`
+	Endpoint string  `json:"endpoint"`
+	Data     []int64 `json:"data"`
+	// ErrorRate is the share of 4xx and 5xx answers in each bucket, and
+	// AvgLatencyMs the mean latency; both are null for buckets with nothing
+	// to measure.
+	ErrorRate    []*float64 `json:"error_rate"`
+	AvgLatencyMs []*float64 `json:"avg_latency_ms"`
+}
+
+type TimeSeries struct {
+	Granularity string             `json:"granularity"`
+	Timezone    string             `json:"timezone"`
+	From        time.Time          `json:"from"`
+	To          time.Time          `json:"to"`
+	Labels      []string           `json:"labels"`
+	Series      []TimeSeriesSeries `json:"series"`
+}
+
+// TimeSeriesQuery selects the window and series TimeSeries returns.
+type TimeSeriesQuery struct {
+	// Granularity is the bucket size: hour (the default), day or month.
+	Granularity string
+	// From and To bound a custom window of the buckets starting in [From, To),
+	// cut in the reporting timezone. To defaults to now. Without From the
+	// window is the last 24 hours, 30 days or 12 months up to To.
+	From time.Time
+	To   time.Time
+	// Endpoints lists the series to return: "" for all requests or an endpoint
+	// path such as "/search". TimeSeriesAllEndpoints returns all requests and
+	// every endpoint. It defaults to all requests and /search.
+	Endpoints []string
+}
+
+// statGranularity describes one bucket size.
+type statGranularity struct {
+	name        string
+	truncate    func(time.Time) time.Time
+	shift       func(t time.Time, n int) time.Time
+	bucketKey   func(time.Time) string
+	labelFormat func(time.Time) string
+	// defaultPoints is the length of the window used when no range is given.
+	defaultPoints int
+}
+
+var statGranularities = map[string]statGranularity{
+	"hour": {
+		name: "hour",
+		truncate: func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+		},
+		shift:         func(t time.Time, n int) time.Time { return t.Add(time.Duration(n) * time.Hour) },
+		bucketKey:     func(t time.Time) string { return t.Format("2006-01-02 15:00") },
+		labelFormat:   func(t time.Time) string { return t.Format("01-02 15:00") },
+		defaultPoints: 24,
+	},
+	"day": {
+		name:          "day",
+		truncate:      func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()) },
+		shift:         func(t time.Time, n int) time.Time { return t.AddDate(0, 0, n) },
+		bucketKey:     func(t time.Time) string { return t.Format("2006-01-02") },
+		labelFormat:   func(t time.Time) string { return t.Format("01-02") },
+		defaultPoints: 30,
+	},
+	"month": {
+		name:          "month",
+		truncate:      func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location()) },
+		shift:         func(t time.Time, n int) time.Time { return t.AddDate(0, n, 0) },
+		bucketKey:     func(t time.Time) string { return t.Format("2006-01") },
+		labelFormat:   func(t time.Time) string { return t.Format("2006-01") },
+		defaultPoints: 12,
+	},
+}
+
+func lookupGranularity(name string) (statGranularity, error) {
+	if name == "" {
+		name = "hour"
+	}
+	g, ok := statGranularities[name]
+	if !ok {
+		return statGranularity{}, ErrInvalidGranularity
+	}
+	return g, nil
+}
+
+// statWindow is the run of buckets a time series covers, oldest first.
+type statWindow struct {
+	statGranularity
+	start  time.Time
+	points int
+	// fullLabels labels buckets with their full key, for custom windows that
+	// may span years.
+	fullLabels bool
+}
+
+// newStatWindow returns the default window of granularity ending with the
+// bucket that contains now.
+func newStatWindow(granularity string, now time.Time) (statWindow, error) {
+	g, err := lookupGranularity(granularity)
+	if err != nil {
+		return statWindow{}, err
+	}
+	return statWindow{
+		statGranularity: g,
+		start:           g.shift(g.truncate(now), -(g.defaultPoints - 1)),
+		points:          g.defaultPoints,
+	}, nil
+}
+
+// newStatRangeWindow returns the buckets of granularity starting in [from, to).
+func newStatRangeWindow(granularity string, from, to time.Time) (statWindow, error) {
+	g, err := lookupGranularity(granularity)
+	if err != nil {
+		return statWindow{}, err
+	}
+	w := statWindow{statGranularity: g, start: g.truncate(from), fullLabels: true}
+	for t := w.start; t.Before(to); t = g.shift(t, 1) {
+		w.points++
+		if w.points > maxTimeSeriesPoints {
+			return statWindow{}, ErrTimeSeriesTooLong
+		}
+	}
+	return w, nil
+}
+
+// end is the first instant after the window.
+func (w statWindow) end() time.Time {
+	return w.shift(w.start, w.points)
+}
+
+func (w statWindow) labels() []string {
+	labels := make([]string, 0, w.points)
+	for i := 0; i < w.points; i++ {
+		t := w.shift(w.start, i)
+		if w.fullLabels {
+			labels = append(labels, w.bucketKey(t))
+		} else {
+			labels = append(labels, w.labelFormat(t))
+		}
+	}
+	return labels
+}
+
+// fill lays counts out over the window's buckets.
+func (w statWindow) fill(counts map[string]int64) []int64 {
+	data := make([]int64, 0, w.points)
+	for i := 0; i < w.points; i++ {
+		data = append(data, counts[w.bucketKey(w.shift(w.start, i))])
+	}
+	return data
+}
+
+// series lays rows out over the window's buckets as counts, error rates and
+// average latencies.
+func (w statWindow) series(rows map[string]models.RequestStat) TimeSeriesSeries {
+	out := TimeSeriesSeries{
+		Data:         make([]int64, 0, w.points),
+		ErrorRate:    make([]*float64, 0, w.points),
+		AvgLatencyMs: make([]*float64, 0, w.points),
+	}
+	for i := 0; i < w.points; i++ {
+		r := rows[w.bucketKey(w.shift(w.start, i))]
+		out.Data = append(out.Data, r.Count)
+
+		var errorRate, avgLatency *float64
+		if classified := r.Status2xx + r.Status3xx + r.Status4xx + r.Status5xx; classified > 0 {
+			v := roundTo(float64(r.Status4xx+r.Status5xx)/float64(classified), 4)
+			errorRate = &v
+		}
+		if r.LatencySamples > 0 {
+			v := roundTo(float64(r.LatencySumMs)/float64(r.LatencySamples), 1)
+			avgLatency = &v
+		}
+		out.ErrorRate = append(out.ErrorRate, errorRate)
+		out.AvgLatencyMs = append(out.AvgLatencyMs, avgLatency)
+	}
+	return out
+}
+
+func (s *StatsService) TimeSeries(ctx context.Context, q TimeSeriesQuery) (TimeSeries, error) {
+	to := q.To
+	if to.IsZero() {
+		to = time.Now()
+	}
+	to = to.In(s.loc)
+	var (
+		w   statWindow
+		err error
+	)
+	if q.From.IsZero() {
+		w, err = newStatWindow(q.Granularity, to)
+	} else {
+		w, err = newStatRangeWindow(q.Granularity, q.From.In(s.loc), to)
+	}
+	if err != nil {
+		return TimeSeries{}, err
+	}
+
+	endpoints := q.Endpoints
+	if len(endpoints) == 0 {
+		endpoints = []string{"", "/search"}
+	}
+	all := false
+	for _, ep := range endpoints {
+		if ep == TimeSeriesAllEndpoints {
+			all = true
+		}
+	}
+
+	// A repeated DST hour, and an hour written before bucket_start was added
+	// next to one written after, are separate rows under one bucket.
+	sums := "SUM(count) as count, SUM(cache_hits) as cache_hits" +
+		", SUM(status_2xx) as status_2xx, SUM(status_3xx) as status_3xx" +
+		", SUM(status_4xx) as status_4xx, SUM(status_5xx) as status_5xx" +
+		", SUM(latency_sum_ms) as latency_sum_ms, SUM(latency_samples) as latency_samples"
+	var rows []models.RequestStat
+	query := totalsOnly(s.db.WithContext(ctx).Model(&models.RequestStat{})).
+		Select("endpoint, bucket, "+sums).
+		Where("granularity = ? AND bucket >= ? AND bucket < ?", w.name, w.bucketKey(w.start), w.bucketKey(w.end()))
+	if !all {
+		query = query.Where("endpoint IN ?", endpoints)
+	}
+	if err := query.Group("endpoint, bucket").Order("endpoint, bucket").Scan(&rows).Error; err != nil {
+		return TimeSeries{}, err
+	}
+	byEndpoint := make(map[string]map[string]models.RequestStat)
+	for _, r := range rows {
+		if byEndpoint[r.Endpoint] == nil {
+			byEndpoint[r.Endpoint] = make(map[string]models.RequestStat)
+		}
+		byEndpoint[r.Endpoint][r.Bucket] = r
+	}
+	if all {
+		// Rows are ordered by endpoint, so "" comes first.
+		endpoints = []string{""}
+		for _, r := range rows {
+			if r.Endpoint != "" && r.Endpoint != endpoints[len(endpoints)-1] {
+				endpoints = append(endpoints, r.Endpoint)
+			}
+		}
+	}
+
+	out := TimeSeries{
+		Granularity: w.name,
+		Timezone:    s.loc.String(),
+		From:        w.start,
+		To:          w.end(),
+		Labels:      w.labels(),
+		Series:      make([]TimeSeriesSeries, 0, len(endpoints)),
+	}
+	for _, ep := range endpoints {
+		series := w.series(byEndpoint[ep])
+		series.Name = timeSeriesName(ep)
+		series.Endpoint = ep
+		out.Series = append(out.Series, series)
+	}
+	return out, nil
+}
+
+// IsStatEndpoint reports whether endpoint has its own time series.
+func IsStatEndpoint(endpoint string) bool {
+	_, ok := statEndpoints[endpoint]
+	return ok || endpoint == statEndpointOther
+}
+
+func timeSeriesName(endpoint string) string {
+	switch endpoint {
+	case "":
+		return "All Requests"
+	case "/search":
+		return "Search"
+	default:
+		return endpoint
+	}
+}
+
+// bucketCountsFromStats loads the counts of the rows matching dims, whose
+// granularity and bucket fields are ignored, from startBucket on.
+func (s *StatsService) bucketCountsFromStats(ctx context.Context, granularity string, dims statKey, startBucket string) (map[string]int64, error) {
+	type row struct {
+		Bucket string `gorm:"column:bucket"`
+		Count  int64  `gorm:"column:count"`
+	}
+
+	var rows []row
+	if err := s.db.WithContext(ctx).
+		Model(&models.RequestStat{}).
+		Select("bucket, SUM(count) as count").
+		Where("granularity = ? AND endpoint = ? AND key_id = ? AND status_code = ? AND bucket >= ?", granularity, dims.endpoint, dims.keyID, dims.status, startBucket).
+		Group("bucket").
+		Order("bucket").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]int64, len(rows))
+	for _, r := range rows {
+		out[r.Bucket] = r.Count
+	}
+	return out, nil
+}
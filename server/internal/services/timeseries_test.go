@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tavily-proxy/server/internal/db"
+)
+
+func TestStatsService_TimeSeriesSplitsEndpoints(t *testing.T) {
+	t.Parallel()
+
+	database, err := db.Open(filepath.Join(t.TempDir(), "app.db"))
+	if err != nil {
+		t.Fatalf("db open: %v", err)
+	}
+	sqlDB, err := database.DB()
+	if err != nil {
+		t.Fatalf("db handle: %v", err)
+	}
+	t.Cleanup(func() { _ = sqlDB.Close() })
+
+	ctx := context.Background()
+	stats := NewStatsService(database)
+
+	day := time.Date(2026, 2, 3, 0, 0, 0, 0, time.Local)
+	events := []StatEvent{
+		{Endpoint: "/search", KeyID: 1, StatusCode: 200, LatencyMs: 100, OccurredAt: day.Add(9 * time.Hour)},
+		{Endpoint: "/search", KeyID: 1, StatusCode: 200, LatencyMs: 300, OccurredAt: day.Add(9 * time.Hour)},
+		{Endpoint: "/search", KeyID: 1, StatusCode: 500, LatencyMs: 200, OccurredAt: day.Add(9 * time.Hour)},
+		{Endpoint: "/search", StatusCode: 502, LatencyMs: -1, OccurredAt: day.Add(9 * time.Hour)},
+		{Endpoint: "/extract", KeyID: 1, StatusCode: 200, LatencyMs: 50, OccurredAt: day.Add(10 * time.Hour)},
+		{Endpoint: "/not-tavily", KeyID: 1, StatusCode: 404, LatencyMs: 10, OccurredAt: day.Add(10 * time.Hour)},
+	}
+	if err := stats.RecordBatch(ctx, events); err != nil {
+		t.Fatalf("record stats: %v", err)
+	}
+
+	out, err := stats.TimeSeries(ctx, TimeSeriesQuery{
+		Granularity: "hour",
+		From:        day.Add(8 * time.Hour),
+		To:          day.Add(11 * time.Hour),
+		Endpoints:   []string{TimeSeriesAllEndpoints},
+	})
+	if err != nil {
+		t.Fatalf("timeseries: %v", err)
+	}
+	if len(out.Labels) != 3 || out.Labels[1] != "2026-02-03 09:00" {
+		t.Fatalf("unexpected labels: %v", out.Labels)
+	}
+
+	byEndpoint := make(map[string]TimeSeriesSeries)
+	for _, s := range out.Series {
+		byEndpoint[s.Endpoint] = s
+	}
+	if len(out.Series) != 4 || out.Series[0].Name != "All Requests" {
+		t.Fatalf("unexpected series: %+v", out.Series)
+	}
+	if _, ok := byEndpoint[statEndpointOther]; !ok {
+		t.Fatalf("unknown endpoints should be counted as %q: %+v", statEndpointOther, out.Series)
+	}
+
+	search := byEndpoint["/search"]
+	if search.Data[1] != 4 {
+		t.Fatalf("search requests at 09:00: got %d want %d", search.Data[1], 4)
+	}
+	if search.ErrorRate[1] == nil || *search.ErrorRate[1] != 0.5 {
+		t.Fatalf("search error rate at 09:00: got %v want %v", search.ErrorRate[1], 0.5)
+	}
+	// The failed request never reached Tavily and has no latency.
+	if search.AvgLatencyMs[1] == nil || *search.AvgLatencyMs[1] != 200 {
+		t.Fatalf("search latency at 09:00: got %v want %v", search.AvgLatencyMs[1], 200)
+	}
+	if search.ErrorRate[0] != nil || search.AvgLatencyMs[0] != nil {
+		t.Fatalf("empty bucket should have no rates: %v %v", search.ErrorRate[0], search.AvgLatencyMs[0])
+	}
+
+	if _, err := stats.TimeSeries(ctx, TimeSeriesQuery{Granularity: "hour", From: day.AddDate(-1, 0, 0), To: day}); err != ErrTimeSeriesTooLong {
+		t.Fatalf("long window: got %v want %v", err, ErrTimeSeriesTooLong)
+	}
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LocalLocation returns the server's local timezone under its IANA name, so
+// it can be stored and loaded again, rather than as time.Local whose name is
+// always "Local". The name comes from TZ, the /etc/localtime link or
+// /etc/timezone; a zone without daylight saving time falls back to its fixed
+// Etc/GMT offset. ok is false when no name could be found.
+func LocalLocation() (loc *time.Location, ok bool) {
+	var candidates []string
+	if tz, set := os.LookupEnv("TZ"); set {
+		tz = strings.TrimPrefix(tz, ":")
+		if tz == "" {
+			tz = "UTC"
+		}
+		candidates = append(candidates, tz)
+	}
+	if target, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+		if i := strings.Index(target, "zoneinfo/"); i >= 0 {
+			candidates = append(candidates, target[i+len("zoneinfo/"):])
+		}
+	}
+	if raw, err := os.ReadFile("/etc/timezone"); err == nil {
+		candidates = append(candidates, strings.TrimSpace(string(raw)))
+	}
+	for _, name := range candidates {
+		if name == "" || name == "Local" {
+			continue
+		}
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc, true
+		}
+	}
+
+	// Without a name, a zone that keeps one offset all year is equivalent to
+	// the matching Etc/GMT zone, whose sign is inverted by convention.
+	year := time.Now().Year()
+	_, jan := time.Date(year, 1, 1, 0, 0, 0, 0, time.Local).Zone()
+	_, jul := time.Date(year, 7, 1, 0, 0, 0, 0, time.Local).Zone()
+	if jan == jul && jan%3600 == 0 {
+		if jan == 0 {
+			return time.UTC, true
+		}
+		if loc, err := time.LoadLocation(fmt.Sprintf("Etc/GMT%+d", -jan/3600)); err == nil {
+			return loc, true
+		}
+	}
+	return time.Local, false
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
 	"embed"
+	"flag"
+	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,7 +19,9 @@ import (
 	"tavily-proxy/server/internal/db"
 	"tavily-proxy/server/internal/httpserver"
 	"tavily-proxy/server/internal/jobs"
+	"tavily-proxy/server/internal/metrics"
 	"tavily-proxy/server/internal/services"
+	"tavily-proxy/server/internal/tracing"
 )
 
 //go:embed public
@@ -23,52 +31,112 @@ func main() {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo}))
 	cfg := config.FromEnv()
 
+	if len(os.Args) > 1 && os.Args[1] == "import-logs" {
+		os.Exit(runImportLogs(logger, cfg, os.Args[2:]))
+	}
+
 	database, err := db.Open(cfg.DatabasePath)
 	if err != nil {
 		logger.Error("db open failed", "err", err)
 		os.Exit(1)
 	}
 
-	masterKeyService := services.NewMasterKeyService(database, logger)
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingEndpoint, cfg.TracingSampleRatio)
+	if err != nil {
+		logger.Error("tracing init failed", "err", err)
+		os.Exit(1)
+	}
+
+	eventBus := services.NewEventBus()
+
+	masterKeyService := services.NewMasterKeyService(database, logger).WithEvents(eventBus)
 	if err := masterKeyService.LoadOrCreate(context.Background()); err != nil {
 		logger.Error("master key init failed", "err", err)
 		os.Exit(1)
 	}
 
 	settingsService := services.NewSettingsService(database)
-	keyService := services.NewKeyService(database, logger)
-	logService := services.NewLogService(database, logger)
-	statsService := services.NewStatsService(database)
+	keyService := services.NewKeyService(database, logger).WithSettings(settingsService).WithEvents(eventBus)
+	statsLoc, err := config.Location(cfg.StatsTimezone)
+	if err != nil {
+		logger.Error("invalid STATS_TIMEZONE", "err", err)
+		os.Exit(1)
+	}
+	resetLoc, err := config.Location(cfg.QuotaResetTimezone)
+	if err != nil {
+		logger.Error("invalid QUOTA_RESET_TIMEZONE", "err", err)
+		os.Exit(1)
+	}
+	statsService := services.NewStatsService(database).WithTimezone(statsLoc).WithResetTimezone(resetLoc)
+	logService := services.NewLogService(database, logger).WithTimezone(statsLoc)
+	clientKeyService := services.NewClientKeyService(database, logger).WithResetTimezone(resetLoc)
 
+	if migrated, err := statsService.MigrateTimezone(context.Background(), settingsService); err != nil {
+		logger.Error("stats timezone migration failed", "err", err)
+	} else if migrated {
+		logger.Info("stats buckets moved to new timezone", "timezone", statsLoc.String())
+	}
 	if err := statsService.BackfillFromLogsIfEmpty(context.Background()); err != nil {
 		logger.Error("stats backfill failed", "err", err)
 	}
 
+	responseCache := services.NewResponseCache(database, settingsService)
+
+	appMetrics := metrics.New(services.StatEndpoint)
+	appMetrics.RegisterKeys(keyService.List)
+
+	logWriter := services.NewLogWriter(logService, statsService, services.LogWriterConfig{
+		QueueSize:     cfg.LogQueueSize,
+		BatchSize:     cfg.LogBatchSize,
+		FlushInterval: cfg.LogFlushInterval,
+	}, logger)
+	logWriter.Start()
+	appMetrics.RegisterGaugeFunc("log_writer_queue_depth", "Log and stat entries waiting to be written.", func() float64 {
+		return float64(logWriter.Stats().QueueDepth)
+	})
+	appMetrics.RegisterCounterFunc("log_writer_dropped_total", "Log and stat entries dropped because the queue was full.", func() float64 {
+		return float64(logWriter.Stats().Dropped)
+	})
+
 	tavilyProxy := services.NewTavilyProxy(cfg.TavilyBaseURL, cfg.UpstreamTimeout, keyService, logService, statsService, logger).
-		WithSettings(settingsService)
+		WithSettings(settingsService).
+		WithCache(responseCache).
+		WithLogWriter(logWriter).
+		WithMetrics(appMetrics)
 	quotaSyncService := services.NewQuotaSyncService(keyService, tavilyProxy, logger)
-	quotaSyncJob := services.NewQuotaSyncJobService(keyService, quotaSyncService, logger)
+	quotaSyncJob := services.NewQuotaSyncJobService(keyService, quotaSyncService, logger).WithMetrics(appMetrics)
+
+	notificationService := services.NewNotificationService(database, settingsService, keyService, eventBus, logger)
+	jobMonitor := jobs.NewMonitor()
 
 	srv := httpserver.New(httpserver.Dependencies{
 		Config:           cfg,
 		EmbeddedPublic:   embeddedPublic,
 		MasterKeyService: masterKeyService,
+		ClientKeyService: clientKeyService,
 		SettingsService:  settingsService,
 		KeyService:       keyService,
 		QuotaSyncService: quotaSyncService,
 		QuotaSyncJob:     quotaSyncJob,
 		LogService:       logService,
 		StatsService:     statsService,
+		LogWriter:        logWriter,
+		Metrics:          appMetrics,
+		Jobs:             jobMonitor,
+		Notifications:    notificationService,
 		TavilyProxy:      tavilyProxy,
+		ResponseCache:    responseCache,
 		Logger:           logger,
 	})
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	jobs.StartMonthlyReset(ctx, keyService, logger)
-	jobs.StartAutoQuotaSync(ctx, settingsService, quotaSyncService, logger)
-	jobs.StartLogCleanup(ctx, settingsService, logService, logger)
+	notificationService.Start(ctx)
+	jobs.StartMonthlyReset(ctx, keyService, resetLoc, jobMonitor, logger)
+	jobs.StartAutoQuotaSync(ctx, settingsService, quotaSyncService, eventBus, appMetrics, jobMonitor, logger)
+	jobs.StartLogCleanup(ctx, settingsService, logService, appMetrics, jobMonitor, logger)
+	jobs.StartKeyRevalidation(ctx, quotaSyncService, jobMonitor, logger)
 
 	go func() {
 		logger.Info("server listening", "addr", cfg.ListenAddr)
@@ -81,4 +149,86 @@ func main() {
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	_ = srv.Shutdown(shutdownCtx)
+
+	// Requests have finished; write out whatever they queued. The drain and
+	// the trace flush get their own budgets so slow requests above cannot use
+	// them up.
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelDrain()
+	if err := logWriter.Close(drainCtx); err != nil {
+		logger.Error("log writer drain incomplete", "err", err, "queued", logWriter.Stats().QueueDepth)
+	}
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Error("tracing flush failed", "err", err)
+	}
+}
+
+// runImportLogs implements "tavily-proxy import-logs [-format jsonl|csv] FILE...",
+// loading files written by GET /api/logs/export into this instance's database.
+// "-" reads from stdin. The rows show up in the request log and its filters but
+// not in the dashboard statistics, which only count requests served here.
+func runImportLogs(logger *slog.Logger, cfg config.Config, args []string) int {
+	fs := flag.NewFlagSet("import-logs", flag.ContinueOnError)
+	format := fs.String("format", "", "input format: jsonl or csv (default: from the file extension; .gz is decompressed)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: tavily-proxy import-logs [-format jsonl|csv] FILE[.gz]...")
+		return 2
+	}
+
+	database, err := db.Open(cfg.DatabasePath)
+	if err != nil {
+		logger.Error("db open failed", "err", err)
+		return 1
+	}
+	logService := services.NewLogService(database, logger)
+
+	for _, path := range fs.Args() {
+		name := path
+		compressed := strings.EqualFold(filepath.Ext(name), ".gz")
+		if compressed {
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		fileFormat := *format
+		if fileFormat == "" {
+			fileFormat = services.LogFormatJSONL
+			if strings.EqualFold(filepath.Ext(name), ".csv") {
+				fileFormat = services.LogFormatCSV
+			}
+		}
+
+		var r io.ReadCloser = io.NopCloser(os.Stdin)
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				logger.Error("import-logs: open failed", "path", path, "err", err)
+				return 1
+			}
+			r = f
+		}
+
+		var src io.Reader = r
+		if compressed {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				_ = r.Close()
+				logger.Error("import-logs: gzip open failed", "path", path, "err", err)
+				return 1
+			}
+			src = gz
+		}
+
+		result, err := logService.Import(context.Background(), src, fileFormat)
+		_ = r.Close()
+		if err != nil {
+			logger.Error("import-logs: import failed", "path", path, "imported", result.Imported, "err", err)
+			return 1
+		}
+		logger.Info("import-logs: completed", "path", path, "imported", result.Imported, "skipped", result.Skipped)
+	}
+	return 0
 }
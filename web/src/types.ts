@@ -9,8 +9,16 @@ export type Stats = {
 
 export type TimeSeries = {
   granularity: string
+  from: string
+  to: string
   labels: string[]
-  series: { name: string; data: number[] }[]
+  series: {
+    name: string
+    endpoint: string
+    data: number[]
+    error_rate: (number | null)[]
+    avg_latency_ms: (number | null)[]
+  }[]
 }
 
 export type KeyItem = {